@@ -0,0 +1,22 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the Go bindings of the external backend protocol defined in backend.proto. The bindings
+// are generated by protoc-gen-go and protoc-gen-go-grpc; run "make protos" after changing backend.proto.
+package v1alpha1
+
+// ProtocolVersion is the version of the protocol defined in this package.
+const ProtocolVersion = "v1alpha1"
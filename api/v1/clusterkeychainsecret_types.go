@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Important: Run "make" to regenerate code after modifying this file
+
+const (
+	// ClusterKeychainSecretLabel is set on KeychainSecrets created for a ClusterKeychainSecret, to the name of the
+	// ClusterKeychainSecret.
+	ClusterKeychainSecretLabel = "keychain.aqueduct/cluster-keychain-secret"
+)
+
+// ClusterKeychainSecretSpec defines the desired state of ClusterKeychainSecret
+type ClusterKeychainSecretSpec struct {
+	// NamespaceSelector selects the namespaces the Secret is created in. An empty selector selects every namespace.
+	// +kubebuilder:validation:Required
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	// Template is the spec of the KeychainSecret created in each selected namespace. It is named after the
+	// ClusterKeychainSecret.
+	// +kubebuilder:validation:Required
+	Template KeychainSecretSpec `json:"template"`
+}
+
+// ClusterKeychainSecretNamespaceStatus is the sync status of a ClusterKeychainSecret within one namespace.
+type ClusterKeychainSecretNamespaceStatus struct {
+	// Namespace the Secret is synced to.
+	Namespace string `json:"namespace"`
+	// Ready is the status of the Ready condition of the KeychainSecret in this namespace.
+	Ready corev1.ConditionStatus `json:"ready"`
+	// LastUpdate is the time the Secret in this namespace was last updated.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// Reason is a brief CamelCase string that describes any failure.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable string indicating details about any failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ClusterKeychainSecretStatus defines the observed state of ClusterKeychainSecret
+type ClusterKeychainSecretStatus struct {
+	// Namespaces is the sync status within each selected namespace.
+	// +optional
+	Namespaces []ClusterKeychainSecretNamespaceStatus `json:"namespaces,omitempty"`
+	// Conditions are the latest observations of this ClusterKeychainSecret's state. Ready is True when the Secret is
+	// synced in every selected namespace.
+	// +optional
+	Conditions []KeychainSecretCondition `json:"conditions,omitempty"`
+}
+
+// GetCondition returns the condition of the given type, or nil if there is none.
+func (s *ClusterKeychainSecretStatus) GetCondition(conditionType KeychainSecretConditionType) *KeychainSecretCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or updates the condition of the given type. LastTransitionTime only changes when the status does.
+func (s *ClusterKeychainSecretStatus) SetCondition(conditionType KeychainSecretConditionType, status corev1.ConditionStatus, reason, message string) {
+	setCondition(&s.Conditions, conditionType, status, reason, message)
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// ClusterKeychainSecret is the Schema for the clusterkeychainsecrets API. It fans a Keychain secret out to every
+// namespace matching a selector.
+type ClusterKeychainSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterKeychainSecretSpec   `json:"spec,omitempty"`
+	Status ClusterKeychainSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterKeychainSecretList contains a list of ClusterKeychainSecret
+type ClusterKeychainSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterKeychainSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterKeychainSecret{}, &ClusterKeychainSecretList{})
+}
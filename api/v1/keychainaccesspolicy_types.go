@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Important: Run "make" to regenerate code after modifying this file
+
+// KeychainAccessPolicySpec defines which Keychain secrets a set of namespaces may request or store
+type KeychainAccessPolicySpec struct {
+	// Namespaces lists, by name, namespaces this policy applies to.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects, by label, namespaces this policy applies to.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Rules list the Keychain secrets the namespaces may request or store. A secret is allowed if any rule of any
+	// policy applying to the namespace allows it.
+	// +optional
+	Rules []KeychainAccessRule `json:"rules,omitempty"`
+}
+
+// KeychainAccessRule allows requesting, or storing, Keychain secrets by group and name.
+type KeychainAccessRule struct {
+	// Groups lists glob patterns, as understood by https://golang.org/pkg/path/#Match, of allowed groups. The empty
+	// pattern allows secrets which are not in a group. Any group is allowed if it is empty.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+	// Names lists glob patterns of allowed secret names. Any name is allowed if it is empty.
+	// +optional
+	Names []string `json:"names,omitempty"`
+	// Verbs lists what the rule allows: get, to request secrets, and put, to store them, as KeychainPublications and
+	// KeychainSecrets generating their values do. It defaults to get, so secrets are only stored where a rule says so.
+	// +optional
+	Verbs []AccessVerb `json:"verbs,omitempty"`
+}
+
+// AllowsVerb reports whether the rule allows verb, when its group and name match.
+func (r KeychainAccessRule) AllowsVerb(verb AccessVerb) bool {
+	if len(r.Verbs) == 0 {
+		return verb == AccessGet
+	}
+	for _, v := range r.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessVerb is something a KeychainAccessRule allows doing with Keychain secrets.
+// +kubebuilder:validation:Enum=get;put
+type AccessVerb string
+
+const (
+	// AccessGet allows requesting secrets.
+	AccessGet AccessVerb = "get"
+	// AccessPut allows storing secrets.
+	AccessPut AccessVerb = "put"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// KeychainAccessPolicy is the Schema for the keychainaccesspolicies API. It restricts which Keychain secrets
+// KeychainSecrets in a namespace may request, and which ones the namespace may store. Policies are checked on every
+// sync: once a KeychainSecret requests a secret its namespace may no longer request, the Secret or ConfigMap it synced
+// is deleted, until it is allowed again.
+type KeychainAccessPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KeychainAccessPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// KeychainAccessPolicyList contains a list of KeychainAccessPolicy
+type KeychainAccessPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeychainAccessPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeychainAccessPolicy{}, &KeychainAccessPolicyList{})
+}
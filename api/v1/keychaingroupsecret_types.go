@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Important: Run "make" to regenerate code after modifying this file
+
+const (
+	// KeychainGroupSecretLabel is set on Secrets created for a KeychainGroupSecret, to the name of the
+	// KeychainGroupSecret.
+	KeychainGroupSecretLabel = "keychain.aqueduct/keychain-group-secret"
+)
+
+// KeychainGroupSecretMode determines how the entries of a group are laid out in Secrets.
+// +kubebuilder:validation:Enum=Single;PerEntry
+type KeychainGroupSecretMode string
+
+const (
+	// SingleSecret syncs every entry into one Secret, keyed by entry name.
+	SingleSecret KeychainGroupSecretMode = "Single"
+	// SecretPerEntry syncs each entry into its own Secret, named after the entry.
+	SecretPerEntry KeychainGroupSecretMode = "PerEntry"
+)
+
+// KeychainGroupSecretSpec defines the desired state of KeychainGroupSecret
+type KeychainGroupSecretSpec struct {
+	// Group is the name of the Keychain group to sync.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=150
+	// +kubebuilder:validation:Pattern="^[A-Z0-9_]+$"
+	Group string `json:"group"`
+	// Include lists glob patterns, as understood by https://golang.org/pkg/path/#Match, of entry names to sync. Every
+	// entry is included if it is empty.
+	// +optional
+	Include []string `json:"include,omitempty"`
+	// Exclude lists glob patterns of entry names not to sync. It takes precedence over Include.
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+	// Mode is how entries are laid out in Secrets, either in a Single Secret or in a Secret PerEntry.
+	// +kubebuilder:default=Single
+	// +optional
+	Mode KeychainGroupSecretMode `json:"mode,omitempty"`
+	// SecretName is the name of the Secret in Single mode, or the prefix of Secret names in PerEntry mode. It defaults
+	// to the name of the KeychainGroupSecret, and a prefix of it followed by a dash respectively.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// TTL is how often the group should be re-enumerated and its secrets updated. See KeychainSecretSpec.TTL.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern="^[0-9]+[smh]$"
+	// +kubebuilder:default="24h"
+	// +optional
+	TTL string `json:"ttl,omitempty"`
+}
+
+// KeychainGroupSecretStatus defines the observed state of KeychainGroupSecret
+type KeychainGroupSecretStatus struct {
+	// Entries are the names of the entries synced as of the last update.
+	// +optional
+	Entries []string `json:"entries,omitempty"`
+	// DeniedEntries are the names of entries matching Include and Exclude which were not synced because no
+	// KeychainAccessPolicy allows this namespace to request them.
+	// +optional
+	DeniedEntries []string `json:"deniedEntries,omitempty"`
+	// InvalidEntries are the names of entries matching Include and Exclude which were not synced because they are not
+	// valid Keychain names, or do not make valid Secret names in PerEntry mode.
+	// +optional
+	InvalidEntries []string `json:"invalidEntries,omitempty"`
+	// SecretRefs are references to the Secrets this KeychainGroupSecret created and maintains.
+	// +optional
+	SecretRefs []corev1.SecretReference `json:"secretRefs,omitempty"`
+	// LastUpdate is the time we last synced the group.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// NextRotation is when the controller next plans to sync the group.
+	// +optional
+	NextRotation metav1.Time `json:"nextRotation,omitempty"`
+	// Conditions are the latest observations of this KeychainGroupSecret's state.
+	// +optional
+	Conditions []KeychainSecretCondition `json:"conditions,omitempty"`
+}
+
+// GetCondition returns the condition of the given type, or nil if there is none.
+func (s *KeychainGroupSecretStatus) GetCondition(conditionType KeychainSecretConditionType) *KeychainSecretCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or updates the condition of the given type. LastTransitionTime only changes when the status does.
+func (s *KeychainGroupSecretStatus) SetCondition(conditionType KeychainSecretConditionType, status corev1.ConditionStatus, reason, message string) {
+	setCondition(&s.Conditions, conditionType, status, reason, message)
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// KeychainGroupSecret is the Schema for the keychaingroupsecrets API. It syncs every secret in a Keychain group.
+type KeychainGroupSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeychainGroupSecretSpec   `json:"spec,omitempty"`
+	Status KeychainGroupSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// KeychainGroupSecretList contains a list of KeychainGroupSecret
+type KeychainGroupSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeychainGroupSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeychainGroupSecret{}, &KeychainGroupSecretList{})
+}
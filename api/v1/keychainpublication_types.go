@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Important: Run "make" to regenerate code after modifying this file
+
+// ConflictPolicy determines what happens when a Keychain secret was changed by someone other than the publication.
+// +kubebuilder:validation:Enum=Fail;Overwrite
+type ConflictPolicy string
+
+const (
+	// ConflictFail leaves the Keychain secret as it is and reports the conflict.
+	ConflictFail ConflictPolicy = "Fail"
+	// ConflictOverwrite replaces the Keychain secret with the value of the Secret.
+	ConflictOverwrite ConflictPolicy = "Overwrite"
+)
+
+const (
+	// ConditionConflict is True when a Keychain secret was not published because it was changed by someone else.
+	ConditionConflict KeychainSecretConditionType = "Conflict"
+)
+
+// KeychainPublicationSpec defines the desired state of KeychainPublication
+type KeychainPublicationSpec struct {
+	// SecretName is the name of the Secret, in the KeychainPublication's namespace, to publish.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+	// Group is the Keychain group to publish secrets to.
+	// +kubebuilder:validation:MaxLength=150
+	// +kubebuilder:validation:Pattern="^[A-Z0-9_]*$"
+	// +optional
+	Group string `json:"group,omitempty"`
+	// Keys map keys of the Secret to the names of the Keychain secrets they are published as.
+	// +kubebuilder:validation:MinItems=1
+	Keys []PublicationKey `json:"keys"`
+	// ConflictPolicy is what to do when a Keychain secret was changed since it was last published, or existed before
+	// it was first published: either Fail, leaving it as it is, or Overwrite it.
+	// +kubebuilder:default=Fail
+	// +optional
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+	// TTL is how often the Keychain secrets should be checked for conflicting changes, in addition to whenever the
+	// Secret changes. See KeychainSecretSpec.TTL.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern="^[0-9]+[smh]$"
+	// +kubebuilder:default="24h"
+	// +optional
+	TTL string `json:"ttl,omitempty"`
+}
+
+// PublicationKey maps a key of a Secret to a Keychain secret.
+type PublicationKey struct {
+	// Key is the key of the Secret's data to publish.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+	// Name is the name of the Keychain secret to publish the value as.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=150
+	// +kubebuilder:validation:Pattern="^[A-Z0-9_]+$"
+	Name string `json:"name"`
+}
+
+// KeychainPublicationStatus defines the observed state of KeychainPublication
+type KeychainPublicationStatus struct {
+	// Published describes the Keychain secrets as of the last publication.
+	// +optional
+	Published []PublishedSecret `json:"published,omitempty"`
+	// Conflicts are the names of Keychain secrets which were not published because they were changed by someone
+	// else.
+	// +optional
+	Conflicts []string `json:"conflicts,omitempty"`
+	// LastUpdate is the time we last published the Secret, or checked it was published.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// Conditions are the latest observations of this KeychainPublication's state.
+	// +optional
+	Conditions []KeychainSecretCondition `json:"conditions,omitempty"`
+}
+
+// PublishedSecret describes a Keychain secret a KeychainPublication published.
+type PublishedSecret struct {
+	// Key is the key of the Secret's data which was published.
+	Key string `json:"key"`
+	// Name is the name of the Keychain secret.
+	Name string `json:"name"`
+	// Hash is the SHA-256 hash of the published value, which tells whether the Keychain secret was changed since.
+	Hash string `json:"hash"`
+	// Version is the version the backend assigned to the published value, if it has versions.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// LastPublished is when the value was written to Keychain.
+	// +optional
+	LastPublished metav1.Time `json:"lastPublished,omitempty"`
+}
+
+// GetCondition returns the condition of the given type, or nil if there is none.
+func (s *KeychainPublicationStatus) GetCondition(conditionType KeychainSecretConditionType) *KeychainSecretCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or updates the condition of the given type. LastTransitionTime only changes when the status does.
+func (s *KeychainPublicationStatus) SetCondition(conditionType KeychainSecretConditionType, status corev1.ConditionStatus, reason, message string) {
+	setCondition(&s.Conditions, conditionType, status, reason, message)
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// KeychainPublication is the Schema for the keychainpublications API. It publishes keys of a Secret, e.g. one minted
+// in the cluster, to Keychain.
+type KeychainPublication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeychainPublicationSpec   `json:"spec,omitempty"`
+	Status KeychainPublicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// KeychainPublicationList contains a list of KeychainPublication
+type KeychainPublicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeychainPublication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeychainPublication{}, &KeychainPublicationList{})
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition of the given type, or nil if there is none.
+func (s *KeychainSecretStatus) GetCondition(conditionType KeychainSecretConditionType) *KeychainSecretCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or updates the condition of the given type. LastTransitionTime only changes when the status does.
+func (s *KeychainSecretStatus) SetCondition(conditionType KeychainSecretConditionType, status corev1.ConditionStatus, reason, message string) {
+	setCondition(&s.Conditions, conditionType, status, reason, message)
+}
+
+// IsConditionTrue reports whether the condition of the given type exists and is True.
+func (s *KeychainSecretStatus) IsConditionTrue(conditionType KeychainSecretConditionType) bool {
+	condition := s.GetCondition(conditionType)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+func getCondition(conditions []KeychainSecretCondition, conditionType KeychainSecretConditionType) *KeychainSecretCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func setCondition(conditions *[]KeychainSecretCondition, conditionType KeychainSecretConditionType, status corev1.ConditionStatus, reason, message string) {
+	condition := getCondition(*conditions, conditionType)
+	if condition == nil {
+		*conditions = append(*conditions, KeychainSecretCondition{Type: conditionType})
+		condition = &(*conditions)[len(*conditions)-1]
+	}
+	if condition.Status != status {
+		condition.Status = status
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Reason = reason
+	condition.Message = message
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -50,17 +52,281 @@ type KeychainSecretSpec struct {
 	// +kubebuilder:default="24h"
 	// +optional
 	TTL string `json:"ttl,omitempty"`
+	// Rollout lists workloads which consume the Secret and should be restarted when it is rotated. This is opt-in, and
+	// only needed for workloads which read the Secret once at startup, e.g. via environment variables.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+	// Template renders the Secret's data from this and other Keychain secrets, e.g. to build a connection string. The
+	// Secret contains only the rendered keys if it is set.
+	// +optional
+	Template *SecretTemplate `json:"template,omitempty"`
+	// Type is how the Keychain values are interpreted. TLS values are PEM encoded certificates and a private key, which
+	// are validated and split into the tls.crt, tls.key and ca.crt keys of a kubernetes.io/tls Secret.
+	// +kubebuilder:default=Opaque
+	// +optional
+	Type KeychainSecretType `json:"type,omitempty"`
+	// Target is the object the Keychain values are written to. It defaults to a Secret.
+	// +optional
+	Target *TargetSpec `json:"target,omitempty"`
+	// Versioning writes each version of the data to its own Secret, which is never modified, instead of updating one
+	// Secret in place. Only Secret targets support it.
+	// +optional
+	Versioning *VersioningSpec `json:"versioning,omitempty"`
+	// Version pins the version of the Keychain secret which is fetched, or is "latest". It names a version known to the
+	// backend; see Versioning.ActiveVersion to roll back to a versioned Secret instead.
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:default=latest
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Suspend stops the controller from syncing this KeychainSecret, e.g. during an incident. The existing Secret is
+	// left as is.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// DryRun makes the controller fetch the Keychain values and report what it would change in status.dryRun, without
+	// writing the Secret.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Generate makes the controller generate the Keychain secret, and store it in Keychain, if it does not exist. It
+	// requires a backend which can store secrets.
+	// +optional
+	Generate *GenerateSpec `json:"generate,omitempty"`
+	// Backends lists, by name, the backends to fetch the Keychain secrets from, in order: each one is only tried when
+	// the previous ones are unavailable. They must be among the backends the controller is configured with, whose
+	// order is used if it is empty.
+	// +optional
+	Backends []string `json:"backends,omitempty"`
+}
+
+// GenerateType is a valid value for GenerateSpec.Type
+// +kubebuilder:validation:Enum=Password;RSA;ECDSA;SSH
+type GenerateType string
+
+const (
+	// GeneratePassword generates a random password.
+	GeneratePassword GenerateType = "Password"
+	// GenerateRSA generates a PEM encoded PKCS #8 RSA private key.
+	GenerateRSA GenerateType = "RSA"
+	// GenerateECDSA generates a PEM encoded PKCS #8 ECDSA private key.
+	GenerateECDSA GenerateType = "ECDSA"
+	// GenerateSSH generates an Ed25519 private key in the OpenSSH format.
+	GenerateSSH GenerateType = "SSH"
+)
+
+// PasswordCharset is a valid value for GenerateSpec.Charset
+// +kubebuilder:validation:Enum=Alphanumeric;ASCII
+type PasswordCharset string
+
+const (
+	// CharsetAlphanumeric passwords consist of letters and digits.
+	CharsetAlphanumeric PasswordCharset = "Alphanumeric"
+	// CharsetASCII passwords consist of printable ASCII characters other than space.
+	CharsetASCII PasswordCharset = "ASCII"
+)
+
+// GenerateSpec describes how a Keychain secret is generated.
+type GenerateSpec struct {
+	// Type of the value generated.
+	Type GenerateType `json:"type"`
+	// Length of passwords.
+	// +kubebuilder:validation:Minimum=8
+	// +kubebuilder:validation:Maximum=1024
+	// +kubebuilder:default=32
+	// +optional
+	Length int32 `json:"length,omitempty"`
+	// Charset passwords are drawn from.
+	// +kubebuilder:default=Alphanumeric
+	// +optional
+	Charset PasswordCharset `json:"charset,omitempty"`
+	// Characters passwords are drawn from, instead of Charset.
+	// +kubebuilder:validation:MinLength=2
+	// +optional
+	Characters string `json:"characters,omitempty"`
+	// Bits of RSA keys.
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	// +kubebuilder:default=2048
+	// +optional
+	Bits int32 `json:"bits,omitempty"`
+	// Curve of ECDSA keys.
+	// +kubebuilder:validation:Enum=P256;P384;P521
+	// +kubebuilder:default=P256
+	// +optional
+	Curve string `json:"curve,omitempty"`
+}
+
+// KeychainSecretType is a valid value for KeychainSecretSpec.Type
+// +kubebuilder:validation:Enum=Opaque;TLS
+type KeychainSecretType string
+
+const (
+	// TypeOpaque values are written as they are.
+	TypeOpaque KeychainSecretType = "Opaque"
+	// TypeTLS values are a certificate and private key.
+	TypeTLS KeychainSecretType = "TLS"
+)
+
+// TargetKind is a valid value for TargetSpec.Kind
+// +kubebuilder:validation:Enum=Secret;ConfigMap
+type TargetKind string
+
+const (
+	// TargetSecret writes values to a Secret.
+	TargetSecret TargetKind = "Secret"
+	// TargetConfigMap writes values to a ConfigMap. Only use it for values which are not secret, e.g. CA bundles.
+	TargetConfigMap TargetKind = "ConfigMap"
+)
+
+// TargetSpec describes the object Keychain values are written to.
+type TargetSpec struct {
+	// Kind of the object, either Secret or ConfigMap.
+	// +kubebuilder:default=Secret
+	// +optional
+	Kind TargetKind `json:"kind,omitempty"`
+}
+
+// VersioningSpec configures versioned Secrets.
+type VersioningSpec struct {
+	// Retain is how many previous versions are kept, besides the active one.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=3
+	// +optional
+	Retain int32 `json:"retain,omitempty"`
+	// ActiveVersion rolls back to a retained version, as listed in status.versions, by making it the active one instead
+	// of the latest. The latest version is still fetched and retained.
+	// +kubebuilder:validation:MaxLength=63
+	// +optional
+	ActiveVersion string `json:"activeVersion,omitempty"`
+}
+
+const (
+	// KeychainSecretLabel is set on versioned Secrets to the name of the KeychainSecret which owns them.
+	KeychainSecretLabel = "keychain.aqueduct/keychain-secret"
+	// VersionLabel is set on versioned Secrets to their version.
+	VersionLabel = "keychain.aqueduct/version"
+	// ActiveLabel is set to "true" on the versioned Secret which is currently active, i.e. the stable alias consumers
+	// may select.
+	ActiveLabel = "keychain.aqueduct/active"
+)
+
+// LatestVersion is the Version which follows the latest version of a Keychain secret.
+const LatestVersion = "latest"
+
+// PinnedVersion returns the pinned Version, or an empty string if the KeychainSecret follows the latest version.
+func (s *KeychainSecretSpec) PinnedVersion() string {
+	if s.Version == LatestVersion {
+		return ""
+	}
+	return s.Version
+}
+
+// TargetKind returns the kind of object the KeychainSecret writes to.
+func (s *KeychainSecretSpec) TargetKind() TargetKind {
+	if s.Target == nil || s.Target.Kind == "" {
+		return TargetSecret
+	}
+	return s.Target.Kind
+}
+
+// SecretTemplate renders Secret data from several Keychain secrets.
+type SecretTemplate struct {
+	// Sources lists additional Keychain secrets to fetch. The secret named by the KeychainSecret is always available
+	// under its name.
+	// +optional
+	Sources []KeychainSource `json:"sources,omitempty"`
+	// Data maps each Secret key to a Go template, see https://golang.org/pkg/text/template/. Templates are executed
+	// with a map from source key to value, and may use the functions b64enc, b64dec, json, sha256sum, trim, upper,
+	// lower, pemBlock and pemCerts.
+	// +kubebuilder:validation:MinProperties=1
+	Data map[string]string `json:"data"`
+}
+
+// KeychainSource names a Keychain secret to fetch for a SecretTemplate.
+type KeychainSource struct {
+	// Key is the name the value is available under in templates. It defaults to Name.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Name is the name of the Keychain secret.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=150
+	// +kubebuilder:validation:Pattern="^[A-Z0-9_]+$"
+	Name string `json:"name"`
+	// Group is the name of the Keychain group the secret exists in, if any.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=150
+	// +kubebuilder:validation:Pattern="^[A-Z0-9_]+$"
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// RolloutSpec selects the workloads restarted when a Secret is rotated. Only workloads in the KeychainSecret's
+// namespace are considered.
+type RolloutSpec struct {
+	// Targets names individual workloads to restart.
+	// +optional
+	Targets []RolloutTarget `json:"targets,omitempty"`
+	// Selector selects Deployments, StatefulSets and DaemonSets to restart by label.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 }
 
+// RolloutTarget is a reference to a single workload.
+type RolloutTarget struct {
+	// Kind is the kind of the workload.
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+	Kind string `json:"kind"`
+	// Name is the name of the workload.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+const (
+	// RefreshRequestedAnnotation asks the controller to refresh a KeychainSecret immediately, regardless of its TTL.
+	// Its value is an RFC 3339 timestamp; each new value triggers one refresh.
+	RefreshRequestedAnnotation = "keychain.aqueduct/refresh-requested-at"
+)
+
 // KeychainSecretStatus defines the observed state of KeychainSecret
 type KeychainSecretStatus struct {
-	// SecretRef is a reference to the Secret this KeychainSecret created and maintains.
+	// SecretRef is a reference to the Secret this KeychainSecret created and maintains. With versioning it refers to
+	// the active version.
 	// +optional
 	SecretRef corev1.SecretReference `json:"secretRef,omitempty"`
+	// CurrentVersion is the version of the Keychain secret last synced, if the backend knows it.
+	// +optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// AvailableVersion is the latest version of the Keychain secret as of the last sync, if the backend knows it. It
+	// differs from CurrentVersion while an older version is pinned.
+	// +optional
+	AvailableVersion string `json:"availableVersion,omitempty"`
+	// ActiveVersion is the version of the active Secret, if versioning is enabled.
+	// +optional
+	ActiveVersion string `json:"activeVersion,omitempty"`
+	// Versions are the retained versions, newest first, if versioning is enabled.
+	// +optional
+	Versions []string `json:"versions,omitempty"`
+	// ConfigMapRef is a reference to the ConfigMap this KeychainSecret created and maintains, if its target is a
+	// ConfigMap.
+	// +optional
+	ConfigMapRef *corev1.ObjectReference `json:"configMapRef,omitempty"`
 	// LastUpdate is the time we updated this secret.
 	// It is a fixed, portable, seriallized version of the golang type https://golang.org/pkg/time/#Time
 	// +optional
 	LastUpdate metav1.Time `json:"lastUpdate,omitempty" protobuf:"bytes,3,opt,name=lastUpdate"`
+	// NextRotation is when the controller next plans to refresh this secret. It is the LastUpdate plus the TTL,
+	// adjusted by the controller's jitter, rotation window and rate limit settings.
+	// +optional
+	NextRotation metav1.Time `json:"nextRotation,omitempty"`
+	// Expiry is when the Keychain values synced last expire, if they carry an expiry. They are refreshed ahead of it.
+	// +optional
+	Expiry *metav1.Time `json:"expiry,omitempty"`
+	// Certificate describes the certificate last synced, if the type is TLS.
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+	// ContentHash is a SHA-256 hash of the Secret's, or ConfigMap's, data as of the last update. It is used to detect
+	// changes made outside the controller, and is also the value of the annotation patched into the pod templates of
+	// workloads listed in Rollout.
+	// +optional
+	ContentHash string `json:"contentHash,omitempty"`
 	// Message is human-readable string indicating details about the last update.
 	// +optional
 	Message string `json:"message,omitempty" protobuf:"bytes,6,opt,name=message"`
@@ -68,9 +334,124 @@ type KeychainSecretStatus struct {
 	// for machine parsing and tidy display in the CLI.
 	// +optional
 	Reason string `json:"reason,omitempty" protobuf:"bytes,3,opt,name=reason"`
+	// LastRefreshRequest is the value of the RefreshRequestedAnnotation most recently handled.
+	// +optional
+	LastRefreshRequest string `json:"lastRefreshRequest,omitempty"`
+	// DryRun is the result of the latest dry run, if spec.dryRun is set.
+	// +optional
+	DryRun *DryRunResult `json:"dryRun,omitempty"`
+	// Generated is when the controller generated the Keychain secret and stored it in Keychain, see Spec.Generate.
+	// +optional
+	Generated *metav1.Time `json:"generated,omitempty"`
+	// ServedBy are the names of the backends which served the values as of the last update. It lists a fallback
+	// backend when the preferred one was unavailable.
+	// +optional
+	ServedBy []string `json:"servedBy,omitempty"`
+	// StaleSince is when the backend first failed to refresh the values, which the target keeps serving until it
+	// succeeds. It is unset while the values are fresh.
+	// +optional
+	StaleSince *metav1.Time `json:"staleSince,omitempty"`
+	// Conditions are the latest observations of this KeychainSecret's state.
+	// +optional
+	Conditions []KeychainSecretCondition `json:"conditions,omitempty"`
+}
+
+// CertificateStatus describes a certificate.
+type CertificateStatus struct {
+	// Subject is the certificate's subject distinguished name.
+	Subject string `json:"subject"`
+	// Issuer is the issuer's distinguished name.
+	Issuer string `json:"issuer"`
+	// SANs are the certificate's subject alternative names: DNS names, IP addresses, email addresses and URIs.
+	// +optional
+	SANs []string `json:"sans,omitempty"`
+	// SerialNumber is the certificate's serial number, in decimal.
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// NotBefore is when the certificate becomes valid.
+	NotBefore metav1.Time `json:"notBefore"`
+	// NotAfter is when the certificate expires.
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
+// DryRunResult describes what the controller would change if spec.dryRun were unset. It never contains values.
+type DryRunResult struct {
+	// ObservedGeneration is the generation of the spec the dry run was made with.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Time is when the Keychain values were fetched.
+	// +optional
+	Time metav1.Time `json:"time,omitempty"`
+	// Keys are the keys the target would contain.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+	// AddedKeys are keys which the target does not contain yet.
+	// +optional
+	AddedKeys []string `json:"addedKeys,omitempty"`
+	// ChangedKeys are keys whose values would change.
+	// +optional
+	ChangedKeys []string `json:"changedKeys,omitempty"`
+	// RemovedKeys are keys which would be removed from the target.
+	// +optional
+	RemovedKeys []string `json:"removedKeys,omitempty"`
+	// ContentHash is the hash of the data which would be written.
+	// +optional
+	ContentHash string `json:"contentHash,omitempty"`
+	// CurrentContentHash is the hash of the target's current data, if it exists.
+	// +optional
+	CurrentContentHash string `json:"currentContentHash,omitempty"`
+}
+
+// ExpiryTime returns the Expiry, or the zero time if the values do not expire.
+func (s *KeychainSecretStatus) ExpiryTime() time.Time {
+	if s.Expiry == nil {
+		return time.Time{}
+	}
+	return s.Expiry.Time
+}
+
+// KeychainSecretConditionType is a valid value for KeychainSecretCondition.Type
+type KeychainSecretConditionType string
+
+const (
+	// ConditionReady is True when the Secret has been synced from Keychain.
+	ConditionReady KeychainSecretConditionType = "Ready"
+	// ConditionExpiringSoon is True when the synced values are due to be refreshed ahead of their expiry, or have
+	// expired, and have not been refreshed yet.
+	ConditionExpiringSoon KeychainSecretConditionType = "ExpiringSoon"
+	// ConditionSuspended is True while spec.suspend is set.
+	ConditionSuspended KeychainSecretConditionType = "Suspended"
+	// ConditionStale is True while the target serves values which the backend failed to refresh because it was
+	// unavailable or timed out. Ready only becomes False once they have been stale for longer than the controller's
+	// grace period. Other failures, e.g. the secret no longer existing, make Ready False immediately.
+	ConditionStale KeychainSecretConditionType = "Stale"
+	// ConditionBackendUnavailable is True when the values could not be fetched because the backend failed repeatedly,
+	// and requests to it are rejected until it recovers.
+	ConditionBackendUnavailable KeychainSecretConditionType = "BackendUnavailable"
+	// ConditionVersionUnknown is True when the values were synced, but their version could not be looked up. The
+	// current and available versions in status are those last known.
+	ConditionVersionUnknown KeychainSecretConditionType = "VersionUnknown"
+)
+
+// KeychainSecretCondition describes the state of a KeychainSecret at a certain point.
+type KeychainSecretCondition struct {
+	// Type of condition.
+	Type KeychainSecretConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase string that describes the last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable string indicating details about the last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // KeychainSecret is the Schema for the keychainsecrets API
 type KeychainSecret struct {
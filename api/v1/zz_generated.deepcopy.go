@@ -21,15 +21,573 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateStatus) DeepCopyInto(out *CertificateStatus) {
+	*out = *in
+	if in.SANs != nil {
+		in, out := &in.SANs, &out.SANs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.NotBefore.DeepCopyInto(&out.NotBefore)
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateStatus.
+func (in *CertificateStatus) DeepCopy() *CertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKeychainSecret) DeepCopyInto(out *ClusterKeychainSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKeychainSecret.
+func (in *ClusterKeychainSecret) DeepCopy() *ClusterKeychainSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKeychainSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterKeychainSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKeychainSecretList) DeepCopyInto(out *ClusterKeychainSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterKeychainSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKeychainSecretList.
+func (in *ClusterKeychainSecretList) DeepCopy() *ClusterKeychainSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKeychainSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterKeychainSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKeychainSecretNamespaceStatus) DeepCopyInto(out *ClusterKeychainSecretNamespaceStatus) {
+	*out = *in
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKeychainSecretNamespaceStatus.
+func (in *ClusterKeychainSecretNamespaceStatus) DeepCopy() *ClusterKeychainSecretNamespaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKeychainSecretNamespaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKeychainSecretSpec) DeepCopyInto(out *ClusterKeychainSecretSpec) {
+	*out = *in
+	in.NamespaceSelector.DeepCopyInto(&out.NamespaceSelector)
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKeychainSecretSpec.
+func (in *ClusterKeychainSecretSpec) DeepCopy() *ClusterKeychainSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKeychainSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKeychainSecretStatus) DeepCopyInto(out *ClusterKeychainSecretStatus) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]ClusterKeychainSecretNamespaceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]KeychainSecretCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKeychainSecretStatus.
+func (in *ClusterKeychainSecretStatus) DeepCopy() *ClusterKeychainSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKeychainSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DryRunResult) DeepCopyInto(out *DryRunResult) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AddedKeys != nil {
+		in, out := &in.AddedKeys, &out.AddedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ChangedKeys != nil {
+		in, out := &in.ChangedKeys, &out.ChangedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedKeys != nil {
+		in, out := &in.RemovedKeys, &out.RemovedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DryRunResult.
+func (in *DryRunResult) DeepCopy() *DryRunResult {
+	if in == nil {
+		return nil
+	}
+	out := new(DryRunResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GenerateSpec) DeepCopyInto(out *GenerateSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GenerateSpec.
+func (in *GenerateSpec) DeepCopy() *GenerateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GenerateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainAccessPolicy) DeepCopyInto(out *KeychainAccessPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainAccessPolicy.
+func (in *KeychainAccessPolicy) DeepCopy() *KeychainAccessPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainAccessPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainAccessPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainAccessPolicyList) DeepCopyInto(out *KeychainAccessPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KeychainAccessPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainAccessPolicyList.
+func (in *KeychainAccessPolicyList) DeepCopy() *KeychainAccessPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainAccessPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainAccessPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainAccessPolicySpec) DeepCopyInto(out *KeychainAccessPolicySpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]KeychainAccessRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainAccessPolicySpec.
+func (in *KeychainAccessPolicySpec) DeepCopy() *KeychainAccessPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainAccessPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainAccessRule) DeepCopyInto(out *KeychainAccessRule) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Verbs != nil {
+		in, out := &in.Verbs, &out.Verbs
+		*out = make([]AccessVerb, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainAccessRule.
+func (in *KeychainAccessRule) DeepCopy() *KeychainAccessRule {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainAccessRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainGroupSecret) DeepCopyInto(out *KeychainGroupSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainGroupSecret.
+func (in *KeychainGroupSecret) DeepCopy() *KeychainGroupSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainGroupSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainGroupSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainGroupSecretList) DeepCopyInto(out *KeychainGroupSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KeychainGroupSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainGroupSecretList.
+func (in *KeychainGroupSecretList) DeepCopy() *KeychainGroupSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainGroupSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainGroupSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainGroupSecretSpec) DeepCopyInto(out *KeychainGroupSecretSpec) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainGroupSecretSpec.
+func (in *KeychainGroupSecretSpec) DeepCopy() *KeychainGroupSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainGroupSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainGroupSecretStatus) DeepCopyInto(out *KeychainGroupSecretStatus) {
+	*out = *in
+	if in.Entries != nil {
+		in, out := &in.Entries, &out.Entries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedEntries != nil {
+		in, out := &in.DeniedEntries, &out.DeniedEntries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InvalidEntries != nil {
+		in, out := &in.InvalidEntries, &out.InvalidEntries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]corev1.SecretReference, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	in.NextRotation.DeepCopyInto(&out.NextRotation)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]KeychainSecretCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainGroupSecretStatus.
+func (in *KeychainGroupSecretStatus) DeepCopy() *KeychainGroupSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainGroupSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainPublication) DeepCopyInto(out *KeychainPublication) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainPublication.
+func (in *KeychainPublication) DeepCopy() *KeychainPublication {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainPublication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainPublication) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainPublicationList) DeepCopyInto(out *KeychainPublicationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KeychainPublication, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainPublicationList.
+func (in *KeychainPublicationList) DeepCopy() *KeychainPublicationList {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainPublicationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeychainPublicationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainPublicationSpec) DeepCopyInto(out *KeychainPublicationSpec) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]PublicationKey, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainPublicationSpec.
+func (in *KeychainPublicationSpec) DeepCopy() *KeychainPublicationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainPublicationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainPublicationStatus) DeepCopyInto(out *KeychainPublicationStatus) {
+	*out = *in
+	if in.Published != nil {
+		in, out := &in.Published, &out.Published
+		*out = make([]PublishedSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]KeychainSecretCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainPublicationStatus.
+func (in *KeychainPublicationStatus) DeepCopy() *KeychainPublicationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainPublicationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KeychainSecret) DeepCopyInto(out *KeychainSecret) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -51,6 +609,22 @@ func (in *KeychainSecret) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainSecretCondition) DeepCopyInto(out *KeychainSecretCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainSecretCondition.
+func (in *KeychainSecretCondition) DeepCopy() *KeychainSecretCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainSecretCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KeychainSecretList) DeepCopyInto(out *KeychainSecretList) {
 	*out = *in
@@ -86,6 +660,36 @@ func (in *KeychainSecretList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KeychainSecretSpec) DeepCopyInto(out *KeychainSecretSpec) {
 	*out = *in
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(SecretTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(TargetSpec)
+		**out = **in
+	}
+	if in.Versioning != nil {
+		in, out := &in.Versioning, &out.Versioning
+		*out = new(VersioningSpec)
+		**out = **in
+	}
+	if in.Generate != nil {
+		in, out := &in.Generate, &out.Generate
+		*out = new(GenerateSpec)
+		**out = **in
+	}
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainSecretSpec.
@@ -102,7 +706,52 @@ func (in *KeychainSecretSpec) DeepCopy() *KeychainSecretSpec {
 func (in *KeychainSecretStatus) DeepCopyInto(out *KeychainSecretStatus) {
 	*out = *in
 	out.SecretRef = in.SecretRef
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(corev1.ObjectReference)
+		**out = **in
+	}
 	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	in.NextRotation.DeepCopyInto(&out.NextRotation)
+	if in.Expiry != nil {
+		in, out := &in.Expiry, &out.Expiry
+		*out = (*in).DeepCopy()
+	}
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(DryRunResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Generated != nil {
+		in, out := &in.Generated, &out.Generated
+		*out = (*in).DeepCopy()
+	}
+	if in.ServedBy != nil {
+		in, out := &in.ServedBy, &out.ServedBy
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StaleSince != nil {
+		in, out := &in.StaleSince, &out.StaleSince
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]KeychainSecretCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainSecretStatus.
@@ -114,3 +763,146 @@ func (in *KeychainSecretStatus) DeepCopy() *KeychainSecretStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeychainSource) DeepCopyInto(out *KeychainSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeychainSource.
+func (in *KeychainSource) DeepCopy() *KeychainSource {
+	if in == nil {
+		return nil
+	}
+	out := new(KeychainSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PublicationKey) DeepCopyInto(out *PublicationKey) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PublicationKey.
+func (in *PublicationKey) DeepCopy() *PublicationKey {
+	if in == nil {
+		return nil
+	}
+	out := new(PublicationKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PublishedSecret) DeepCopyInto(out *PublishedSecret) {
+	*out = *in
+	in.LastPublished.DeepCopyInto(&out.LastPublished)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PublishedSecret.
+func (in *PublishedSecret) DeepCopy() *PublishedSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(PublishedSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutSpec) DeepCopyInto(out *RolloutSpec) {
+	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]RolloutTarget, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutSpec.
+func (in *RolloutSpec) DeepCopy() *RolloutSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutTarget) DeepCopyInto(out *RolloutTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutTarget.
+func (in *RolloutTarget) DeepCopy() *RolloutTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTemplate) DeepCopyInto(out *SecretTemplate) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]KeychainSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplate.
+func (in *SecretTemplate) DeepCopy() *SecretTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetSpec) DeepCopyInto(out *TargetSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetSpec.
+func (in *TargetSpec) DeepCopy() *TargetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VersioningSpec) DeepCopyInto(out *VersioningSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VersioningSpec.
+func (in *VersioningSpec) DeepCopy() *VersioningSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VersioningSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// keychain-backend-command is the reference backend plugin. It serves the Backend service of api/backend/v1alpha1 on a
+// Unix socket, by running the same *_COMMAND templates the controller's command backend does, and is meant to run as a
+// sidecar of the manager started with --backend=grpc.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"google.golang.org/grpc"
+
+	backendv1alpha1 "github.com/davidewatson/keychain/api/backend/v1alpha1"
+	"github.com/davidewatson/keychain/controllers"
+)
+
+func main() {
+	// We use our own FlagSet since controller-runtime registers flags, e.g. kubeconfig, on the default one.
+	flags := flag.NewFlagSet("keychain-backend-command", flag.ExitOnError)
+	socket := flags.String("socket", "/var/run/keychain/backend.sock", "Unix socket to listen on.")
+	flags.Parse(os.Args[1:])
+
+	if os.Getenv(controllers.GetSecretCommandEnv) == "" {
+		fmt.Fprintf(os.Stderr, "%s is not set\n", controllers.GetSecretCommandEnv)
+		os.Exit(1)
+	}
+
+	// Remove the socket left behind by a previous run, if any.
+	if err := os.Remove(*socket); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	server := grpc.NewServer()
+	backendv1alpha1.RegisterBackendServer(server, controllers.NewBackendServer(controllers.CommandBackend{}))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.GracefulStop()
+	}()
+
+	if err := server.Serve(listener); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// keychainctl renders, and optionally runs, the controller's command templates for KeychainSecret manifests without
+// deploying the controller. It reads the same environment variables as the controller, or loads them from the
+// manager's Deployment manifest.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	"github.com/davidewatson/keychain/controllers"
+)
+
+const (
+	managerContainerName = "manager"
+)
+
+func main() {
+	// We use our own FlagSet since controller-runtime registers flags, e.g. kubeconfig, on the default one.
+	flags := flag.NewFlagSet("keychainctl", flag.ExitOnError)
+	filename := flags.String("f", "", "KeychainSecret manifest to render, - for stdin.")
+	managerManifest := flags.String("manager-manifest", "",
+		"Deployment manifest to load the controller's environment from, e.g. config/manager/manager.yaml. "+
+			"The current environment is used if empty.")
+	execute := flags.Bool("execute", false, "Run the Keychain command locally and print the resulting Secret.")
+	showValues := flags.Bool("show-values", false, "Print Secret values instead of redacting them.")
+	flags.Parse(os.Args[1:])
+
+	if *filename == "" {
+		fmt.Fprintf(os.Stderr, "Usage: keychainctl -f FILE [--manager-manifest FILE] [--execute] [--show-values]\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err := run(*filename, *managerManifest, *execute, *showValues); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(filename, managerManifest string, execute, showValues bool) error {
+	if managerManifest != "" {
+		if err := loadManagerEnv(managerManifest); err != nil {
+			return err
+		}
+	}
+
+	keychainSecrets, err := readKeychainSecrets(filename)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for i, keychainSecret := range keychainSecrets {
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Printf("# keychainsecret/%s\n", keychainSecret.Name)
+
+		identityCommand, err := controllers.RenderProvisionServiceIdentity(controllers.IdentityParams(keychainSecret.Namespace))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# %s: %s\n", controllers.GenerateCertCommandEnv, formatCommand(identityCommand))
+
+		for _, requested := range controllers.RequestedSecrets(keychainSecret) {
+			getCommand, err := controllers.RenderGetKeychainSecret(requested.Params)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s (%s): %s\n", controllers.GetSecretCommandEnv, requested.Key, formatCommand(getCommand))
+		}
+		if os.Getenv(controllers.GetSecretVersionCommandEnv) != "" {
+			versionCommand, err := controllers.RenderGetKeychainSecretVersion(controllers.GetKeychainSecretParams{
+				Name: keychainSecret.Spec.Name, Group: keychainSecret.Spec.Group})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s: %s\n", controllers.GetSecretVersionCommandEnv, formatCommand(versionCommand))
+		}
+		if keychainSecret.Spec.Generate != nil && os.Getenv(controllers.PutSecretCommandEnv) != "" {
+			putCommand, err := controllers.RenderPutKeychainSecret(controllers.PutKeychainSecretParams{
+				Name: keychainSecret.Spec.Name, Group: keychainSecret.Spec.Group})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s (if missing): %s\n", controllers.PutSecretCommandEnv, formatCommand(putCommand))
+		}
+
+		if !execute {
+			continue
+		}
+		data, err := controllers.BuildSecretData(ctx, controllers.CommandBackend{}, keychainSecret)
+		if err != nil {
+			return err
+		}
+		if err := printSecret(os.Stdout, controllers.NewSecret(keychainSecret, data), showValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadManagerEnv sets the literal environment variables of the manager container in a Deployment manifest.
+func loadManagerEnv(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var deployment appsv1.Deployment
+		if err := decoder.Decode(&deployment); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if deployment.Kind != "Deployment" {
+			continue
+		}
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			if container.Name != managerContainerName {
+				continue
+			}
+			for _, env := range container.Env {
+				// Values from the downward API, Secrets etc. can't be resolved offline.
+				if env.ValueFrom == nil {
+					os.Setenv(env.Name, env.Value)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no %q container found in a Deployment in %s", managerContainerName, filename)
+}
+
+// readKeychainSecrets reads every KeychainSecret in a, possibly multi-document, manifest.
+func readKeychainSecrets(filename string) ([]aqueductv1.KeychainSecret, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var keychainSecrets []aqueductv1.KeychainSecret
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var keychainSecret aqueductv1.KeychainSecret
+		if err := decoder.Decode(&keychainSecret); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		gvk := schema.FromAPIVersionAndKind(keychainSecret.APIVersion, keychainSecret.Kind)
+		if gvk.GroupKind() != aqueductv1.GroupVersion.WithKind("KeychainSecret").GroupKind() {
+			continue
+		}
+		if keychainSecret.Namespace == "" {
+			keychainSecret.Namespace = corev1.NamespaceDefault
+		}
+		keychainSecrets = append(keychainSecrets, keychainSecret)
+	}
+	if len(keychainSecrets) == 0 {
+		return nil, fmt.Errorf("no KeychainSecrets found in %s", filename)
+	}
+	return keychainSecrets, nil
+}
+
+// formatCommand returns command as it would be typed in a shell, without quoting.
+func formatCommand(command controllers.Command) string {
+	return strings.Join(append([]string{command.Command}, command.Args...), " ")
+}
+
+// printSecret writes secret as YAML. Unless showValues is set, values are replaced by their length. Not even a hash is
+// printed, since short or well-known values could be recovered from it.
+func printSecret(w io.Writer, secret *corev1.Secret, showValues bool) error {
+	secret.TypeMeta.APIVersion = "v1"
+	secret.TypeMeta.Kind = "Secret"
+	if !showValues {
+		secret.StringData = map[string]string{}
+		for key, value := range secret.Data {
+			secret.StringData[key] = fmt.Sprintf("<redacted: %d bytes>", len(value))
+		}
+		secret.Data = nil
+	}
+
+	out, err := yaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
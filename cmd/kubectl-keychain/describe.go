@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// describe prints a KeychainSecret's spec, status and identity in a human readable form.
+func describe(args []string) error {
+	flags := flag.NewFlagSet("describe", flag.ExitOnError)
+	names, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("describe takes exactly one KeychainSecret name")
+	}
+
+	c, ns, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: names[0]}, &keychainSecret); err != nil {
+		return err
+	}
+	identity, identityErr := getIdentityCertificate(ctx, c, ns)
+	return printDescription(os.Stdout, keychainSecret, identity, identityErr)
+}
+
+// printDescription writes a KeychainSecret, along with the identity of its namespace or why it is unknown.
+func printDescription(out io.Writer, keychainSecret aqueductv1.KeychainSecret, identity *x509.Certificate, identityErr error) error {
+	spec, status := keychainSecret.Spec, keychainSecret.Status
+
+	w := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", keychainSecret.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", keychainSecret.Namespace)
+	fmt.Fprintf(w, "Age:\t%s\n", age(keychainSecret.CreationTimestamp))
+	fmt.Fprintf(w, "Keychain Name:\t%s\n", spec.Name)
+	fmt.Fprintf(w, "Keychain Group:\t%s\n", valueOrNone(spec.Group))
+	fmt.Fprintf(w, "TTL:\t%s\n", spec.TTL)
+	fmt.Fprintf(w, "Type:\t%s\n", valueOrNone(string(spec.Type)))
+	fmt.Fprintf(w, "Target Kind:\t%s\n", spec.TargetKind())
+	fmt.Fprintf(w, "Suspend:\t%v\n", spec.Suspend)
+	fmt.Fprintf(w, "Dry Run:\t%v\n", spec.DryRun)
+	if spec.Generate != nil {
+		fmt.Fprintf(w, "Generate:\t%s\n", spec.Generate.Type)
+	}
+	if status.Generated != nil {
+		fmt.Fprintf(w, "Generated:\t%s\n", formatTime(status.Generated.Time))
+	}
+	fmt.Fprintf(w, "Secret:\t%s\n", valueOrNone(status.SecretRef.Name))
+	fmt.Fprintf(w, "Version:\t%s\n", valueOrNone(spec.Version))
+	fmt.Fprintf(w, "Current Version:\t%s\n", valueOrNone(status.CurrentVersion))
+	fmt.Fprintf(w, "Available Version:\t%s\n", valueOrNone(status.AvailableVersion))
+	if status.ActiveVersion != "" {
+		fmt.Fprintf(w, "Active Version:\t%s\n", status.ActiveVersion)
+		fmt.Fprintf(w, "Versions:\t%s\n", strings.Join(status.Versions, ","))
+	}
+	if status.ConfigMapRef != nil {
+		fmt.Fprintf(w, "ConfigMap:\t%s\n", status.ConfigMapRef.Name)
+	}
+	if len(spec.Backends) > 0 {
+		fmt.Fprintf(w, "Backends:\t%s\n", strings.Join(spec.Backends, ","))
+	}
+	fmt.Fprintf(w, "Served By:\t%s\n", valueOrNone(strings.Join(status.ServedBy, ",")))
+	if status.StaleSince != nil {
+		fmt.Fprintf(w, "Stale Since:\t%s (%s)\n", formatTime(status.StaleSince.Time), age(*status.StaleSince))
+	}
+	fmt.Fprintf(w, "Last Update:\t%s\n", formatTime(status.LastUpdate.Time))
+	fmt.Fprintf(w, "Next Rotation:\t%s\n", untilString(status.NextRotation))
+	if cert := status.Certificate; cert != nil {
+		fmt.Fprintf(w, "Certificate:\n")
+		fmt.Fprintf(w, "  Subject:\t%s\n", cert.Subject)
+		fmt.Fprintf(w, "  Issuer:\t%s\n", cert.Issuer)
+		fmt.Fprintf(w, "  SANs:\t%s\n", valueOrNone(strings.Join(cert.SANs, ",")))
+		fmt.Fprintf(w, "  Not After:\t%s (%s)\n", formatTime(cert.NotAfter.Time), untilString(cert.NotAfter))
+	}
+	if status.Expiry != nil {
+		fmt.Fprintf(w, "Expiry:\t%s (%s)\n", formatTime(status.Expiry.Time), untilString(*status.Expiry))
+	}
+	fmt.Fprintf(w, "Content Hash:\t%s\n", valueOrNone(status.ContentHash))
+	fmt.Fprintf(w, "Last Refresh Request:\t%s\n", valueOrNone(status.LastRefreshRequest))
+	if result := status.DryRun; result != nil {
+		fmt.Fprintf(w, "Dry Run Result:\n")
+		fmt.Fprintf(w, "  Time:\t%s\n", formatTime(result.Time.Time))
+		fmt.Fprintf(w, "  Keys:\t%s\n", valueOrNone(strings.Join(result.Keys, ",")))
+		fmt.Fprintf(w, "  Added:\t%s\n", valueOrNone(strings.Join(result.AddedKeys, ",")))
+		fmt.Fprintf(w, "  Changed:\t%s\n", valueOrNone(strings.Join(result.ChangedKeys, ",")))
+		fmt.Fprintf(w, "  Removed:\t%s\n", valueOrNone(strings.Join(result.RemovedKeys, ",")))
+		fmt.Fprintf(w, "  Content Hash:\t%s\n", valueOrNone(result.ContentHash))
+		fmt.Fprintf(w, "  Current Content Hash:\t%s\n", valueOrNone(result.CurrentContentHash))
+	}
+	fmt.Fprintf(w, "Conditions:\n")
+	fmt.Fprintf(w, "  Type\tStatus\tLastTransitionTime\tReason\tMessage\n")
+	for _, condition := range status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status,
+			formatTime(condition.LastTransitionTime.Time), condition.Reason, condition.Message)
+	}
+	fmt.Fprintf(w, "Identity:\n")
+	if identityErr != nil {
+		fmt.Fprintf(w, "  <unknown>\t%v\n", identityErr)
+	} else {
+		fmt.Fprintf(w, "  Subject:\t%s\n", identity.Subject)
+		fmt.Fprintf(w, "  Not After:\t%s\n", formatTime(identity.NotAfter))
+	}
+	return w.Flush()
+}
+
+// valueOrNone returns s, or <none> if it is empty.
+func valueOrNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// formatTime returns t in RFC 3339, or <none> if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "<none>"
+	}
+	return t.Format(time.RFC3339)
+}
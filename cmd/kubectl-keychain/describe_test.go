@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+func TestPrintDescription(t *testing.T) {
+	lastUpdate := metav1.NewTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	identity := &x509.Certificate{Subject: pkix.Name{CommonName: "default"}, NotAfter: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	synced := aqueductv1.KeychainSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", Group: "DATABASE", TTL: "24h"},
+		Status: aqueductv1.KeychainSecretStatus{
+			SecretRef:  corev1.SecretReference{Namespace: "default", Name: "DB_PASSWORD"},
+			LastUpdate: lastUpdate,
+			Conditions: []aqueductv1.KeychainSecretCondition{{Type: aqueductv1.ConditionReady, Status: corev1.ConditionTrue,
+				LastTransitionTime: lastUpdate, Reason: "Synced", Message: "Secret synced from Keychain"}},
+		},
+	}
+	versioned := synced
+	versioned.Status.ActiveVersion = "1a2b3c"
+	versioned.Status.Versions = []string{"1a2b3c", "4d5e6f"}
+
+	var testsTable = []struct {
+		name           string
+		keychain       aqueductv1.KeychainSecret
+		identityErr    error
+		contains       []string
+		doesNotContain []string
+	}{
+		{name: "synced", keychain: synced, contains: []string{
+			"Name:                 db\n",
+			"Keychain Name:        DB_PASSWORD\n",
+			"Keychain Group:       DATABASE\n",
+			"Secret:               DB_PASSWORD\n",
+			"Last Update:          2020-01-02T03:04:05Z\n",
+			"Content Hash:         <none>\n",
+			"  Ready True   2020-01-02T03:04:05Z Synced Secret synced from Keychain\n",
+			"  Subject:   CN=default\n",
+			"  Not After: 2021-01-02T03:04:05Z\n",
+		}, doesNotContain: []string{"Active Version:", "Dry Run Result:", "Certificate:"}},
+		{name: "versions are listed once versioned", keychain: versioned, contains: []string{
+			"Active Version:       1a2b3c\n",
+			"Versions:             1a2b3c,4d5e6f\n",
+		}},
+		{name: "unknown identity", keychain: synced, identityErr: errors.New(`secrets "default" not found`), contains: []string{
+			"  <unknown> secrets \"default\" not found\n",
+		}, doesNotContain: []string{"CN=default"}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			var cert *x509.Certificate
+			if tt.identityErr == nil {
+				cert = identity
+			}
+			if err := printDescription(&out, tt.keychain, cert, tt.identityErr); err != nil {
+				t.Fatalf("printDescription failed: %v", err)
+			}
+			for _, line := range tt.contains {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("Output does not contain %q:\n%s", line, out.String())
+				}
+			}
+			for _, s := range tt.doesNotContain {
+				if strings.Contains(out.String(), s) {
+					t.Errorf("Output contains %q:\n%s", s, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	"github.com/davidewatson/keychain/controllers"
+)
+
+// diff compares a KeychainSecret's target with its values. By default the values are those the controller last synced,
+// as recorded by their hash in the KeychainSecret's status. That detects targets modified outside the controller, i.e.
+// drift, but not values changed in Keychain since the last sync. With --fetch the values are fetched from Keychain
+// instead, by the Keychain commands of the environment as keychainctl runs them. Neither the values nor the target's
+// data are printed.
+func diff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	fetch := flags.Bool("fetch", false, "Fetch the current values from Keychain, instead of comparing with the values last synced.")
+	names, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("diff takes exactly one KeychainSecret name")
+	}
+
+	c, ns, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: names[0]}, &keychainSecret); err != nil {
+		return err
+	}
+	var backend controllers.Backend
+	if *fetch {
+		backend = controllers.CommandBackend{}
+	}
+	return diffTarget(ctx, os.Stdout, c, keychainSecret, backend)
+}
+
+// diffTarget writes the hash of the KeychainSecret's values, fetched from backend or, if it is nil, last synced by the
+// controller, and the hash of its target's data. It returns an error if they differ.
+func diffTarget(ctx context.Context, w io.Writer, c client.Client, keychainSecret aqueductv1.KeychainSecret, backend controllers.Backend) error {
+	source, valuesHash := "Last synced", keychainSecret.Status.ContentHash
+	if backend != nil {
+		data, err := fetchTargetData(ctx, backend, keychainSecret)
+		if err != nil {
+			return err
+		}
+		source, valuesHash = "Keychain", controllers.HashSecretData(data)
+	} else if valuesHash == "" {
+		return fmt.Errorf("keychainsecret/%s has not been synced yet", keychainSecret.Name)
+	}
+
+	kind, name, targetHash, err := targetHash(ctx, c, keychainSecret)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%-13s %s\n", source+":", valuesHash)
+	fmt.Fprintf(w, "%-13s %s\n", "Target:", targetHash)
+	switch {
+	case valuesHash != targetHash && backend == nil:
+		return fmt.Errorf("%s/%s was modified since the controller last synced it", kind, name)
+	case valuesHash != targetHash:
+		return fmt.Errorf("%s/%s differs from the values in Keychain", kind, name)
+	case backend == nil:
+		fmt.Fprintln(w, "no drift since the last sync, Keychain was not consulted (use --fetch to compare with it)")
+	default:
+		fmt.Fprintln(w, "in sync with Keychain")
+	}
+	return nil
+}
+
+// fetchTargetData fetches the KeychainSecret's values from backend, and returns the data the controller would write
+// to its target.
+func fetchTargetData(ctx context.Context, backend controllers.Backend, keychainSecret aqueductv1.KeychainSecret) (map[string][]byte, error) {
+	data, err := controllers.BuildSecretData(ctx, backend, keychainSecret)
+	if err != nil {
+		return nil, err
+	}
+	if keychainSecret.Spec.Type == aqueductv1.TypeTLS {
+		data, _, err = controllers.BuildTLSData(data, time.Now())
+	}
+	return data, err
+}
+
+// targetHash returns the kind and name of the KeychainSecret's target, either a Secret or, if configured, a
+// ConfigMap, and the hash of its data.
+func targetHash(ctx context.Context, c client.Client, keychainSecret aqueductv1.KeychainSecret) (string, string, string, error) {
+	if configMapRef := keychainSecret.Status.ConfigMapRef; configMapRef != nil {
+		var configMap corev1.ConfigMap
+		if err := c.Get(ctx, client.ObjectKey{Namespace: configMapRef.Namespace, Name: configMapRef.Name}, &configMap); err != nil {
+			return "", "", "", err
+		}
+		return "configmap", configMap.Name, controllers.HashSecretData(controllers.ConfigMapData(&configMap)), nil
+	}
+	secretRef := keychainSecret.Status.SecretRef
+	if secretRef.Name == "" {
+		return "", "", "", fmt.Errorf("keychainsecret/%s has no target yet", keychainSecret.Name)
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: secretRef.Namespace, Name: secretRef.Name}, &secret); err != nil {
+		return "", "", "", err
+	}
+	return "secret", secret.Name, controllers.HashSecretData(secret.Data), nil
+}
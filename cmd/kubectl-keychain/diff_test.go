@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	"github.com/davidewatson/keychain/controllers"
+)
+
+func TestDiffTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-keychain-diff")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "DB_PASSWORD"), []byte("hunter2"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	backend := &controllers.FileBackend{Root: dir}
+
+	synced := map[string][]byte{"DB_PASSWORD": []byte("hunter2")}
+	modified := map[string][]byte{"DB_PASSWORD": []byte("hunter3")}
+	secret := func(data map[string][]byte) runtime.Object {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "DB_PASSWORD"}, Data: data}
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "DB_PASSWORD"},
+		Data: map[string]string{"DB_PASSWORD": "hunter2"}}
+	secretStatus := func(data map[string][]byte) aqueductv1.KeychainSecretStatus {
+		return aqueductv1.KeychainSecretStatus{ContentHash: controllers.HashSecretData(data),
+			SecretRef: corev1.SecretReference{Namespace: "default", Name: "DB_PASSWORD"}}
+	}
+
+	var testsTable = []struct {
+		name     string
+		status   aqueductv1.KeychainSecretStatus
+		target   runtime.Object
+		backend  controllers.Backend
+		expected string
+		valid    bool
+	}{
+		{name: "never synced", status: aqueductv1.KeychainSecretStatus{}, target: secret(synced), valid: false},
+		{name: "no drift", status: secretStatus(synced), target: secret(synced), expected: "no drift since the last sync", valid: true},
+		{name: "drift", status: secretStatus(synced), target: secret(modified), valid: false},
+		{name: "deleted target", status: secretStatus(synced), valid: false},
+		{name: "configmap target", status: aqueductv1.KeychainSecretStatus{ContentHash: controllers.HashSecretData(synced),
+			ConfigMapRef: &corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: "DB_PASSWORD"}},
+			target: configMap, expected: "no drift since the last sync", valid: true},
+		{name: "in sync with Keychain", status: secretStatus(synced), target: secret(synced), backend: backend,
+			expected: "in sync with Keychain", valid: true},
+		// The values last synced match the target, but not what Keychain holds now.
+		{name: "Keychain changed", status: secretStatus(modified), target: secret(modified), backend: backend, valid: false},
+		{name: "fetching does not need a previous sync", status: aqueductv1.KeychainSecretStatus{
+			SecretRef: corev1.SecretReference{Namespace: "default", Name: "DB_PASSWORD"}}, target: secret(synced),
+			backend: backend, expected: "in sync with Keychain", valid: true},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []runtime.Object
+			if tt.target != nil {
+				objs = append(objs, tt.target)
+			}
+			c := fake.NewFakeClientWithScheme(scheme, objs...)
+			keychainSecret := aqueductv1.KeychainSecret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+				Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD"},
+				Status:     tt.status,
+			}
+
+			var out bytes.Buffer
+			err := diffTarget(context.Background(), &out, c, keychainSecret, tt.backend)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !strings.Contains(out.String(), tt.expected) {
+				t.Errorf("Output %q does not contain %q", out.String(), tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// identity manages the per-namespace identities created by the controller. Only "show" is supported.
+func identity(args []string) error {
+	flags := flag.NewFlagSet("identity", flag.ExitOnError)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 || positional[0] != "show" || len(positional) > 2 {
+		return fmt.Errorf("usage: identity show [NAMESPACE]")
+	}
+
+	c, ns, err := newClient()
+	if err != nil {
+		return err
+	}
+	if len(positional) == 2 {
+		ns = positional[1]
+	}
+
+	cert, err := getIdentityCertificate(context.Background(), c, ns)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Namespace:\t%s\n", ns)
+	printCertificate(os.Stdout, cert)
+	return nil
+}
+
+// printCertificate writes the interesting fields of cert in kubectl describe style.
+func printCertificate(w io.Writer, cert *x509.Certificate) {
+	fmt.Fprintf(w, "Subject:\t%s\n", cert.Subject)
+	fmt.Fprintf(w, "Issuer:\t%s\n", cert.Issuer)
+	fmt.Fprintf(w, "Serial Number:\t%s\n", cert.SerialNumber)
+	fmt.Fprintf(w, "Not Before:\t%s\n", cert.NotBefore.Format(time.RFC3339))
+	fmt.Fprintf(w, "Not After:\t%s (%s)\n", cert.NotAfter.Format(time.RFC3339), untilString(metav1.NewTime(cert.NotAfter)))
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	if len(sans) > 0 {
+		fmt.Fprintf(w, "SANs:\t%s\n", strings.Join(sans, ", "))
+	}
+	fmt.Fprintf(w, "Signature Algorithm:\t%s\n", cert.SignatureAlgorithm)
+}
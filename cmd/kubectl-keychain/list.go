@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	"github.com/davidewatson/keychain/controllers"
+)
+
+// list prints a summary of KeychainSecrets in one or all namespaces.
+func list(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	allNamespaces := flags.Bool("all-namespaces", false, "List KeychainSecrets across all namespaces.")
+	flags.BoolVar(allNamespaces, "A", false, "Shorthand for --all-namespaces.")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	c, ns, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var opts []client.ListOption
+	if !*allNamespaces {
+		opts = append(opts, client.InNamespace(ns))
+	}
+	var keychainSecrets aqueductv1.KeychainSecretList
+	if err := c.List(ctx, &keychainSecrets, opts...); err != nil {
+		return err
+	}
+
+	identityExpiry := map[string]string{}
+	return printList(os.Stdout, keychainSecrets.Items, func(namespace string) string {
+		expiry, ok := identityExpiry[namespace]
+		if !ok {
+			expiry = identityExpiryString(ctx, c, namespace)
+			identityExpiry[namespace] = expiry
+		}
+		return expiry
+	})
+}
+
+// printList writes a row summarizing each KeychainSecret, along with when the identity of its namespace expires.
+func printList(out io.Writer, keychainSecrets []aqueductv1.KeychainSecret, identityExpiry func(namespace string) string) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tREADY\tAGE\tNEXT ROTATION\tIDENTITY EXPIRY")
+	for _, keychainSecret := range keychainSecrets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			keychainSecret.Namespace,
+			keychainSecret.Name,
+			readyString(keychainSecret.Status),
+			age(keychainSecret.CreationTimestamp),
+			untilString(keychainSecret.Status.NextRotation),
+			identityExpiry(keychainSecret.Namespace))
+	}
+	return w.Flush()
+}
+
+// identityExpiryString returns when the identity for namespace expires, or why that could not be determined.
+func identityExpiryString(ctx context.Context, c client.Client, namespace string) string {
+	cert, err := getIdentityCertificate(ctx, c, namespace)
+	if err != nil {
+		return "<unknown>"
+	}
+	return untilString(metav1.NewTime(cert.NotAfter))
+}
+
+// readyString summarizes the Ready condition.
+func readyString(status aqueductv1.KeychainSecretStatus) string {
+	ready := status.GetCondition(aqueductv1.ConditionReady)
+	if ready == nil {
+		return string(corev1.ConditionUnknown)
+	}
+	return string(ready.Status)
+}
+
+// age returns the time since t, formatted like kubectl's AGE column.
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
+
+// untilString returns the time until t, or how long ago it was if it has passed.
+func untilString(t metav1.Time) string {
+	if t.IsZero() {
+		return "<none>"
+	}
+	if d := time.Until(t.Time); d >= 0 {
+		return "in " + duration.HumanDuration(d)
+	}
+	return duration.HumanDuration(time.Since(t.Time)) + " ago"
+}
+
+// getIdentityCertificate fetches and parses the identity certificate for namespace.
+func getIdentityCertificate(ctx context.Context, c client.Client, namespace string) (*x509.Certificate, error) {
+	var identitySecret corev1.Secret
+	key := client.ObjectKey{Namespace: controllerNamespace, Name: controllers.IdentitySecretName(namespace)}
+	if err := c.Get(ctx, key, &identitySecret); err != nil {
+		return nil, err
+	}
+	return controllers.ParseIdentityCertificate(&identitySecret)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+func TestFormatting(t *testing.T) {
+	now := time.Now()
+	// Half a minute of slack keeps the durations from rounding down while the test runs.
+	future := metav1.NewTime(now.Add(10*time.Hour + 30*time.Second))
+	past := metav1.NewTime(now.Add(-10 * time.Hour))
+
+	var testsTable = []struct {
+		name     string
+		observed string
+		expected string
+	}{
+		{name: "empty value", observed: valueOrNone(""), expected: "<none>"},
+		{name: "value", observed: valueOrNone("DB_PASSWORD"), expected: "DB_PASSWORD"},
+		{name: "zero time", observed: formatTime(time.Time{}), expected: "<none>"},
+		{name: "time", observed: formatTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)), expected: "2020-01-02T03:04:05Z"},
+		{name: "unknown age", observed: age(metav1.Time{}), expected: "<unknown>"},
+		{name: "age", observed: age(past), expected: "10h"},
+		{name: "no deadline", observed: untilString(metav1.Time{}), expected: "<none>"},
+		{name: "future deadline", observed: untilString(future), expected: "in 10h"},
+		{name: "past deadline", observed: untilString(past), expected: "10h ago"},
+		{name: "ready unknown without condition", observed: readyString(aqueductv1.KeychainSecretStatus{}), expected: "Unknown"},
+		{name: "ready", observed: readyString(aqueductv1.KeychainSecretStatus{Conditions: []aqueductv1.KeychainSecretCondition{
+			{Type: aqueductv1.ConditionReady, Status: corev1.ConditionFalse}}}), expected: "False"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.observed != tt.expected {
+				t.Errorf("Observed %q, expected %q", tt.observed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrintList(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-10 * time.Hour))
+	ready := aqueductv1.KeychainSecretStatus{Conditions: []aqueductv1.KeychainSecretCondition{
+		{Type: aqueductv1.ConditionReady, Status: corev1.ConditionTrue}}}
+	identityExpiry := func(namespace string) string {
+		if namespace == "default" {
+			return "in 364d"
+		}
+		return "<unknown>"
+	}
+
+	var testsTable = []struct {
+		name            string
+		keychainSecrets []aqueductv1.KeychainSecret
+		expected        []string
+	}{
+		{name: "header only", expected: []string{
+			"NAMESPACE   NAME   READY   AGE   NEXT ROTATION   IDENTITY EXPIRY"}},
+		{name: "row per KeychainSecret", keychainSecrets: []aqueductv1.KeychainSecret{
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", CreationTimestamp: created}, Status: ready},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "api"}},
+		}, expected: []string{
+			"NAMESPACE   NAME   READY     AGE         NEXT ROTATION   IDENTITY EXPIRY",
+			"default     db     True      10h         <none>          in 364d",
+			"other       api    Unknown   <unknown>   <none>          <unknown>",
+		}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := printList(&out, tt.keychainSecrets, identityExpiry); err != nil {
+				t.Fatalf("printList failed: %v", err)
+			}
+			if expected := strings.Join(tt.expected, "\n") + "\n"; out.String() != expected {
+				t.Errorf("Observed\n%s\nexpected\n%s", out.String(), expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-keychain is a kubectl plugin for managing KeychainSecrets. Install it anywhere on your PATH and invoke it as
+// "kubectl keychain".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// command is a kubectl-keychain subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "list", usage: "list [-A]", run: list},
+	{name: "describe", usage: "describe NAME", run: describe},
+	{name: "refresh", usage: "refresh NAME [--wait] [--timeout DURATION]", run: refresh},
+	{name: "identity", usage: "identity show [NAMESPACE]", run: identity},
+	{name: "diff", usage: "diff NAME [--fetch]", run: diff},
+}
+
+var (
+	scheme              = runtime.NewScheme()
+	kubeconfig          string
+	namespace           string
+	controllerNamespace = "keychain-system"
+)
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = aqueductv1.AddToScheme(scheme)
+}
+
+func main() {
+	// We use our own FlagSet since controller-runtime registers flags, e.g. kubeconfig, on the default one.
+	flags := flag.NewFlagSet("kubectl-keychain", flag.ExitOnError)
+	addGlobalFlags(flags)
+	flags.Usage = usage
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name == flags.Arg(0) {
+			if err := c.run(flags.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", flags.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: kubectl keychain [--kubeconfig FILE] [-n NAMESPACE] COMMAND\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+}
+
+// addGlobalFlags registers the flags shared by all commands, so they may be given before or after the command name.
+func addGlobalFlags(flags *flag.FlagSet) {
+	flags.StringVar(&kubeconfig, "kubeconfig", kubeconfig, "Path to the kubeconfig file to use.")
+	flags.StringVar(&namespace, "namespace", namespace, "Namespace to use, defaults to the kubeconfig's current namespace.")
+	flags.StringVar(&namespace, "n", namespace, "Shorthand for --namespace.")
+	flags.StringVar(&controllerNamespace, "controller-namespace", controllerNamespace,
+		"Namespace the controller runs in, where identity Secrets are stored.")
+}
+
+// parseFlags parses a command's arguments, allowing flags to follow positional arguments as kubectl does.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	addGlobalFlags(flags)
+
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// newClient returns a client for the cluster, and the namespace to use, as configured by kubeconfig and the global
+// flags.
+func newClient() (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		Context: clientcmdapi.Context{Namespace: namespace},
+	})
+
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	ns, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, ns, nil
+}
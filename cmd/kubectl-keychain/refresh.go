@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+const (
+	pollInterval = time.Second
+)
+
+// refresh asks the controller to refresh a KeychainSecret now, and by default waits until it has.
+func refresh(args []string) error {
+	flags := flag.NewFlagSet("refresh", flag.ExitOnError)
+	waitForReady := flags.Bool("wait", true, "Wait for the controller to handle the refresh.")
+	timeout := flags.Duration("timeout", 5*time.Minute, "How long to wait for the refresh.")
+	names, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("refresh takes exactly one KeychainSecret name")
+	}
+
+	c, ns, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := client.ObjectKey{Namespace: ns, Name: names[0]}
+
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := c.Get(ctx, key, &keychainSecret); err != nil {
+		return err
+	}
+	requestedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	original := keychainSecret.DeepCopy()
+	if keychainSecret.ObjectMeta.Annotations == nil {
+		keychainSecret.ObjectMeta.Annotations = map[string]string{}
+	}
+	keychainSecret.ObjectMeta.Annotations[aqueductv1.RefreshRequestedAnnotation] = requestedAt
+	if err := c.Patch(ctx, &keychainSecret, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	fmt.Printf("keychainsecret/%s refresh requested at %s\n", key.Name, requestedAt)
+
+	if !*waitForReady {
+		return nil
+	}
+	err = wait.PollImmediate(pollInterval, *timeout, func() (bool, error) {
+		if err := c.Get(ctx, key, &keychainSecret); err != nil {
+			return false, err
+		}
+		return keychainSecret.Status.LastRefreshRequest == requestedAt &&
+			keychainSecret.Status.IsConditionTrue(aqueductv1.ConditionReady), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready != nil {
+			return fmt.Errorf("timed out waiting for refresh, Ready is %s: %s: %s", ready.Status, ready.Reason, ready.Message)
+		}
+		return fmt.Errorf("timed out waiting for refresh")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("keychainsecret/%s refreshed\n", key.Name)
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// AccessPolicyChecker decides whether a namespace may request, or store, a Keychain secret, according to
+// KeychainAccessPolicies.
+type AccessPolicyChecker struct {
+	client.Reader
+	// DefaultDeny denies namespaces no policy applies to. Otherwise they may request any secret.
+	DefaultDeny bool
+}
+
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychainaccesspolicies,verbs=get;list;watch
+
+// Allowed reports whether namespace may request the secret name in group. If it may not, the reason is returned.
+func (c *AccessPolicyChecker) Allowed(ctx context.Context, namespace, group, name string) (bool, string, error) {
+	ns, policies, err := c.policies(ctx, namespace)
+	if err != nil {
+		return false, "", err
+	}
+	allowed, reason := EvaluateAccessPolicies(policies, ns, group, name, c.DefaultDeny)
+	return allowed, reason, nil
+}
+
+// AllowedPut reports whether namespace may store the secret name in group. If it may not, the reason is returned.
+func (c *AccessPolicyChecker) AllowedPut(ctx context.Context, namespace, group, name string) (bool, string, error) {
+	ns, policies, err := c.policies(ctx, namespace)
+	if err != nil {
+		return false, "", err
+	}
+	allowed, reason := EvaluatePutAccessPolicies(policies, ns, group, name, c.DefaultDeny)
+	return allowed, reason, nil
+}
+
+// AllowedGroup reports whether namespace may request any secret in group, as KeychainGroupSecrets do. Their entries
+// are still checked one by one. If it may not, the reason is returned.
+func (c *AccessPolicyChecker) AllowedGroup(ctx context.Context, namespace, group string) (bool, string, error) {
+	ns, policies, err := c.policies(ctx, namespace)
+	if err != nil {
+		return false, "", err
+	}
+	allowed, reason := EvaluateGroupAccessPolicies(policies, ns, group, c.DefaultDeny)
+	return allowed, reason, nil
+}
+
+// AllowedSecrets reports whether namespace may request every one of requested. If it may not, the reason for the first
+// denial is returned.
+func (c *AccessPolicyChecker) AllowedSecrets(ctx context.Context, namespace string, requested []RequestedSecret) (bool, string, error) {
+	for _, r := range requested {
+		allowed, reason, err := c.Allowed(ctx, namespace, r.Params.Group, r.Params.Name)
+		if err != nil || !allowed {
+			return allowed, reason, err
+		}
+	}
+	return true, "", nil
+}
+
+// policies returns namespace, and every KeychainAccessPolicy.
+func (c *AccessPolicyChecker) policies(ctx context.Context, namespace string) (*corev1.Namespace, []aqueductv1.KeychainAccessPolicy, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return nil, nil, err
+	}
+	var policies aqueductv1.KeychainAccessPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return nil, nil, err
+	}
+	return &ns, policies.Items, nil
+}
+
+// EvaluateAccessPolicies reports whether namespace may request the secret name in group under policies. If it may
+// not, the reason is returned.
+func EvaluateAccessPolicies(policies []aqueductv1.KeychainAccessPolicy, namespace *corev1.Namespace, group, name string, defaultDeny bool) (bool, string) {
+	return evaluateRules(policies, namespace, defaultDeny, func(rule aqueductv1.KeychainAccessRule) bool {
+		return rule.AllowsVerb(aqueductv1.AccessGet) && matchesAny(rule.Groups, group) && matchesAny(rule.Names, name)
+	}, fmt.Sprintf("no KeychainAccessPolicy allows namespace %s to request %s in group %q", namespace.Name, name, group))
+}
+
+// EvaluatePutAccessPolicies reports whether namespace may store the secret name in group under policies. If it may
+// not, the reason is returned.
+func EvaluatePutAccessPolicies(policies []aqueductv1.KeychainAccessPolicy, namespace *corev1.Namespace, group, name string, defaultDeny bool) (bool, string) {
+	return evaluateRules(policies, namespace, defaultDeny, func(rule aqueductv1.KeychainAccessRule) bool {
+		return rule.AllowsVerb(aqueductv1.AccessPut) && matchesAny(rule.Groups, group) && matchesAny(rule.Names, name)
+	}, fmt.Sprintf("no KeychainAccessPolicy allows namespace %s to store %s in group %q", namespace.Name, name, group))
+}
+
+// EvaluateGroupAccessPolicies reports whether namespace may request any secret in group under policies. If it may not,
+// the reason is returned.
+func EvaluateGroupAccessPolicies(policies []aqueductv1.KeychainAccessPolicy, namespace *corev1.Namespace, group string, defaultDeny bool) (bool, string) {
+	return evaluateRules(policies, namespace, defaultDeny, func(rule aqueductv1.KeychainAccessRule) bool {
+		return rule.AllowsVerb(aqueductv1.AccessGet) && matchesAny(rule.Groups, group)
+	}, fmt.Sprintf("no KeychainAccessPolicy allows namespace %s to request secrets in group %q", namespace.Name, group))
+}
+
+// evaluateRules reports whether any rule of the policies applying to namespace allows a request. If none does, the
+// reason is denied, or that no policy applies under defaultDeny.
+func evaluateRules(policies []aqueductv1.KeychainAccessPolicy, namespace *corev1.Namespace, defaultDeny bool, allows func(aqueductv1.KeychainAccessRule) bool, denied string) (bool, string) {
+	applied := 0
+	for _, policy := range policies {
+		if !policyApplies(policy.Spec, namespace) {
+			continue
+		}
+		applied++
+		for _, rule := range policy.Spec.Rules {
+			if allows(rule) {
+				return true, ""
+			}
+		}
+	}
+
+	if applied == 0 {
+		if defaultDeny {
+			return false, fmt.Sprintf("no KeychainAccessPolicy applies to namespace %s", namespace.Name)
+		}
+		return true, ""
+	}
+	return false, denied
+}
+
+// policyApplies reports whether a policy applies to namespace, either by name or by label.
+func policyApplies(spec aqueductv1.KeychainAccessPolicySpec, namespace *corev1.Namespace) bool {
+	for _, name := range spec.Namespaces {
+		if name == namespace.Name {
+			return true
+		}
+	}
+	if spec.NamespaceSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+	if err != nil {
+		// An invalid selector selects nothing, rather than everything.
+		return false
+	}
+	return selector.Matches(labels.Set(namespace.Labels))
+}
+
+// matchesAny reports whether value matches any of patterns, or true if there are none. Invalid patterns match
+// nothing.
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestEvaluateAccessPolicies(t *testing.T) {
+	policies := []aqueductv1.KeychainAccessPolicy{
+		{Spec: aqueductv1.KeychainAccessPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Rules:             []aqueductv1.KeychainAccessRule{{Groups: []string{"PAYMENTS"}}},
+		}},
+		{Spec: aqueductv1.KeychainAccessPolicySpec{
+			Namespaces: []string{"web"},
+			Rules:      []aqueductv1.KeychainAccessRule{{Groups: []string{""}, Names: []string{"WEB_*"}}},
+		}},
+	}
+	payments := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}}
+	web := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	var testsTable = []struct {
+		name        string
+		namespace   *corev1.Namespace
+		group       string
+		secret      string
+		defaultDeny bool
+		allowed     bool
+	}{
+		{name: "selected by label and group allowed", namespace: payments, group: "PAYMENTS", secret: "API_KEY", allowed: true},
+		{name: "selected by label and group denied", namespace: payments, group: "WEB", secret: "API_KEY", allowed: false},
+		{name: "selected by name and name allowed", namespace: web, secret: "WEB_TOKEN", allowed: true},
+		{name: "selected by name and name denied", namespace: web, secret: "DB_PASSWORD", allowed: false},
+		{name: "empty group pattern only matches no group", namespace: web, group: "WEB", secret: "WEB_TOKEN", allowed: false},
+		{name: "unselected namespaces are allowed by default", namespace: other, secret: "ANYTHING", allowed: true},
+		{name: "unselected namespaces are denied with default deny", namespace: other, secret: "ANYTHING", defaultDeny: true, allowed: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, reason := EvaluateAccessPolicies(policies, tt.namespace, tt.group, tt.secret, tt.defaultDeny)
+			if allowed != tt.allowed {
+				t.Errorf("Allowed %v (%s), expected %v", allowed, reason, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestEvaluateGroupAccessPolicies(t *testing.T) {
+	policies := []aqueductv1.KeychainAccessPolicy{
+		{Spec: aqueductv1.KeychainAccessPolicySpec{
+			Namespaces: []string{"payments"},
+			Rules:      []aqueductv1.KeychainAccessRule{{Groups: []string{"PAYMENTS"}, Names: []string{"API_*"}}},
+		}},
+	}
+	payments := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	var testsTable = []struct {
+		name        string
+		namespace   *corev1.Namespace
+		group       string
+		defaultDeny bool
+		allowed     bool
+	}{
+		{name: "group allowed for some names", namespace: payments, group: "PAYMENTS", allowed: true},
+		{name: "group denied", namespace: payments, group: "WEB", allowed: false},
+		{name: "unselected namespaces are allowed by default", namespace: other, group: "WEB", allowed: true},
+		{name: "unselected namespaces are denied with default deny", namespace: other, group: "WEB", defaultDeny: true, allowed: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, reason := EvaluateGroupAccessPolicies(policies, tt.namespace, tt.group, tt.defaultDeny)
+			if allowed != tt.allowed {
+				t.Errorf("Allowed observed %v, expected %v", allowed, tt.allowed)
+			}
+			if !allowed && reason == "" {
+				t.Errorf("Denied without a reason")
+			}
+		})
+	}
+}
+
+func TestEvaluatePutAccessPolicies(t *testing.T) {
+	policies := []aqueductv1.KeychainAccessPolicy{
+		{Spec: aqueductv1.KeychainAccessPolicySpec{
+			Namespaces: []string{"payments"},
+			Rules: []aqueductv1.KeychainAccessRule{
+				{Groups: []string{"PAYMENTS"}},
+				{Groups: []string{"PAYMENTS"}, Names: []string{"API_*"}, Verbs: []aqueductv1.AccessVerb{aqueductv1.AccessPut}},
+			},
+		}},
+	}
+	payments := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	var testsTable = []struct {
+		name        string
+		namespace   *corev1.Namespace
+		secret      string
+		defaultDeny bool
+		allowed     bool
+	}{
+		{name: "put allowed", namespace: payments, secret: "API_KEY", allowed: true},
+		{name: "rules without verbs only allow get", namespace: payments, secret: "DB_PASSWORD", allowed: false},
+		{name: "unselected namespaces are allowed by default", namespace: other, secret: "ANYTHING", allowed: true},
+		{name: "unselected namespaces are denied with default deny", namespace: other, secret: "ANYTHING", defaultDeny: true, allowed: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, reason := EvaluatePutAccessPolicies(policies, tt.namespace, "PAYMENTS", tt.secret, tt.defaultDeny)
+			if allowed != tt.allowed {
+				t.Errorf("Allowed observed %v, expected %v", allowed, tt.allowed)
+			}
+			if !allowed && reason == "" {
+				t.Errorf("Denied without a reason")
+			}
+		})
+	}
+
+	// Put rules do not allow get.
+	if allowed, _ := EvaluateAccessPolicies(policies[:1], payments, "PAYMENTS", "API_KEY", true); !allowed {
+		t.Errorf("Get denied, expected the rule without verbs to allow it")
+	}
+	putOnly := []aqueductv1.KeychainAccessPolicy{{Spec: aqueductv1.KeychainAccessPolicySpec{Namespaces: []string{"payments"},
+		Rules: []aqueductv1.KeychainAccessRule{{Verbs: []aqueductv1.AccessVerb{aqueductv1.AccessPut}}}}}}
+	if allowed, _ := EvaluateAccessPolicies(putOnly, payments, "PAYMENTS", "API_KEY", true); allowed {
+		t.Errorf("Get allowed by a put rule")
+	}
+}
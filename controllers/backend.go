@@ -0,0 +1,311 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Backend is a store of Keychain secrets.
+type Backend interface {
+	// Get returns the value of a secret.
+	Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error)
+	// List returns the names of the secrets in a group.
+	List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error)
+}
+
+// keychainNamePattern matches the names of Keychain secrets and groups, as validated by the CRDs.
+var keychainNamePattern = regexp.MustCompile(`^[A-Z0-9_]{1,150}$`)
+
+// ValidateKeychainName returns an error unless name is a valid Keychain secret or group name. Names end up in
+// command arguments, URL paths and file names, so anything else could request something other than a secret.
+func ValidateKeychainName(name string) error {
+	if !keychainNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid Keychain name %q: must be 1 to 150 upper case letters, digits or underscores", name)
+	}
+	return nil
+}
+
+// ErrNotFound is wrapped by the errors Backends return for secrets which do not exist, see IsNotFound.
+var ErrNotFound = errors.New("secret not found")
+
+// IsNotFound reports whether err is due to a secret not existing.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ErrAlreadyExists is wrapped by the errors Writers return for secrets which exist when they were only to be created,
+// see IsAlreadyExists.
+var ErrAlreadyExists = errors.New("secret already exists")
+
+// IsAlreadyExists reports whether err is due to a secret existing.
+func IsAlreadyExists(err error) bool {
+	return errors.Is(err, ErrAlreadyExists)
+}
+
+// ErrConflict is wrapped by the errors Writers return for secrets whose latest version is not the one they were to
+// replace, see IsConflict.
+var ErrConflict = errors.New("secret changed concurrently")
+
+// IsConflict reports whether err is due to a secret having changed since the version it was to replace.
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// ErrUnavailable is wrapped by the errors Backends return when they cannot answer at the moment, e.g. because they are
+// down or overloaded, see IsUnavailable.
+var ErrUnavailable = errors.New("backend unavailable")
+
+// IsUnavailable reports whether err is due to a backend being unavailable or timing out, rather than an answer from it.
+// Only then are values synced earlier still worth serving. A failing Keychain command does not say why it failed, so
+// it is assumed to be unavailable.
+func IsUnavailable(err error) bool {
+	var netErr net.Error
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, ErrUnavailable), errors.Is(err, ErrCircuitOpen), errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &netErr), errors.As(err, &exitErr):
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// ErrNotSupported is wrapped by the errors Backends return for requests they do not support, e.g. Put by backends which
+// cannot store secrets, see IsNotSupported.
+var ErrNotSupported = errors.New("not supported by the backend")
+
+// IsNotSupported reports whether err is due to a backend not supporting the request.
+func IsNotSupported(err error) bool {
+	return errors.Is(err, ErrNotSupported) || status.Code(err) == codes.Unimplemented
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity Secret of the namespace a request to a Backend is made for.
+// Backends which authenticate as that namespace, e.g. with mutual TLS, read it with IdentityFrom.
+func WithIdentity(ctx context.Context, identity *corev1.Secret) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity Secret carried by ctx, or nil if there is none.
+func IdentityFrom(ctx context.Context) *corev1.Secret {
+	identity, _ := ctx.Value(identityKey{}).(*corev1.Secret)
+	return identity
+}
+
+// Versioner is implemented by Backends which know the versions of secrets.
+type Versioner interface {
+	// LatestVersion returns the latest version of a secret, or an empty string if it is unknown.
+	LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error)
+}
+
+// LatestVersion returns the latest version of a secret in backend, or an empty string if the backend does not know it.
+func LatestVersion(ctx context.Context, backend Backend, params GetKeychainSecretParams) (string, error) {
+	versioner, ok := backend.(Versioner)
+	if !ok {
+		return "", nil
+	}
+	return versioner.LatestVersion(ctx, params)
+}
+
+// FetchedSecret is the value of a secret, along with its version and expiry as of the same request.
+type FetchedSecret struct {
+	Value   []byte
+	Version string    // Empty if unknown
+	Expiry  time.Time // Zero if the value does not expire
+	// VersionErr is why the version is unknown, if looking it up failed. The value is valid regardless.
+	VersionErr error
+}
+
+// Fetcher is implemented by Backends which return the version of a secret with its value, so that the two match even
+// if the secret changes meanwhile.
+type Fetcher interface {
+	// Fetch returns the value of a secret, along with its version and expiry.
+	Fetch(ctx context.Context, params GetKeychainSecretParams) (FetchedSecret, error)
+}
+
+// Fetch returns the value of a secret in backend, along with its version and expiry. Backends which are Versioners,
+// but not Fetchers, look up the latest version before and after getting the value, which is only known if it did not
+// change in between. They cannot confirm which version a pinned value is, so its version is unknown. Other backends
+// do not support pinned versions.
+func Fetch(ctx context.Context, backend Backend, params GetKeychainSecretParams) (FetchedSecret, error) {
+	if fetcher, ok := backend.(Fetcher); ok {
+		return fetcher.Fetch(ctx, params)
+	}
+	versioner, ok := backend.(Versioner)
+	if !ok && params.Version != "" {
+		return FetchedSecret{}, fmt.Errorf("backend cannot fetch versions: %w", ErrNotSupported)
+	}
+	if !ok || params.Version != "" {
+		value, expiry, err := GetWithExpiry(ctx, backend, params)
+		if err != nil {
+			return FetchedSecret{}, err
+		}
+		secret := FetchedSecret{Value: value, Expiry: expiry}
+		if params.Version != "" {
+			secret.VersionErr = fmt.Errorf("backend cannot confirm that version %s was fetched", params.Version)
+		}
+		return secret, nil
+	}
+
+	before, beforeErr := versioner.LatestVersion(ctx, params)
+	value, expiry, err := GetWithExpiry(ctx, backend, params)
+	if err != nil {
+		return FetchedSecret{}, err
+	}
+	secret := FetchedSecret{Value: value, Expiry: expiry}
+	if beforeErr != nil {
+		secret.VersionErr = beforeErr
+		return secret, nil
+	}
+	after, err := versioner.LatestVersion(ctx, params)
+	switch {
+	case err != nil:
+		secret.VersionErr = err
+	case before != after:
+		secret.VersionErr = fmt.Errorf("version changed from %q to %q while fetching", before, after)
+	default:
+		secret.Version = after
+	}
+	return secret, nil
+}
+
+// Expirer is implemented by Backends whose secrets may carry their own expiry, e.g. tokens or short-lived certificates.
+type Expirer interface {
+	// GetWithExpiry returns the value of a secret and when it expires, or the zero time if it does not.
+	GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error)
+}
+
+// GetWithExpiry returns the value of a secret in backend and when it expires. Secrets of backends which do not
+// implement Expirer do not expire.
+func GetWithExpiry(ctx context.Context, backend Backend, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	if expirer, ok := backend.(Expirer); ok {
+		return expirer.GetWithExpiry(ctx, params)
+	}
+	value, err := backend.Get(ctx, params)
+	return value, time.Time{}, err
+}
+
+// Writer is implemented by Backends which can store secrets.
+type Writer interface {
+	// Put stores value as the new version of a secret, creating the secret if it does not exist. It returns the
+	// version, or an empty string if it is unknown. With params.IfAbsent, it only creates the secret, atomically, and
+	// fails with an error wrapping ErrAlreadyExists if it exists. With params.IfVersion, it only replaces that version
+	// of the secret, atomically, and fails with an error wrapping ErrConflict if the latest version is another one.
+	// Writers which cannot check either fail with an error wrapping ErrNotSupported.
+	Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error)
+}
+
+// Put stores value as the new version of a secret in backend, which fails unless backend is a Writer.
+func Put(ctx context.Context, backend Backend, params PutKeychainSecretParams, value []byte) (string, error) {
+	writer, ok := backend.(Writer)
+	if !ok {
+		return "", fmt.Errorf("backend cannot store secrets: %w", ErrNotSupported)
+	}
+	return writer.Put(ctx, params, value)
+}
+
+// BackendEvent reports that a Keychain secret changed.
+type BackendEvent struct {
+	Group   string
+	Name    string
+	Version string // The new version, if the backend knows versions
+}
+
+// ErrWatchNotSupported is returned by Watchers which turn out not to support watching, e.g. backend plugins lacking
+// the capability.
+var ErrWatchNotSupported = errors.New("backend does not support watch")
+
+// Watcher is implemented by Backends which can report changes to secrets as they happen, so they are synced without
+// waiting for their TTL.
+type Watcher interface {
+	// Watch sends an event to events for every change to a secret, until ctx is done or it fails.
+	Watch(ctx context.Context, events chan<- BackendEvent) error
+}
+
+// HealthChecker is implemented by Backends which can tell whether they are able to serve requests.
+type HealthChecker interface {
+	// Health returns an error if the backend cannot serve requests.
+	Health(ctx context.Context) error
+}
+
+// Health returns an error if backend cannot serve requests. Backends which do not implement HealthChecker are assumed
+// to be healthy.
+func Health(ctx context.Context, backend Backend) error {
+	if checker, ok := backend.(HealthChecker); ok {
+		return checker.Health(ctx)
+	}
+	return nil
+}
+
+// CommandBackend is a Backend which shells out to the commands templated by GET_SECRET_COMMAND and
+// LIST_SECRETS_COMMAND, and the optional *_COMMAND templates.
+type CommandBackend struct{}
+
+// Get implements Backend.
+func (CommandBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	return GetKeychainSecret(ctx, params)
+}
+
+// List implements Backend.
+func (CommandBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	return ListKeychainSecrets(ctx, params)
+}
+
+// GetWithExpiry implements Expirer, using the optional GET_SECRET_EXPIRY_COMMAND.
+func (b CommandBackend) GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	value, err := b.Get(ctx, params)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiry, err := GetKeychainSecretExpiry(ctx, params)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return value, expiry, nil
+}
+
+// LatestVersion implements Versioner, using the optional GET_SECRET_VERSION_COMMAND.
+func (CommandBackend) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	return GetKeychainSecretVersion(ctx, params)
+}
+
+// Put implements Writer, using the optional PUT_SECRET_COMMAND.
+func (CommandBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	return PutKeychainSecret(ctx, params, value)
+}
+
+// Health implements HealthChecker, checking that the binaries of GET_SECRET_COMMAND and the other configured
+// *_COMMAND templates exist and are executable.
+func (CommandBackend) Health(ctx context.Context) error {
+	return CheckCommands(GetSecretCommandEnv)
+}
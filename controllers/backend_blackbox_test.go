@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	var testsTable = []struct {
+		name        string
+		err         error
+		unavailable bool
+	}{
+		{name: "no error", err: nil, unavailable: false},
+		{name: "server errors", err: fmt.Errorf("GET /v1/secrets/-/A: 503 Service Unavailable: %w", ErrUnavailable), unavailable: true},
+		{name: "open circuits", err: fmt.Errorf("primary: %w", ErrCircuitOpen), unavailable: true},
+		{name: "timeouts", err: context.DeadlineExceeded, unavailable: true},
+		{name: "network errors", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, unavailable: true},
+		{name: "unavailable gRPC servers", err: status.Error(codes.Unavailable, "connection refused"), unavailable: true},
+		{name: "missing secrets", err: fmt.Errorf("A: %w", ErrNotFound), unavailable: false},
+		{name: "denied gRPC requests", err: status.Error(codes.PermissionDenied, "denied"), unavailable: false},
+		{name: "other errors", err: errors.New("GET /v1/secrets/-/A: 403 Forbidden"), unavailable: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if unavailable := IsUnavailable(tt.err); unavailable != tt.unavailable {
+				t.Errorf("IsUnavailable(%v) observed %v, expected %v", tt.err, unavailable, tt.unavailable)
+			}
+		})
+	}
+}
+
+// versionedBackend is a fakeBackend which is a Versioner, reporting the results of latest as the latest versions.
+type versionedBackend struct {
+	fakeBackend
+	latest func() (string, error)
+}
+
+func (b versionedBackend) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	return b.latest()
+}
+
+// versions returns a function returning versions in turn, and then failing.
+func versions(versions ...string) func() (string, error) {
+	return func() (string, error) {
+		if len(versions) == 0 {
+			return "", errors.New("GET_SECRET_VERSION_COMMAND failed")
+		}
+		version := versions[0]
+		versions = versions[1:]
+		return version, nil
+	}
+}
+
+func TestFetch(t *testing.T) {
+	values := fakeBackend{values: map[string]string{"SUPER_SECRET": "hunter2"}}
+
+	var testsTable = []struct {
+		name       string
+		backend    Backend
+		params     GetKeychainSecretParams
+		version    string
+		versionErr bool
+		valid      bool
+	}{
+		{name: "unversioned backend", backend: values, params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, valid: true},
+		{name: "stable version", backend: versionedBackend{values, versions("2", "2")},
+			params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, version: "2", valid: true},
+		{name: "version changed while fetching", backend: versionedBackend{values, versions("2", "3")},
+			params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, versionErr: true, valid: true},
+		{name: "version lookup failed", backend: versionedBackend{values, versions("2")},
+			params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, versionErr: true, valid: true},
+		{name: "pinned version", backend: versionedBackend{values, versions()},
+			params: GetKeychainSecretParams{Name: "SUPER_SECRET", Version: "1"}, versionErr: true, valid: true},
+		{name: "pinned version of an unversioned backend", backend: values,
+			params: GetKeychainSecretParams{Name: "SUPER_SECRET", Version: "1"}, valid: false},
+		{name: "missing secret", backend: versionedBackend{values, versions("2", "2")},
+			params: GetKeychainSecretParams{Name: "MISSING"}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := Fetch(context.Background(), tt.backend, tt.params)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if string(secret.Value) != "hunter2" {
+				t.Errorf("Value %q, expected hunter2", secret.Value)
+			}
+			if secret.Version != tt.version || (secret.VersionErr != nil) != tt.versionErr {
+				t.Errorf("Version %q (%v), expected %q with error %v", secret.Version, secret.VersionErr, tt.version, tt.versionErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// backendChanges records when backends last reported changes to the secrets KeychainSecrets request, so they are
+// synced regardless of schedule until a sync fetches the change.
+type backendChanges struct {
+	mu      sync.Mutex
+	changed map[types.NamespacedName]time.Time
+}
+
+// add records a change to the secrets the KeychainSecret key requests at now.
+func (c *backendChanges) add(key types.NamespacedName, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed == nil {
+		c.changed = map[types.NamespacedName]time.Time{}
+	}
+	c.changed[key] = now
+}
+
+// pending reports whether a change to the secrets the KeychainSecret key requests has yet to be synced.
+func (c *backendChanges) pending(key types.NamespacedName) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.changed[key]
+	return ok
+}
+
+// synced records that the secrets the KeychainSecret key requests were fetched at fetched, which includes the changes
+// reported until then.
+func (c *backendChanges) synced(key types.NamespacedName, fetched time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if changed, ok := c.changed[key]; ok && !changed.After(fetched) {
+		delete(c.changed, key)
+	}
+}
+
+// watchBackend adds a Runnable watching the backend to mgr, and returns the source of events for the KeychainSecrets
+// requesting the secrets which changed, which are looked up with the RequestedSecretsField index. It returns nil if
+// the backend is not a Watcher.
+func (r *KeychainSecretReconciler) watchBackend(mgr ctrl.Manager) (source.Source, error) {
+	watcher, ok := r.backend().(Watcher)
+	if !ok {
+		return nil, nil
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&aqueductv1.KeychainSecret{}, RequestedSecretsField, IndexRequestedSecrets); err != nil {
+		return nil, err
+	}
+	log := r.Log.WithName("backend")
+
+	changes := make(chan event.GenericEvent)
+	err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop
+			cancel()
+		}()
+
+		events := make(chan BackendEvent)
+		go func() {
+			for {
+				err := watcher.Watch(ctx, events)
+				if err == ErrWatchNotSupported {
+					log.Info("backend does not support watch, secrets are synced on TTL only")
+					return
+				}
+				if err != nil {
+					log.Error(err, "watching backend failed")
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryAfterErrorDuration):
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case e := <-events:
+				keychainSecrets, err := requestingKeychainSecrets(ctx, mgr.GetClient(), e)
+				if err != nil {
+					log.Error(err, "unable to list KeychainSecrets", "group", e.Group, "name", e.Name)
+					continue
+				}
+				for i := range keychainSecrets {
+					r.changes.add(types.NamespacedName{Namespace: keychainSecrets[i].Namespace, Name: keychainSecrets[i].Name}, time.Now())
+					log.V(1).Info("Keychain secret changed", "group", e.Group, "name", e.Name, "version", e.Version,
+						"keychainsecret", keychainSecrets[i].Namespace+"/"+keychainSecrets[i].Name)
+					select {
+					case changes <- event.GenericEvent{Meta: &keychainSecrets[i], Object: &keychainSecrets[i]}:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			}
+		}
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &source.Channel{Source: changes}, nil
+}
+
+// RequestedSecretsField is the name of the KeychainSecret field index of the Keychain secrets they request, see
+// IndexRequestedSecrets.
+const RequestedSecretsField = "keychain.requestedSecrets"
+
+// IndexRequestedSecrets returns the RequestedSecretsField index values of a KeychainSecret: the GROUP/NAME of every
+// Keychain secret it requests.
+func IndexRequestedSecrets(obj runtime.Object) []string {
+	keychainSecret, ok := obj.(*aqueductv1.KeychainSecret)
+	if !ok {
+		return nil
+	}
+	var values []string
+	for _, requested := range RequestedSecrets(*keychainSecret) {
+		values = append(values, requestedSecretsValue(requested.Params.Group, requested.Params.Name))
+	}
+	return values
+}
+
+// requestedSecretsValue returns the RequestedSecretsField index value of a Keychain secret.
+func requestedSecretsValue(group, name string) string {
+	return group + "/" + name
+}
+
+// requestingKeychainSecrets returns the KeychainSecrets requesting the secret which changed.
+func requestingKeychainSecrets(ctx context.Context, c client.Reader, e BackendEvent) ([]aqueductv1.KeychainSecret, error) {
+	var list aqueductv1.KeychainSecretList
+	if err := c.List(ctx, &list, client.MatchingFields{RequestedSecretsField: requestedSecretsValue(e.Group, e.Name)}); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
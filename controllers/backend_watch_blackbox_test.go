@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestIndexRequestedSecrets(t *testing.T) {
+	var testsTable = []struct {
+		name     string
+		spec     aqueductv1.KeychainSecretSpec
+		expected []string
+	}{
+		{name: "no group", spec: aqueductv1.KeychainSecretSpec{Name: "SUPER_SECRET"}, expected: []string{"/SUPER_SECRET"}},
+		{name: "group", spec: aqueductv1.KeychainSecretSpec{Group: "DATABASE", Name: "DB_PASSWORD"},
+			expected: []string{"DATABASE/DB_PASSWORD"}},
+		{name: "template sources", spec: aqueductv1.KeychainSecretSpec{Group: "DATABASE", Name: "DB_PASSWORD",
+			Template: &aqueductv1.SecretTemplate{Sources: []aqueductv1.KeychainSource{{Group: "DATABASE", Name: "DB_USER"}}}},
+			expected: []string{"DATABASE/DB_PASSWORD", "DATABASE/DB_USER"}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			values := IndexRequestedSecrets(&aqueductv1.KeychainSecret{Spec: tt.spec})
+			if !reflect.DeepEqual(values, tt.expected) {
+				t.Errorf("Index values %v, expected %v", values, tt.expected)
+			}
+		})
+	}
+
+	if values := IndexRequestedSecrets(&corev1.Secret{}); values != nil {
+		t.Errorf("Index values %v for a Secret, expected none", values)
+	}
+}
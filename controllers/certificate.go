@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// CACertKey is the key of CA certificates in TLS Secrets, as used by cert-manager and ingress controllers.
+const CACertKey = "ca.crt"
+
+// BuildTLSData splits the PEM blocks found in data, in key order, into the keys of a kubernetes.io/tls Secret:
+// tls.crt holds the leaf certificate followed by any intermediates, tls.key the private key, and ca.crt any
+// self-signed certificates. It fails unless the private key matches the leaf certificate, and the leaf certificate is
+// valid at now. It returns the leaf certificate along with the data.
+func BuildTLSData(data map[string][]byte, now time.Time) (map[string][]byte, *x509.Certificate, error) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var chain, roots, privateKey bytes.Buffer
+	var leaf *x509.Certificate
+	for _, key := range keys {
+		rest := data[key]
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+
+			switch {
+			case block.Type == "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", key, err)
+				}
+				if isSelfSigned(cert) {
+					pem.Encode(&roots, block)
+					continue
+				}
+				if leaf == nil {
+					leaf = cert
+				}
+				pem.Encode(&chain, block)
+			case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+				if privateKey.Len() > 0 {
+					return nil, nil, fmt.Errorf("%s: more than one private key", key)
+				}
+				pem.Encode(&privateKey, block)
+			}
+		}
+	}
+
+	// A self-signed certificate alone is its own leaf.
+	if leaf == nil && roots.Len() > 0 {
+		block, _ := pem.Decode(roots.Bytes())
+		leaf, _ = x509.ParseCertificate(block.Bytes)
+		chain, roots = roots, bytes.Buffer{}
+	}
+	if leaf == nil {
+		return nil, nil, fmt.Errorf("no certificate found")
+	}
+	if privateKey.Len() == 0 {
+		return nil, nil, fmt.Errorf("no private key found")
+	}
+	if _, err := tls.X509KeyPair(chain.Bytes(), privateKey.Bytes()); err != nil {
+		return nil, nil, fmt.Errorf("private key does not match certificate: %v", err)
+	}
+	if !now.Before(leaf.NotAfter) {
+		return nil, nil, fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	tlsData := map[string][]byte{
+		corev1.TLSCertKey:       chain.Bytes(),
+		corev1.TLSPrivateKeyKey: privateKey.Bytes(),
+	}
+	if roots.Len() > 0 {
+		tlsData[CACertKey] = roots.Bytes()
+	}
+	return tlsData, leaf, nil
+}
+
+// CertificateStatus returns the status describing cert.
+func CertificateStatus(cert *x509.Certificate) *aqueductv1.CertificateStatus {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return &aqueductv1.CertificateStatus{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SANs:         sans,
+		SerialNumber: cert.SerialNumber.String(),
+		NotBefore:    metav1.NewTime(cert.NotBefore),
+		NotAfter:     metav1.NewTime(cert.NotAfter),
+	}
+}
+
+// isSelfSigned reports whether cert is signed by its own key, as root CAs are.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+// newCertificate returns a PEM encoded certificate for cn, signed by parent and parentKey, or self-signed if they are
+// nil, along with its PEM encoded private key.
+func newCertificate(t *testing.T, cn string, isCA bool, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), cert, key
+}
+
+func TestBuildTLSData(t *testing.T) {
+	now := time.Now()
+	caPEM, _, ca, caKey := newCertificate(t, "ca", true, now.Add(48*time.Hour), nil, nil)
+	leafPEM, leafKeyPEM, _, _ := newCertificate(t, "example.com", false, now.Add(time.Hour), ca, caKey)
+	_, otherKeyPEM, _, _ := newCertificate(t, "other.com", false, now.Add(time.Hour), ca, caKey)
+	expiredPEM, expiredKeyPEM, _, _ := newCertificate(t, "expired.com", false, now.Add(-time.Hour), ca, caKey)
+
+	var testsTable = []struct {
+		name  string
+		data  map[string][]byte
+		valid bool
+		ca    bool
+	}{
+		{name: "bundle in one value", data: map[string][]byte{"A": append(append(leafPEM, leafKeyPEM...), caPEM...)},
+			valid: true, ca: true},
+		{name: "separate values", data: map[string][]byte{"cert": leafPEM, "key": leafKeyPEM}, valid: true},
+		{name: "mismatched key", data: map[string][]byte{"cert": leafPEM, "key": otherKeyPEM}, valid: false},
+		{name: "expired certificate", data: map[string][]byte{"cert": expiredPEM, "key": expiredKeyPEM}, valid: false},
+		{name: "missing key", data: map[string][]byte{"cert": leafPEM}, valid: false},
+		{name: "not PEM", data: map[string][]byte{"A": []byte("hunter2")}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			data, leaf, err := BuildTLSData(tt.data, now)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if leaf.Subject.CommonName != "example.com" {
+				t.Errorf("Leaf %s, expected example.com", leaf.Subject.CommonName)
+			}
+			if len(data[corev1.TLSCertKey]) == 0 || len(data[corev1.TLSPrivateKeyKey]) == 0 {
+				t.Errorf("Data has keys %v, expected %s and %s", data, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+			}
+			if _, ok := data[CACertKey]; ok != tt.ca {
+				t.Errorf("Data has %s %v, expected %v", CACertKey, ok, tt.ca)
+			}
+		})
+	}
+}
+
+func TestCertificateStatus(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, _, cert, _ := newCertificate(t, "example.com", false, notAfter, nil, nil)
+
+	status := CertificateStatus(cert)
+	if status.Subject != "CN=example.com" || status.Issuer != "CN=example.com" {
+		t.Errorf("Subject %q and issuer %q, expected CN=example.com", status.Subject, status.Issuer)
+	}
+	if len(status.SANs) != 1 || status.SANs[0] != "example.com" {
+		t.Errorf("SANs %v, expected [example.com]", status.SANs)
+	}
+	if !status.NotAfter.Time.Equal(notAfter) {
+		t.Errorf("NotAfter %v, expected %v", status.NotAfter, notAfter)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// ErrCircuitOpen is wrapped by the errors of requests a CircuitBreaker rejected without calling its backend.
+var ErrCircuitOpen = errors.New("circuit open, backend unavailable")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed passes requests to the backend.
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen passes a single request, probing whether the backend recovered, and rejects the others.
+	CircuitHalfOpen
+	// CircuitOpen rejects requests.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+// CircuitBreaker is a Backend which stops calling a failing backend: once FailureThreshold consecutive requests fail,
+// the circuit opens and requests are rejected with ErrCircuitOpen, e.g. so a FallbackBackend falls back immediately
+// rather than waiting for a timeout. After OpenDuration, a request is let through to probe the backend, which closes
+// the circuit if it succeeds. Only errors of a backend which is unavailable or timing out, see IsUnavailable, are
+// failures. Secrets not being found, already existing or having changed, are successes, while canceled requests and other errors,
+// e.g. access being denied or Put without PUT_SECRET_COMMAND, count as neither.
+type CircuitBreaker struct {
+	Backend
+	Name             string        // Backend name, the label of the state metric
+	FailureThreshold int           // Consecutive failures which open the circuit
+	OpenDuration     time.Duration // How long the circuit stays open before probing the backend
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker around backend.
+func NewCircuitBreaker(name string, backend Backend, failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	b := &CircuitBreaker{Backend: backend, Name: name, FailureThreshold: failureThreshold, OpenDuration: openDuration}
+	circuitState.WithLabelValues(name).Set(float64(CircuitClosed))
+	return b
+}
+
+// State returns the state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Get implements Backend.
+func (b *CircuitBreaker) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	var value []byte
+	err := b.call(ctx, func() (err error) {
+		value, err = b.Backend.Get(ctx, params)
+		return err
+	})
+	return value, err
+}
+
+// List implements Backend.
+func (b *CircuitBreaker) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	var names []string
+	err := b.call(ctx, func() (err error) {
+		names, err = b.Backend.List(ctx, params)
+		return err
+	})
+	return names, err
+}
+
+// GetWithExpiry implements Expirer, if the backend does.
+func (b *CircuitBreaker) GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	var value []byte
+	var expiry time.Time
+	err := b.call(ctx, func() (err error) {
+		value, expiry, err = GetWithExpiry(ctx, b.Backend, params)
+		return err
+	})
+	return value, expiry, err
+}
+
+// Fetch implements Fetcher, if the backend does.
+func (b *CircuitBreaker) Fetch(ctx context.Context, params GetKeychainSecretParams) (FetchedSecret, error) {
+	var secret FetchedSecret
+	err := b.call(ctx, func() (err error) {
+		secret, err = Fetch(ctx, b.Backend, params)
+		return err
+	})
+	return secret, err
+}
+
+// LatestVersion implements Versioner, if the backend does.
+func (b *CircuitBreaker) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	var version string
+	err := b.call(ctx, func() (err error) {
+		version, err = LatestVersion(ctx, b.Backend, params)
+		return err
+	})
+	return version, err
+}
+
+// Put implements Writer, if the backend does.
+func (b *CircuitBreaker) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	var version string
+	err := b.call(ctx, func() (err error) {
+		version, err = Put(ctx, b.Backend, params, value)
+		return err
+	})
+	return version, err
+}
+
+// Watch implements Watcher, if the backend does. Watches are long-lived, so they bypass the circuit.
+func (b *CircuitBreaker) Watch(ctx context.Context, events chan<- BackendEvent) error {
+	watcher, ok := b.Backend.(Watcher)
+	if !ok {
+		return ErrWatchNotSupported
+	}
+	return watcher.Watch(ctx, events)
+}
+
+// Health implements HealthChecker, if the backend does. Health checks bypass the circuit, and are not counted.
+func (b *CircuitBreaker) Health(ctx context.Context) error {
+	return Health(ctx, b.Backend)
+}
+
+// call calls f unless the circuit is open, and records its outcome.
+func (b *CircuitBreaker) call(ctx context.Context, f func() error) error {
+	if !b.allow(time.Now()) {
+		return fmt.Errorf("%s: %w", b.Name, ErrCircuitOpen)
+	}
+	err := f()
+	switch {
+	case err == nil || IsNotFound(err) || IsAlreadyExists(err) || IsConflict(err):
+		b.record(false, time.Now())
+	case IsUnavailable(err) && !errors.Is(ctx.Err(), context.Canceled):
+		b.record(true, time.Now())
+	default:
+		// Requests canceled by the caller, e.g. on shutdown, and errors particular to the request, e.g. one namespace
+		// being denied access or a version the backend does not support, tell nothing about whether it is up.
+		b.release()
+	}
+	return err
+}
+
+// allow reports whether a request may be passed to the backend at now, turning the circuit half-open once it has been
+// open for OpenDuration.
+func (b *CircuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if now.Sub(b.openedAt) >= b.OpenDuration {
+			b.setState(CircuitHalfOpen)
+			return true
+		}
+	}
+	// A probe is in flight while half-open.
+	return false
+}
+
+// record records the outcome of a request passed to the backend at now.
+func (b *CircuitBreaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		if b.state != CircuitClosed {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.state == CircuitClosed && b.failures >= b.FailureThreshold) {
+		b.openedAt = now
+		b.setState(CircuitOpen)
+	}
+}
+
+// release records that a request passed to the backend had no outcome. A probe without outcome leaves the circuit
+// open, but lets the next request probe again right away.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState sets the state of the circuit and its metric. b.mu must be held.
+func (b *CircuitBreaker) setState(state CircuitState) {
+	b.state = state
+	circuitState.WithLabelValues(b.Name).Set(float64(state))
+}
+
+// setBackendUnavailable sets the BackendUnavailable condition of the KeychainSecret from the error fetching its values,
+// which is True when requests were rejected because the circuit of the backend is open.
+func setBackendUnavailable(keychainSecret *aqueductv1.KeychainSecret, err error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionBackendUnavailable, corev1.ConditionTrue, "CircuitOpen", err.Error())
+	} else if keychainSecret.Status.GetCondition(aqueductv1.ConditionBackendUnavailable) != nil {
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionBackendUnavailable, corev1.ConditionFalse, "CircuitClosed", "Backend available")
+	}
+}
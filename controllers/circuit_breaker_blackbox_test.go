@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	up := fakeBackend{values: map[string]string{"SUPER_SECRET": "hunter2"}}
+	empty := fakeBackend{values: map[string]string{}}
+	down := fakeBackend{err: fmt.Errorf("GET /v1/secrets/-/SUPER_SECRET: 503 Service Unavailable: %w", ErrUnavailable)}
+	denied := fakeBackend{err: errors.New("GET /v1/secrets/-/SUPER_SECRET: 403 Forbidden")}
+	unsupported := fakeBackend{err: fmt.Errorf("PUT_SECRET_COMMAND is not set: %w", ErrNotSupported)}
+	hanging := fakeBackend{hang: true}
+	breaker := NewCircuitBreaker("test", empty, 2, 50*time.Millisecond)
+	params := GetKeychainSecretParams{Name: "SUPER_SECRET"}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// get sets the breaker's backend and returns the state of the circuit after a Get, and its error.
+	get := func(ctx context.Context, backend Backend) (CircuitState, error) {
+		breaker.Backend = backend
+		_, err := breaker.Get(ctx, params)
+		return breaker.State(), err
+	}
+
+	var testsTable = []struct {
+		name     string
+		backend  Backend
+		wait     bool
+		canceled bool // Whether the caller cancels the request
+		open     bool // Whether the request is rejected
+		state    CircuitState
+	}{
+		{name: "missing secrets are no failures", backend: empty, state: CircuitClosed},
+		{name: "missing secrets still are no failures", backend: empty, state: CircuitClosed},
+		{name: "unsupported requests are no failures", backend: unsupported, state: CircuitClosed},
+		{name: "denied requests are no failures", backend: denied, state: CircuitClosed},
+		{name: "denied requests still are no failures", backend: denied, state: CircuitClosed},
+		{name: "repeatedly denied requests are no failures", backend: denied, state: CircuitClosed},
+		{name: "first failure", backend: down, state: CircuitClosed},
+		{name: "denied requests do not reset failures", backend: denied, state: CircuitClosed},
+		{name: "canceled requests do not reset failures", backend: hanging, canceled: true, state: CircuitClosed},
+		{name: "threshold opens", backend: down, state: CircuitOpen},
+		{name: "open rejects", backend: up, open: true, state: CircuitOpen},
+		{name: "canceled probe stays open", backend: hanging, canceled: true, wait: true, state: CircuitOpen},
+		{name: "next request probes", backend: down, state: CircuitOpen},
+		{name: "failed probe reopens", backend: down, wait: true, state: CircuitOpen},
+		{name: "reopened rejects", backend: up, open: true, state: CircuitOpen},
+		{name: "successful probe closes", backend: up, wait: true, state: CircuitClosed},
+		{name: "closed passes", backend: up, state: CircuitClosed},
+	}
+
+	for _, tt := range testsTable {
+		if tt.wait {
+			time.Sleep(60 * time.Millisecond)
+		}
+		ctx := context.Background()
+		if tt.canceled {
+			ctx = canceled
+		}
+		state, err := get(ctx, tt.backend)
+		if errors.Is(err, ErrCircuitOpen) != tt.open {
+			t.Errorf("%s: Error observed %v, expected rejection %v", tt.name, err, tt.open)
+		}
+		if state != tt.state {
+			t.Errorf("%s: State observed %v, expected %v", tt.name, state, tt.state)
+		}
+	}
+}
+
+func TestFallbackBackendCircuitOpen(t *testing.T) {
+	down := fakeBackend{err: &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connection refused")}}
+	breaker := NewCircuitBreaker("down", down, 1, time.Hour)
+	backend := &FallbackBackend{Backends: []NamedBackend{{Name: "primary", Backend: down}, {Name: "down", Backend: breaker}}}
+	ctx := context.Background()
+	// The first failure opens the circuit.
+	breaker.Get(ctx, GetKeychainSecretParams{Name: "SUPER_SECRET"})
+
+	_, err := backend.Get(ctx, GetKeychainSecretParams{Name: "SUPER_SECRET"})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Error observed %v, expected the circuit of the last backend to be open", err)
+	}
+}
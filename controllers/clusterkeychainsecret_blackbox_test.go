@@ -0,0 +1,258 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+// newClusterKeychainSecret returns a ClusterKeychainSecret named db, and a KeychainSecret it controls in namespace.
+func newClusterKeychainSecret(namespace string) (*aqueductv1.ClusterKeychainSecret, aqueductv1.KeychainSecret) {
+	owner := &aqueductv1.ClusterKeychainSecret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "ClusterKeychainSecret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", UID: "cluster-uid"},
+		Spec:       aqueductv1.ClusterKeychainSecretSpec{Template: aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h"}},
+	}
+	controller := true
+	child := aqueductv1.KeychainSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "db",
+			Labels: map[string]string{aqueductv1.ClusterKeychainSecretLabel: "db"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: aqueductv1.GroupVersion.String(),
+				Kind: "ClusterKeychainSecret", Name: "db", UID: "cluster-uid", Controller: &controller}}},
+		Spec: owner.Spec.Template,
+	}
+	return owner, child
+}
+
+func TestSelectNamespaces(t *testing.T) {
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments-old", Labels: map[string]string{"team": "payments"}},
+			Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}},
+	}
+
+	var testsTable = []struct {
+		name     string
+		selector metav1.LabelSelector
+		expected []string
+		valid    bool
+	}{
+		{name: "empty selector selects every active namespace", expected: []string{"default", "payments"}, valid: true},
+		{name: "labels select", selector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			expected: []string{"payments"}, valid: true},
+		{name: "nothing selected", selector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+			expected: []string{}, valid: true},
+		{name: "invalid selectors are errors", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "team", Operator: "Resembles"}}}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := SelectNamespaces(tt.selector, namespaces)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			names := []string{}
+			for name := range selected {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("Selected %v, expected %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStaleKeychainSecrets(t *testing.T) {
+	owner, selected := newClusterKeychainSecret("default")
+	_, unselected := newClusterKeychainSecret("payments")
+	unowned := aqueductv1.KeychainSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "db",
+		Labels: map[string]string{aqueductv1.ClusterKeychainSecretLabel: "db"}}}
+
+	var testsTable = []struct {
+		name     string
+		children []aqueductv1.KeychainSecret
+		expected []string
+	}{
+		{name: "selected namespaces are kept", children: []aqueductv1.KeychainSecret{selected}},
+		{name: "unselected namespaces are stale", children: []aqueductv1.KeychainSecret{selected, unselected},
+			expected: []string{"payments"}},
+		{name: "KeychainSecrets not controlled are never stale", children: []aqueductv1.KeychainSecret{unowned}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var namespaces []string
+			for _, child := range StaleKeychainSecrets(owner, tt.children, map[string]struct{}{"default": {}}) {
+				namespaces = append(namespaces, child.Namespace)
+			}
+			if !reflect.DeepEqual(namespaces, tt.expected) {
+				t.Errorf("Stale in %v, expected %v", namespaces, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNamespaceStatus(t *testing.T) {
+	owner, pending := newClusterKeychainSecret("default")
+	synced := *pending.DeepCopy()
+	synced.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionTrue, "Synced", "Secret synced from Keychain")
+	failed := *pending.DeepCopy()
+	failed.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, "SyncFailed", "connection refused")
+	unowned := *synced.DeepCopy()
+	unowned.OwnerReferences = nil
+
+	var testsTable = []struct {
+		name   string
+		child  aqueductv1.KeychainSecret
+		ready  corev1.ConditionStatus
+		reason string
+		owned  bool
+	}{
+		{name: "not yet synced", child: pending, ready: corev1.ConditionUnknown, reason: "Pending", owned: true},
+		{name: "synced", child: synced, ready: corev1.ConditionTrue, reason: "Synced", owned: true},
+		{name: "failed", child: failed, ready: corev1.ConditionFalse, reason: "SyncFailed", owned: true},
+		{name: "unowned KeychainSecrets are not taken over", child: unowned, ready: corev1.ConditionFalse, reason: "Conflict", owned: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			status, owned := NamespaceStatus(owner, &tt.child)
+			if owned != tt.owned {
+				t.Errorf("Owned %v, expected %v", owned, tt.owned)
+			}
+			if status.Namespace != "default" || status.Ready != tt.ready || status.Reason != tt.reason {
+				t.Errorf("Status %s/%s/%s, expected default/%s/%s", status.Namespace, status.Ready, status.Reason, tt.ready, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSetNamespaceStatuses(t *testing.T) {
+	ready := func(namespace string, status corev1.ConditionStatus) aqueductv1.ClusterKeychainSecretNamespaceStatus {
+		return aqueductv1.ClusterKeychainSecretNamespaceStatus{Namespace: namespace, Ready: status}
+	}
+
+	var testsTable = []struct {
+		name       string
+		statuses   []aqueductv1.ClusterKeychainSecretNamespaceStatus
+		namespaces []string
+		ready      corev1.ConditionStatus
+		message    string
+	}{
+		{name: "no namespaces", namespaces: []string{}, ready: corev1.ConditionTrue, message: "Secret synced to 0 namespaces"},
+		{name: "ready everywhere", statuses: []aqueductv1.ClusterKeychainSecretNamespaceStatus{
+			ready("payments", corev1.ConditionTrue), ready("default", corev1.ConditionTrue)},
+			namespaces: []string{"default", "payments"}, ready: corev1.ConditionTrue, message: "Secret synced to 2 namespaces"},
+		{name: "pending and failed namespaces are not ready", statuses: []aqueductv1.ClusterKeychainSecretNamespaceStatus{
+			ready("web", corev1.ConditionUnknown), ready("payments", corev1.ConditionFalse), ready("default", corev1.ConditionTrue)},
+			namespaces: []string{"default", "payments", "web"}, ready: corev1.ConditionFalse,
+			message: "Secret not yet synced to 2 of 3 namespaces"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, _ := newClusterKeychainSecret("default")
+			SetNamespaceStatuses(owner, tt.statuses)
+			namespaces := []string{}
+			for _, status := range owner.Status.Namespaces {
+				namespaces = append(namespaces, status.Namespace)
+			}
+			if !reflect.DeepEqual(namespaces, tt.namespaces) {
+				t.Errorf("Namespaces %v, expected %v", namespaces, tt.namespaces)
+			}
+			condition := owner.Status.GetCondition(aqueductv1.ConditionReady)
+			if condition == nil || condition.Status != tt.ready || condition.Message != tt.message {
+				t.Errorf("Ready %+v, expected %s: %s", condition, tt.ready, tt.message)
+			}
+		})
+	}
+}
+
+func TestClusterKeychainSecretReconcile(t *testing.T) {
+	owner, stale := newClusterKeychainSecret("payments-old")
+	unowned := aqueductv1.KeychainSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "db"},
+		Spec: aqueductv1.KeychainSecretSpec{Name: "WEB_PASSWORD", TTL: "1h"}}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = aqueductv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme, owner, &stale, &unowned,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}})
+	// Only payments-old is no longer selected, since it no longer exists.
+	r := &ClusterKeychainSecretReconciler{Client: c, Log: ctrl.Log, Scheme: scheme}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "db"}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	var testsTable = []struct {
+		namespace string
+		exists    bool
+		spec      aqueductv1.KeychainSecretSpec
+	}{
+		{namespace: "default", exists: true, spec: owner.Spec.Template},
+		{namespace: "web", exists: true, spec: unowned.Spec},
+		{namespace: "payments", exists: false},
+		{namespace: "payments-old", exists: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.namespace, func(t *testing.T) {
+			var child aqueductv1.KeychainSecret
+			err := c.Get(context.Background(), types.NamespacedName{Namespace: tt.namespace, Name: "db"}, &child)
+			if exists := err == nil; exists != tt.exists {
+				t.Fatalf("Exists %v (%v), expected %v", exists, err, tt.exists)
+			}
+			if tt.exists && !reflect.DeepEqual(child.Spec, tt.spec) {
+				t.Errorf("Spec %+v, expected %+v", child.Spec, tt.spec)
+			}
+		})
+	}
+
+	var updated aqueductv1.ClusterKeychainSecret
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "db"}, &updated); err != nil {
+		t.Fatalf("Get ClusterKeychainSecret failed: %v", err)
+	}
+	var reasons []string
+	for _, status := range updated.Status.Namespaces {
+		reasons = append(reasons, status.Namespace+":"+status.Reason)
+	}
+	if expected := []string{"default:Pending", "web:Conflict"}; !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Namespace statuses %v, expected %v", reasons, expected)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// ClusterKeychainSecretReconciler reconciles a ClusterKeychainSecret object. It does so by maintaining a KeychainSecret,
+// owned by the ClusterKeychainSecret, in each selected namespace. Those are reconciled by the KeychainSecretReconciler
+// like any other.
+type ClusterKeychainSecretReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=clusterkeychainsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=clusterkeychainsecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// Reconcile is called when a watched resource needs to be reconciled.
+func (r *ClusterKeychainSecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	var clusterKeychainSecret aqueductv1.ClusterKeychainSecret
+
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterkeychainsecret", req.Name)
+
+	if err := r.Get(ctx, req.NamespacedName, &clusterKeychainSecret); err != nil {
+		// Owned KeychainSecrets are garbage collected, so there is nothing to clean up.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	namespaces, err := r.selectedNamespaces(ctx, clusterKeychainSecret)
+	if err != nil {
+		log.Error(err, "unable to list namespaces")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	var children aqueductv1.KeychainSecretList
+	if err := r.List(ctx, &children, client.MatchingLabels{aqueductv1.ClusterKeychainSecretLabel: clusterKeychainSecret.Name}); err != nil {
+		log.Error(err, "unable to list KeychainSecrets")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	// Remove KeychainSecrets from namespaces which are no longer selected. Their Secrets are garbage collected.
+	for _, child := range StaleKeychainSecrets(&clusterKeychainSecret, children.Items, namespaces) {
+		log.Info("removing KeychainSecret", "namespace", child.Namespace)
+		if err := r.Delete(ctx, child); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+		}
+	}
+
+	// Create or update a KeychainSecret in every selected namespace, collecting each one's status.
+	var statuses []aqueductv1.ClusterKeychainSecretNamespaceStatus
+	for namespace := range namespaces {
+		statuses = append(statuses, r.syncNamespace(ctx, clusterKeychainSecret, namespace))
+	}
+	SetNamespaceStatuses(&clusterKeychainSecret, statuses)
+	if err := r.Status().Update(ctx, &clusterKeychainSecret); err != nil {
+		log.Error(err, "unable to update ClusterKeychainSecret status")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// selectedNamespaces returns the names of active namespaces matching the ClusterKeychainSecret's selector.
+func (r *ClusterKeychainSecretReconciler) selectedNamespaces(ctx context.Context, clusterKeychainSecret aqueductv1.ClusterKeychainSecret) (map[string]struct{}, error) {
+	var namespaceList corev1.NamespaceList
+	if err := r.List(ctx, &namespaceList); err != nil {
+		return nil, err
+	}
+	return SelectNamespaces(clusterKeychainSecret.Spec.NamespaceSelector, namespaceList.Items)
+}
+
+// SelectNamespaces returns the names of the namespaces matching selector. Terminating namespaces are skipped, since
+// nothing can be created in them.
+func SelectNamespaces(selector metav1.LabelSelector, namespaces []corev1.Namespace) (map[string]struct{}, error) {
+	labelSelector, err := metav1.LabelSelectorAsSelector(&selector)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]struct{}{}
+	for _, namespace := range namespaces {
+		if namespace.Status.Phase == corev1.NamespaceTerminating || !labelSelector.Matches(labels.Set(namespace.Labels)) {
+			continue
+		}
+		selected[namespace.Name] = struct{}{}
+	}
+	return selected, nil
+}
+
+// StaleKeychainSecrets returns the children controlled by the ClusterKeychainSecret in namespaces which are no longer
+// selected.
+func StaleKeychainSecrets(clusterKeychainSecret *aqueductv1.ClusterKeychainSecret, children []aqueductv1.KeychainSecret, namespaces map[string]struct{}) []*aqueductv1.KeychainSecret {
+	var stale []*aqueductv1.KeychainSecret
+	for i := range children {
+		child := &children[i]
+		if _, ok := namespaces[child.Namespace]; ok || !metav1.IsControlledBy(child, clusterKeychainSecret) {
+			continue
+		}
+		stale = append(stale, child)
+	}
+	return stale
+}
+
+// NamespaceStatus returns the status of the ClusterKeychainSecret in the namespace of child, its existing
+// KeychainSecret there, and whether it controls child and so may update it. A KeychainSecret somebody else created is
+// never taken over.
+func NamespaceStatus(clusterKeychainSecret *aqueductv1.ClusterKeychainSecret, child *aqueductv1.KeychainSecret) (aqueductv1.ClusterKeychainSecretNamespaceStatus, bool) {
+	status := aqueductv1.ClusterKeychainSecretNamespaceStatus{Namespace: child.Namespace, Ready: corev1.ConditionFalse}
+	if !metav1.IsControlledBy(child, clusterKeychainSecret) {
+		status.Reason = "Conflict"
+		status.Message = fmt.Sprintf("keychainsecret %s/%s exists and is not owned by this ClusterKeychainSecret", child.Namespace, child.Name)
+		return status, false
+	}
+
+	status.LastUpdate = child.Status.LastUpdate
+	ready := child.Status.GetCondition(aqueductv1.ConditionReady)
+	if ready == nil {
+		status.Ready, status.Reason, status.Message = corev1.ConditionUnknown, "Pending", "KeychainSecret not yet synced"
+		return status, true
+	}
+	status.Ready, status.Reason, status.Message = ready.Status, ready.Reason, ready.Message
+	return status, true
+}
+
+// SetNamespaceStatuses records the status of the ClusterKeychainSecret in each of its namespaces, and sets its Ready
+// condition to whether it is ready in all of them.
+func SetNamespaceStatuses(clusterKeychainSecret *aqueductv1.ClusterKeychainSecret, statuses []aqueductv1.ClusterKeychainSecretNamespaceStatus) {
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Namespace < statuses[j].Namespace })
+
+	notReady := 0
+	for _, status := range statuses {
+		if status.Ready != corev1.ConditionTrue {
+			notReady++
+		}
+	}
+	clusterKeychainSecret.Status.Namespaces = statuses
+	if notReady == 0 {
+		clusterKeychainSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionTrue, "Synced",
+			fmt.Sprintf("Secret synced to %d namespaces", len(statuses)))
+	} else {
+		clusterKeychainSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, "NotSynced",
+			fmt.Sprintf("Secret not yet synced to %d of %d namespaces", notReady, len(statuses)))
+	}
+}
+
+// syncNamespace creates or updates the KeychainSecret in namespace, and returns its status.
+func (r *ClusterKeychainSecretReconciler) syncNamespace(ctx context.Context, clusterKeychainSecret aqueductv1.ClusterKeychainSecret, namespace string) aqueductv1.ClusterKeychainSecretNamespaceStatus {
+	status := aqueductv1.ClusterKeychainSecretNamespaceStatus{Namespace: namespace, Ready: corev1.ConditionFalse}
+	log := r.Log.WithValues("clusterkeychainsecret", clusterKeychainSecret.Name, "namespace", namespace)
+
+	child := &aqueductv1.KeychainSecret{}
+	key := client.ObjectKey{Namespace: namespace, Name: clusterKeychainSecret.Name}
+	if err := r.Get(ctx, key, child); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			status.Reason, status.Message = "GetFailed", err.Error()
+			return status
+		}
+
+		child = &aqueductv1.KeychainSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      clusterKeychainSecret.Name,
+				Labels:    map[string]string{aqueductv1.ClusterKeychainSecretLabel: clusterKeychainSecret.Name},
+			},
+			Spec: clusterKeychainSecret.Spec.Template,
+		}
+		if err := controllerutil.SetControllerReference(&clusterKeychainSecret, child, r.Scheme); err != nil {
+			status.Reason, status.Message = "CreateFailed", err.Error()
+			return status
+		}
+		log.Info("creating KeychainSecret")
+		if err := r.Create(ctx, child); err != nil {
+			status.Reason, status.Message = "CreateFailed", err.Error()
+			return status
+		}
+		status.Ready, status.Reason, status.Message = corev1.ConditionUnknown, "Pending", "KeychainSecret created"
+		return status
+	}
+
+	status, owned := NamespaceStatus(&clusterKeychainSecret, child)
+	if !owned {
+		return status
+	}
+	if !equality.Semantic.DeepEqual(child.Spec, clusterKeychainSecret.Spec.Template) {
+		original := child.DeepCopy()
+		child.Spec = clusterKeychainSecret.Spec.Template
+		log.Info("updating KeychainSecret")
+		if err := r.Patch(ctx, child, client.MergeFrom(original)); err != nil {
+			status.Ready, status.Reason, status.Message = corev1.ConditionFalse, "UpdateFailed", err.Error()
+			return status
+		}
+	}
+	return status
+}
+
+// SetupWithManager sets up the controller with manager.
+func (r *ClusterKeychainSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Any namespace event may change which namespaces any ClusterKeychainSecret selects.
+	namespaceToRequests := handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+		var clusterKeychainSecrets aqueductv1.ClusterKeychainSecretList
+		if err := mgr.GetClient().List(context.Background(), &clusterKeychainSecrets); err != nil {
+			r.Log.Error(err, "unable to list ClusterKeychainSecrets")
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(clusterKeychainSecrets.Items))
+		for _, clusterKeychainSecret := range clusterKeychainSecrets.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: clusterKeychainSecret.Name}})
+		}
+		return requests
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aqueductv1.ClusterKeychainSecret{}).
+		Owns(&aqueductv1.KeychainSecret{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: namespaceToRequests}).
+		Complete(r)
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -29,6 +30,32 @@ import (
 
 const (
 	defaultTimeout = 5 * time.Minute
+
+	// GenerateCertCommandEnv names the environment variable holding the template for ProvisionServiceIdentity.
+	GenerateCertCommandEnv = "GENERATE_CERT_COMMAND"
+	// GetSecretCommandEnv names the environment variable holding the template for GetKeychainSecret.
+	GetSecretCommandEnv = "GET_SECRET_COMMAND"
+	// ListSecretsCommandEnv names the environment variable holding the template for ListKeychainSecrets.
+	ListSecretsCommandEnv = "LIST_SECRETS_COMMAND"
+	// GetSecretVersionCommandEnv names the environment variable holding the template for GetKeychainSecretVersion.
+	// It is optional; without it versions are unknown.
+	GetSecretVersionCommandEnv = "GET_SECRET_VERSION_COMMAND"
+	// GetSecretExpiryCommandEnv names the environment variable holding the template for GetKeychainSecretExpiry. It is
+	// optional; without it secrets do not expire.
+	GetSecretExpiryCommandEnv = "GET_SECRET_EXPIRY_COMMAND"
+	// PutSecretCommandEnv names the environment variable holding the template for PutKeychainSecret. It is optional;
+	// without it secrets cannot be generated.
+	PutSecretCommandEnv = "PUT_SECRET_COMMAND"
+
+	// NotFoundExitCode is the exit code of GET_SECRET_COMMAND for secrets which do not exist. Any other non-zero exit
+	// code is a failure to get the secret.
+	NotFoundExitCode = 3
+	// AlreadyExistsExitCode is the exit code of PUT_SECRET_COMMAND for secrets which exist when .IfAbsent is set. The
+	// command must check and create the secret atomically, or secrets generated concurrently overwrite each other.
+	AlreadyExistsExitCode = 4
+	// ConflictExitCode is the exit code of PUT_SECRET_COMMAND for secrets whose latest version is not .IfVersion, when
+	// it is set. The command must check the version and store the value atomically.
+	ConflictExitCode = 5
 )
 
 // Command encapsulates a command to run.
@@ -36,6 +63,7 @@ type Command struct {
 	Command string        // Name of command (relative or absolute)
 	Args    []string      // Slice of arguments for command
 	Timeout time.Duration // Number of seconds before process times out
+	Stdin   []byte        // Optional input of the command
 }
 
 // RunCommand runs command with arguments and a timeout. If the timeout expires
@@ -55,6 +83,9 @@ func RunCommand(ctx context.Context, command Command) ([]byte, error) {
 
 	// TODO: Create the command with our context
 	cmd := exec.CommandContext(newCtx, absPath, command.Args...)
+	if command.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(command.Stdin)
+	}
 	output, err := cmd.Output()
 
 	// Check the context error to see if a timeout occurred. The error returned
@@ -74,6 +105,26 @@ func RunCommand(ctx context.Context, command Command) ([]byte, error) {
 	return output, nil
 }
 
+// RenderCommand executes a command template with params and splits the result into a Command. Arguments are split on
+// whitespace, without any shell quoting.
+func RenderCommand(name, tmpl string, params interface{}) (Command, error) {
+	var buf strings.Builder
+
+	t, err := template.New(name).Parse(tmpl)
+	if err != nil {
+		return Command{}, err
+	}
+	if err := t.Execute(&buf, params); err != nil {
+		return Command{}, err
+	}
+
+	fields := strings.Fields(buf.String())
+	if len(fields) == 0 {
+		return Command{}, fmt.Errorf("%s template rendered an empty command", name)
+	}
+	return Command{Command: fields[0], Args: fields[1:], Timeout: defaultTimeout}, nil
+}
+
 // ProvisionServiceIdentityParams is used when templating ProvisionServiceIdentity commands
 type ProvisionServiceIdentityParams struct {
 	Algorithm string
@@ -81,21 +132,19 @@ type ProvisionServiceIdentityParams struct {
 	Subject   string
 }
 
+// RenderProvisionServiceIdentity renders the GENERATE_CERT_COMMAND template without running it.
+func RenderProvisionServiceIdentity(params ProvisionServiceIdentityParams) (Command, error) {
+	return RenderCommand(GenerateCertCommandEnv, os.Getenv(GenerateCertCommandEnv), params)
+}
+
 // ProvisionServiceIdentity shells out to create a certificate and returns it
 func ProvisionServiceIdentity(ctx context.Context, params ProvisionServiceIdentityParams) ([]byte, error) {
-	var buf strings.Builder
-
-	tmpl := os.Getenv("GENERATE_CERT_COMMAND")
-	fmt.Printf("tmpl %v\n", tmpl)
-	t := template.Must(template.New("GetServiceIdentity").Parse(tmpl))
-	t.Execute(&buf, params)
-
-	fields := strings.Fields(buf.String())
-	fmt.Printf("%v\n", fields)
-	command := fields[0]
-	args := fields[1:]
+	command, err := RenderProvisionServiceIdentity(params)
+	if err != nil {
+		return nil, err
+	}
 
-	cert, err := RunCommand(ctx, Command{Command: command, Args: args, Timeout: defaultTimeout})
+	cert, err := RunCommand(ctx, command)
 	if err != nil {
 		return nil, err
 	}
@@ -104,29 +153,147 @@ func ProvisionServiceIdentity(ctx context.Context, params ProvisionServiceIdenti
 
 // GetKeychainSecretParams is used when templating GetKeychainSecret commands
 type GetKeychainSecretParams struct {
-	Name  string
-	Group string
+	Name    string
+	Group   string
+	Version string // Empty for the latest version
+}
+
+// RenderGetKeychainSecret renders the GET_SECRET_COMMAND template without running it.
+func RenderGetKeychainSecret(params GetKeychainSecretParams) (Command, error) {
+	return RenderCommand(GetSecretCommandEnv, os.Getenv(GetSecretCommandEnv), params)
 }
 
 // GetKeychainSecret shells out to get a Keychain secret and returns it
 func GetKeychainSecret(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
-	var buf strings.Builder
+	command, err := RenderGetKeychainSecret(params)
+	if err != nil {
+		return nil, err
+	}
 
-	tmpl := os.Getenv("GET_SECRET_COMMAND")
-	fmt.Printf("tmpl %v\n", tmpl)
-	t := template.Must(template.New("GetServiceIdentity").Parse(tmpl))
-	t.Execute(&buf, params)
+	secret, err := RunCommand(ctx, command)
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == NotFoundExitCode {
+		return nil, fmt.Errorf("%s: %w", params.Name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
 
-	fields := strings.Fields(buf.String())
-	fmt.Printf("%v\n", fields)
-	command := fields[0]
-	args := fields[1:]
+// RenderGetKeychainSecretVersion renders the GET_SECRET_VERSION_COMMAND template without running it.
+func RenderGetKeychainSecretVersion(params GetKeychainSecretParams) (Command, error) {
+	return RenderCommand(GetSecretVersionCommandEnv, os.Getenv(GetSecretVersionCommandEnv), params)
+}
+
+// GetKeychainSecretVersion shells out to get the latest version of a Keychain secret. The command is expected to print
+// the version. If GET_SECRET_VERSION_COMMAND is not set the version is unknown, and empty.
+func GetKeychainSecretVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	if os.Getenv(GetSecretVersionCommandEnv) == "" {
+		return "", nil
+	}
+	command, err := RenderGetKeychainSecretVersion(params)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := RunCommand(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RenderGetKeychainSecretExpiry renders the GET_SECRET_EXPIRY_COMMAND template without running it.
+func RenderGetKeychainSecretExpiry(params GetKeychainSecretParams) (Command, error) {
+	return RenderCommand(GetSecretExpiryCommandEnv, os.Getenv(GetSecretExpiryCommandEnv), params)
+}
+
+// GetKeychainSecretExpiry shells out to get the expiry of a Keychain secret. The command is expected to print an RFC
+// 3339 timestamp, or nothing if the secret does not expire. If GET_SECRET_EXPIRY_COMMAND is not set secrets do not
+// expire, and the zero time is returned.
+func GetKeychainSecretExpiry(ctx context.Context, params GetKeychainSecretParams) (time.Time, error) {
+	if os.Getenv(GetSecretExpiryCommandEnv) == "" {
+		return time.Time{}, nil
+	}
+	command, err := RenderGetKeychainSecretExpiry(params)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	cert, err := RunCommand(ctx, Command{Command: command, Args: args, Timeout: defaultTimeout})
+	output, err := RunCommand(ctx, command)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiry := strings.TrimSpace(string(output))
+	if expiry == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, expiry)
+}
+
+// PutKeychainSecretParams is used when templating PutKeychainSecret commands
+type PutKeychainSecretParams struct {
+	Name      string
+	Group     string
+	IfAbsent  bool   // Only create the secret, failing with AlreadyExistsExitCode if it exists
+	IfVersion string // Only replace this version of the secret, failing with ConflictExitCode if it is not the latest
+}
+
+// RenderPutKeychainSecret renders the PUT_SECRET_COMMAND template without running it.
+func RenderPutKeychainSecret(params PutKeychainSecretParams) (Command, error) {
+	return RenderCommand(PutSecretCommandEnv, os.Getenv(PutSecretCommandEnv), params)
+}
+
+// PutKeychainSecret shells out to store value as the new version of a Keychain secret. The value is written to the
+// command's standard input, never to its arguments. The command may print the new version.
+func PutKeychainSecret(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	if os.Getenv(PutSecretCommandEnv) == "" {
+		return "", fmt.Errorf("%s is not set: %w", PutSecretCommandEnv, ErrNotSupported)
+	}
+	command, err := RenderPutKeychainSecret(params)
+	if err != nil {
+		return "", err
+	}
+	command.Stdin = value
+
+	output, err := RunCommand(ctx, command)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		switch {
+		case params.IfAbsent && exitErr.ExitCode() == AlreadyExistsExitCode:
+			return "", fmt.Errorf("%s: %w", params.Name, ErrAlreadyExists)
+		case params.IfVersion != "" && exitErr.ExitCode() == ConflictExitCode:
+			return "", fmt.Errorf("%s: %w", params.Name, ErrConflict)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ListKeychainSecretsParams is used when templating ListKeychainSecrets commands
+type ListKeychainSecretsParams struct {
+	Group string
+}
+
+// RenderListKeychainSecrets renders the LIST_SECRETS_COMMAND template without running it.
+func RenderListKeychainSecrets(params ListKeychainSecretsParams) (Command, error) {
+	return RenderCommand(ListSecretsCommandEnv, os.Getenv(ListSecretsCommandEnv), params)
+}
+
+// ListKeychainSecrets shells out to list the secrets in a Keychain group. The command is expected to print their
+// names separated by whitespace.
+func ListKeychainSecrets(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	command, err := RenderListKeychainSecrets(params)
 	if err != nil {
 		return nil, err
 	}
-	return cert, nil
+
+	output, err := RunCommand(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
 }
 
 /*
@@ -59,3 +59,44 @@ func TestRunCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderCommand(t *testing.T) {
+	var testsTable = []struct {
+		name    string
+		tmpl    string
+		params  interface{}
+		command string
+		args    []string
+		valid   bool
+	}{
+		{name: "params are substituted", tmpl: "echo -n {{.Group}}_{{.Name}}", params: GetKeychainSecretParams{Group: "G", Name: "N"},
+			command: "echo", args: []string{"-n", "G_N"}, valid: true},
+		{name: "empty commands are rejected", tmpl: "{{.Group}}", params: GetKeychainSecretParams{}, valid: false},
+		{name: "invalid templates are rejected", tmpl: "echo {{.Group", params: GetKeychainSecretParams{}, valid: false},
+		{name: "versions are substituted", tmpl: "get {{.Name}}{{if .Version}} --version {{.Version}}{{end}}",
+			params: GetKeychainSecretParams{Name: "N", Version: "3"}, command: "get", args: []string{"N", "--version", "3"}, valid: true},
+		{name: "unknown fields are rejected", tmpl: "echo {{.Revision}}", params: GetKeychainSecretParams{}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			command, err := RenderCommand(tt.name, tt.tmpl, tt.params)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if command.Command != tt.command || !reflect.DeepEqual(command.Args, tt.args) {
+				t.Errorf("Command observed %v %v, expected %v %v", command.Command, command.Args, tt.command, tt.args)
+			}
+		})
+	}
+}
+
+func TestRunCommandStdin(t *testing.T) {
+	output, err := RunCommand(context.Background(), Command{Command: "cat", Timeout: defaultTimeout, Stdin: []byte("hunter2")})
+	if err != nil || string(output) != "hunter2" {
+		t.Errorf("Output %q (%v), expected the input", output, err)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// suspend records that the KeychainSecret is suspended. Nothing is requeued; unsetting spec.suspend triggers the next
+// reconcile.
+func (r *KeychainSecretReconciler) suspend(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret) (ctrl.Result, error) {
+	if keychainSecret.Status.IsConditionTrue(aqueductv1.ConditionSuspended) {
+		return ctrl.Result{}, nil
+	}
+
+	r.Log.Info("suspended", "keychainsecret", types.NamespacedName{Namespace: keychainSecret.Namespace, Name: keychainSecret.Name})
+	keychainSecret.Status.Reason = "Suspended"
+	keychainSecret.Status.Message = "Syncing is suspended"
+	keychainSecret.Status.SetCondition(aqueductv1.ConditionSuspended, corev1.ConditionTrue, "Suspended", keychainSecret.Status.Message)
+	if err := r.Status().Update(ctx, keychainSecret); err != nil {
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// dryRun compares data with the target's current data and records the differences in the KeychainSecret's status,
+// without writing the target.
+func (r *KeychainSecretReconciler) dryRun(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, data map[string][]byte, now time.Time, ttl time.Duration, refreshRequest string) (ctrl.Result, error) {
+	current, exists, err := r.targetData(ctx, keychainSecret)
+	if err != nil {
+		return r.fail(ctx, keychainSecret, "GetFailed", err)
+	}
+
+	result := &aqueductv1.DryRunResult{
+		ObservedGeneration: keychainSecret.Generation,
+		Time:               metav1.NewTime(now),
+		ContentHash:        HashSecretData(data),
+	}
+	for key := range data {
+		result.Keys = append(result.Keys, key)
+	}
+	sort.Strings(result.Keys)
+	if exists {
+		result.CurrentContentHash = HashSecretData(current)
+	}
+	result.AddedKeys, result.ChangedKeys, result.RemovedKeys = DiffSecretData(current, data)
+
+	keychainSecret.Status.DryRun = result
+	keychainSecret.Status.LastRefreshRequest = refreshRequest
+	keychainSecret.Status.Reason = "DryRun"
+	keychainSecret.Status.Message = fmt.Sprintf("Dry run: %d keys would be added, %d changed and %d removed",
+		len(result.AddedKeys), len(result.ChangedKeys), len(result.RemovedKeys))
+	if err := r.Status().Update(ctx, keychainSecret); err != nil {
+		r.Log.Error(err, "unable to update KeychainSecret status", "keychainsecret", keychainSecret.ObjectMeta.Name)
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	key := types.NamespacedName{Namespace: keychainSecret.Namespace, Name: keychainSecret.Name}
+	return ctrl.Result{RequeueAfter: r.scheduler().NextRotation(key, now, ttl).Sub(now)}, nil
+}
+
+// DiffSecretData returns the sorted keys which are in desired but not current, in both but with different values, and
+// in current but not desired.
+func DiffSecretData(current, desired map[string][]byte) (added, changed, removed []string) {
+	for key, value := range desired {
+		currentValue, ok := current[key]
+		switch {
+		case !ok:
+			added = append(added, key)
+		case !bytes.Equal(currentValue, value):
+			changed = append(changed, key)
+		}
+	}
+	for key := range current {
+		if _, ok := desired[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestDiffSecretData(t *testing.T) {
+	var testsTable = []struct {
+		name    string
+		current map[string][]byte
+		desired map[string][]byte
+		added   []string
+		changed []string
+		removed []string
+	}{
+		{name: "new target", current: nil, desired: map[string][]byte{"b": []byte("2"), "a": []byte("1")},
+			added: []string{"a", "b"}},
+		{name: "unchanged", current: map[string][]byte{"a": []byte("1")}, desired: map[string][]byte{"a": []byte("1")}},
+		{name: "changed and removed", current: map[string][]byte{"a": []byte("1"), "b": []byte("2")},
+			desired: map[string][]byte{"a": []byte("3"), "c": []byte("4")},
+			added:   []string{"c"}, changed: []string{"a"}, removed: []string{"b"}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			added, changed, removed := DiffSecretData(tt.current, tt.desired)
+			if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(changed, tt.changed) || !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("Diff observed added %v changed %v removed %v, expected added %v changed %v removed %v",
+					added, changed, removed, tt.added, tt.changed, tt.removed)
+			}
+		})
+	}
+}
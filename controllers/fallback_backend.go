@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NamedBackend is a Backend with the name it is configured and selected by, e.g. "command".
+type NamedBackend struct {
+	Name string
+	Backend
+}
+
+// FallbackBackend is a Backend which tries an ordered list of backends: requests are served by the first backend,
+// falling back to the next one when a backend is unavailable, i.e. fails or times out. Secrets which do not exist in a
+// backend are not looked up in the next ones, so a backend which is up to date is never overridden by a stale one.
+type FallbackBackend struct {
+	Backends []NamedBackend
+	// Timeout limits each attempt, but the last, so a backend which hangs is fallen back from. Attempts are only
+	// limited by the backends' own timeouts if it is zero.
+	Timeout time.Duration
+}
+
+// Select returns a FallbackBackend of the backends named by names, in that order, e.g. as requested by a
+// KeychainSecret.
+func (b *FallbackBackend) Select(names []string) (*FallbackBackend, error) {
+	selected := &FallbackBackend{Timeout: b.Timeout}
+	for _, name := range names {
+		found := false
+		for _, named := range b.Backends {
+			if named.Name == name {
+				selected.Backends = append(selected.Backends, named)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("backend %q is not configured", name)
+		}
+	}
+	return selected, nil
+}
+
+// Get implements Backend.
+func (b *FallbackBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	var value []byte
+	err := b.try(ctx, func(ctx context.Context, backend Backend) (err error) {
+		value, err = backend.Get(ctx, params)
+		return err
+	})
+	return value, err
+}
+
+// List implements Backend.
+func (b *FallbackBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	var names []string
+	err := b.try(ctx, func(ctx context.Context, backend Backend) (err error) {
+		names, err = backend.List(ctx, params)
+		return err
+	})
+	return names, err
+}
+
+// GetWithExpiry implements Expirer. Values of backends which are not Expirers do not expire.
+func (b *FallbackBackend) GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	var value []byte
+	var expiry time.Time
+	err := b.try(ctx, func(ctx context.Context, backend Backend) (err error) {
+		value, expiry, err = GetWithExpiry(ctx, backend, params)
+		return err
+	})
+	return value, expiry, err
+}
+
+// Fetch implements Fetcher, fetching the value and its version from the same backend.
+func (b *FallbackBackend) Fetch(ctx context.Context, params GetKeychainSecretParams) (FetchedSecret, error) {
+	var secret FetchedSecret
+	err := b.try(ctx, func(ctx context.Context, backend Backend) (err error) {
+		secret, err = Fetch(ctx, backend, params)
+		return err
+	})
+	return secret, err
+}
+
+// LatestVersion implements Versioner. The version is unknown if the backend serving it is not a Versioner.
+func (b *FallbackBackend) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	var version string
+	err := b.try(ctx, func(ctx context.Context, backend Backend) (err error) {
+		version, err = LatestVersion(ctx, backend, params)
+		return err
+	})
+	return version, err
+}
+
+// Put implements Writer, storing the secret with the first backend which is a Writer. Writes do not fall back, so
+// the values of a secret are never split between backends.
+func (b *FallbackBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	for _, named := range b.Backends {
+		if writer, ok := named.Backend.(Writer); ok {
+			version, err := writer.Put(ctx, params, value)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", named.Name, err)
+			}
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("backend cannot store secrets: %w", ErrNotSupported)
+}
+
+// Watch implements Watcher, watching every backend which is a Watcher, since any of them may serve a secret which
+// changed.
+func (b *FallbackBackend) Watch(ctx context.Context, events chan<- BackendEvent) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(b.Backends))
+	watching := 0
+	for _, named := range b.Backends {
+		if watcher, ok := named.Backend.(Watcher); ok {
+			watching++
+			go func(name string) {
+				if err := watcher.Watch(ctx, events); err != nil && err != ErrWatchNotSupported {
+					errs <- fmt.Errorf("%s: %w", name, err)
+					return
+				}
+				errs <- nil
+			}(named.Name)
+		}
+	}
+	for ; watching > 0; watching-- {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return ErrWatchNotSupported
+}
+
+// Health implements HealthChecker. A FallbackBackend is healthy as long as any of its backends is.
+func (b *FallbackBackend) Health(ctx context.Context) error {
+	var failures []string
+	for _, named := range b.Backends {
+		err := Health(ctx, named.Backend)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", named.Name, err))
+	}
+	return fmt.Errorf("no backend healthy: %s", strings.Join(failures, "; "))
+}
+
+// try calls f with each backend in turn, until one succeeds or reports that the secret does not exist.
+func (b *FallbackBackend) try(ctx context.Context, f func(ctx context.Context, backend Backend) error) error {
+	if len(b.Backends) == 0 {
+		return errors.New("no backends configured")
+	}
+	unavailable := &unavailableError{}
+	for i, named := range b.Backends {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		if b.Timeout > 0 && i < len(b.Backends)-1 {
+			attemptCtx, cancel = context.WithTimeout(ctx, b.Timeout)
+		}
+		err := f(attemptCtx, named.Backend)
+		cancel()
+		if err == nil {
+			recordServedBy(ctx, named.Name)
+			return nil
+		}
+		if IsNotFound(err) || ctx.Err() != nil {
+			return fmt.Errorf("%s: %w", named.Name, err)
+		}
+		unavailable.failures = append(unavailable.failures, fmt.Sprintf("%s: %v", named.Name, err))
+		unavailable.last = err
+	}
+	return unavailable
+}
+
+// unavailableError is returned when no backend of a FallbackBackend is available. It wraps the error of the last
+// backend, e.g. ErrCircuitOpen.
+type unavailableError struct {
+	failures []string
+	last     error
+}
+
+func (e *unavailableError) Error() string {
+	return "no backend available: " + strings.Join(e.failures, "; ")
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.last
+}
+
+type servedByKey struct{}
+
+// servedBy records the names of the backends which served requests.
+type servedBy struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// WithServedBy returns a context in which FallbackBackends record which of their backends served requests, and a
+// function returning the names of those backends, in the order they first served one.
+func WithServedBy(ctx context.Context) (context.Context, func() []string) {
+	s := &servedBy{}
+	return context.WithValue(ctx, servedByKey{}, s), func() []string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return append([]string(nil), s.names...)
+	}
+}
+
+// recordServedBy records that the backend name served a request made with ctx.
+func recordServedBy(ctx context.Context, name string) {
+	s, ok := ctx.Value(servedByKey{}).(*servedBy)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, served := range s.names {
+		if served == name {
+			return
+		}
+	}
+	s.names = append(s.names, name)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+// fakeBackend is a Backend serving values by name, failing with err, or hanging until the request is done.
+type fakeBackend struct {
+	values map[string]string
+	err    error
+	hang   bool
+}
+
+func (b fakeBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	if b.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	value, ok := b.values[params.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", params.Name, ErrNotFound)
+	}
+	return []byte(value), nil
+}
+
+func (b fakeBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestFallbackBackend(t *testing.T) {
+	up := fakeBackend{values: map[string]string{"SUPER_SECRET": "hunter2"}}
+	empty := fakeBackend{values: map[string]string{}}
+	down := fakeBackend{err: errors.New("connection refused")}
+	hanging := fakeBackend{hang: true}
+
+	var testsTable = []struct {
+		name     string
+		backends []Backend
+		value    string
+		servedBy []string
+		notFound bool
+		valid    bool
+	}{
+		{name: "primary serves", backends: []Backend{up, down}, value: "hunter2", servedBy: []string{"0"}, valid: true},
+		{name: "unavailable primary falls back", backends: []Backend{down, up}, value: "hunter2", servedBy: []string{"1"}, valid: true},
+		{name: "hanging primary falls back", backends: []Backend{hanging, up}, value: "hunter2", servedBy: []string{"1"}, valid: true},
+		{name: "missing secret does not fall back", backends: []Backend{empty, up}, notFound: true, valid: false},
+		{name: "no backend available", backends: []Backend{down, down}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &FallbackBackend{Timeout: 100 * time.Millisecond}
+			for i, b := range tt.backends {
+				backend.Backends = append(backend.Backends, NamedBackend{Name: fmt.Sprint(i), Backend: b})
+			}
+			ctx, servedBy := WithServedBy(context.Background())
+			value, err := backend.Get(ctx, GetKeychainSecretParams{Name: "SUPER_SECRET"})
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if IsNotFound(err) != tt.notFound {
+				t.Errorf("Error %v is not found %v, expected %v", err, IsNotFound(err), tt.notFound)
+			}
+			if string(value) != tt.value || !reflect.DeepEqual(servedBy(), tt.servedBy) {
+				t.Errorf("Value %q served by %v, expected %q served by %v", value, servedBy(), tt.value, tt.servedBy)
+			}
+		})
+	}
+}
+
+func TestFallbackBackendSelect(t *testing.T) {
+	backend := &FallbackBackend{Backends: []NamedBackend{{Name: "grpc"}, {Name: "command"}}, Timeout: time.Second}
+
+	selected, err := backend.Select([]string{"command", "grpc"})
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if len(selected.Backends) != 2 || selected.Backends[0].Name != "command" || selected.Timeout != time.Second {
+		t.Errorf("Selected %v, expected command then grpc", selected)
+	}
+	if _, err := backend.Select([]string{"file"}); err == nil {
+		t.Errorf("Select of an unconfigured backend succeeded, expected an error")
+	}
+}
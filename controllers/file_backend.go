@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileBackend is a Backend which reads secrets from files under Root, e.g. a mounted volume: the secret Name of Group
+// is the file Root/Group/Name, or Root/Name for secrets outside of any group. Names and groups may not be hidden, i.e.
+// start with a dot, which excludes the bookkeeping files of Kubernetes volumes, and files may not resolve, through
+// symbolic links, to outside of Root.
+type FileBackend struct {
+	Root string
+}
+
+// NewFileBackend returns a FileBackend for the directory root.
+func NewFileBackend(root string) (*FileBackend, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &FileBackend{Root: root}, nil
+}
+
+// Get implements Backend.
+func (b *FileBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	if params.Version != "" {
+		return nil, fmt.Errorf("file backend cannot fetch versions: %w", ErrNotSupported)
+	}
+	dir, err := b.groupDir(params.Group)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePathSegment(params.Name); err != nil {
+		return nil, fmt.Errorf("name %q: %v", params.Name, err)
+	}
+	path, err := b.resolve(filepath.Join(dir, params.Name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", params.Name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Put implements Writer. The file is replaced atomically, so readers and watchers never observe partial values, or, with
+// params.IfAbsent, linked into place, which fails if it exists. Files have no versions.
+func (b *FileBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	dir, err := b.groupDir(params.Group)
+	if err != nil {
+		return "", err
+	}
+	if err := validatePathSegment(params.Name); err != nil {
+		return "", fmt.Errorf("name %q: %v", params.Name, err)
+	}
+	if params.IfVersion != "" {
+		return "", fmt.Errorf("file backend cannot replace versions: %w", ErrNotSupported)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// The temporary file is hidden, so it is neither listed nor reported as a secret.
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if params.IfAbsent {
+		err := os.Link(tmp.Name(), filepath.Join(dir, params.Name))
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%s: %w", params.Name, ErrAlreadyExists)
+		}
+		return "", err
+	}
+	return "", os.Rename(tmp.Name(), filepath.Join(dir, params.Name))
+}
+
+// Health implements HealthChecker, checking that the root directory can be read, e.g. that its volume is mounted.
+func (b *FileBackend) Health(ctx context.Context) error {
+	_, err := ioutil.ReadDir(b.Root)
+	return err
+}
+
+// List implements Backend.
+func (b *FileBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	dir, err := b.groupDir(params.Group)
+	if err != nil {
+		return nil, err
+	}
+	return b.listDir(dir)
+}
+
+// Watch implements Watcher using inotify. The root directory and the group directories in it are watched, so changes
+// to files, including the atomic updates of Kubernetes volumes, are reported for every secret they may affect.
+func (b *FileBackend) Watch(ctx context.Context, events chan<- BackendEvent) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(b.Root); err != nil {
+		return err
+	}
+	groups, err := b.listGroups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := watcher.Add(filepath.Join(b.Root, group)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case e := <-watcher.Events:
+			for _, changed := range b.changed(watcher, e) {
+				select {
+				case events <- changed:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// changed returns the secrets a file system event may have changed, adding a watch to new group directories.
+func (b *FileBackend) changed(watcher *fsnotify.Watcher, e fsnotify.Event) []BackendEvent {
+	dir, name := filepath.Split(e.Name)
+	dir = filepath.Clean(dir)
+
+	group := ""
+	if dir != b.Root {
+		group = filepath.Base(dir)
+	}
+	if validatePathSegment(name) != nil {
+		// Hidden files, e.g. the ..data symbolic link Kubernetes swaps to update a volume, may change every file
+		// of their directory.
+		return b.dirEvents(group, dir)
+	}
+
+	if group == "" {
+		if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+			if e.Op&fsnotify.Create != 0 {
+				watcher.Add(e.Name)
+			}
+			return b.dirEvents(name, e.Name)
+		}
+	}
+	return []BackendEvent{{Group: group, Name: name}}
+}
+
+// dirEvents returns an event for every secret in dir, which holds group.
+func (b *FileBackend) dirEvents(group, dir string) []BackendEvent {
+	names, _ := b.listDir(dir)
+	events := make([]BackendEvent, 0, len(names))
+	for _, name := range names {
+		events = append(events, BackendEvent{Group: group, Name: name})
+	}
+	return events
+}
+
+// groupDir returns the directory holding the secrets of group.
+func (b *FileBackend) groupDir(group string) (string, error) {
+	if group == "" {
+		return b.Root, nil
+	}
+	if err := validatePathSegment(group); err != nil {
+		return "", fmt.Errorf("group %q: %v", group, err)
+	}
+	return filepath.Join(b.Root, group), nil
+}
+
+// listDir returns the names of the secrets in dir, i.e. the files which are neither hidden nor directories.
+func (b *FileBackend) listDir(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if validatePathSegment(entry.Name()) != nil {
+			continue
+		}
+		path, err := b.resolve(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listGroups returns the names of the group directories.
+func (b *FileBackend) listGroups() ([]string, error) {
+	entries, err := ioutil.ReadDir(b.Root)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, entry := range entries {
+		if entry.IsDir() && validatePathSegment(entry.Name()) == nil {
+			groups = append(groups, entry.Name())
+		}
+	}
+	return groups, nil
+}
+
+// resolve follows the symbolic links of path, failing if it resolves to outside of the root directory.
+func (b *FileBackend) resolve(path string) (string, error) {
+	root, err := filepath.EvalSymlinks(b.Root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s resolves to outside of %s", path, b.Root)
+	}
+	return resolved, nil
+}
+
+// validatePathSegment returns an error unless s can be used as a single, visible, path segment.
+func validatePathSegment(s string) error {
+	switch {
+	case s == "":
+		return fmt.Errorf("must not be empty")
+	case strings.HasPrefix(s, "."):
+		return fmt.Errorf("must not start with a dot")
+	case strings.ContainsAny(s, `/\`+"\x00"):
+		return fmt.Errorf("must not contain path separators")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+// newFileBackend returns a FileBackend for a new directory holding files, and a function removing it.
+func newFileBackend(t *testing.T, files map[string]string) (*FileBackend, func()) {
+	dir, err := ioutil.TempDir("", "keychain-file-backend")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	for name, value := range files {
+		path := filepath.Join(dir, "root", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	backend, err := NewFileBackend(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatalf("NewFileBackend failed: %v", err)
+	}
+	return backend, func() { os.RemoveAll(dir) }
+}
+
+func TestFileBackend(t *testing.T) {
+	backend, cleanup := newFileBackend(t, map[string]string{
+		"SUPER_SECRET":         "hunter2",
+		"DATABASE/DB_PASSWORD": "password",
+		"DATABASE/DB_USER":     "app",
+		"DATABASE/.hidden":     "hidden",
+		"../OUTSIDE":           "outside",
+	})
+	defer cleanup()
+	if err := os.Symlink(filepath.Join(backend.Root, "..", "OUTSIDE"), filepath.Join(backend.Root, "ESCAPE")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := os.Symlink("DB_USER", filepath.Join(backend.Root, "DATABASE", "DB_LINK")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	ctx := context.Background()
+
+	var testsTable = []struct {
+		name   string
+		params GetKeychainSecretParams
+		value  string
+		valid  bool
+	}{
+		{name: "no group", params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, value: "hunter2", valid: true},
+		{name: "group", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD"}, value: "password", valid: true},
+		{name: "link within root", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_LINK"}, value: "app", valid: true},
+		{name: "missing secret", params: GetKeychainSecretParams{Name: "MISSING"}, valid: false},
+		{name: "hidden secret", params: GetKeychainSecretParams{Group: "DATABASE", Name: ".hidden"}, valid: false},
+		{name: "parent name", params: GetKeychainSecretParams{Name: ".."}, valid: false},
+		{name: "traversing name", params: GetKeychainSecretParams{Name: "../OUTSIDE"}, valid: false},
+		{name: "traversing group", params: GetKeychainSecretParams{Group: "..", Name: "OUTSIDE"}, valid: false},
+		{name: "link outside root", params: GetKeychainSecretParams{Name: "ESCAPE"}, valid: false},
+		{name: "version", params: GetKeychainSecretParams{Name: "SUPER_SECRET", Version: "1"}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := backend.Get(ctx, tt.params)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if string(value) != tt.value {
+				t.Errorf("Value %q, expected %q", value, tt.value)
+			}
+		})
+	}
+
+	if _, err := backend.Get(ctx, GetKeychainSecretParams{Name: "SUPER_SECRET", Version: "1"}); !IsNotSupported(err) {
+		t.Errorf("Get of a version returned %v, expected not supported", err)
+	}
+
+	names, err := backend.List(ctx, ListKeychainSecretsParams{Group: "DATABASE"})
+	if expected := []string{"DB_LINK", "DB_PASSWORD", "DB_USER"}; err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("List %v (%v), expected %v", names, err, expected)
+	}
+	names, err = backend.List(ctx, ListKeychainSecretsParams{})
+	if expected := []string{"SUPER_SECRET"}; err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("List %v (%v), expected %v", names, err, expected)
+	}
+
+	params := GetKeychainSecretParams{Group: "GENERATED", Name: "PASSWORD"}
+	if _, err := backend.Get(ctx, params); !IsNotFound(err) {
+		t.Errorf("Get of a missing secret returned %v, expected not found", err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "GENERATED", Name: "PASSWORD"}, []byte("generated")); err != nil {
+		t.Errorf("Put failed: %v", err)
+	}
+	if value, err := backend.Get(ctx, params); err != nil || string(value) != "generated" {
+		t.Errorf("Get %q (%v) after Put, expected generated", value, err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "GENERATED", Name: "PASSWORD", IfAbsent: true}, []byte("again")); !IsAlreadyExists(err) {
+		t.Errorf("Put if absent of an existing secret returned %v, expected already exists", err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "GENERATED", Name: "PASSWORD", IfVersion: "1"}, []byte("again")); !IsNotSupported(err) {
+		t.Errorf("Put if version returned %v, expected not supported", err)
+	}
+	if value, err := backend.Get(ctx, params); err != nil || string(value) != "generated" {
+		t.Errorf("Get %q (%v) after Put if absent, expected generated", value, err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "..", Name: "OUTSIDE"}, []byte("outside")); err == nil {
+		t.Errorf("Put outside of the root succeeded, expected an error")
+	}
+}
+
+func TestFileBackendWatch(t *testing.T) {
+	backend, cleanup := newFileBackend(t, map[string]string{"DATABASE/DB_PASSWORD": "old"})
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan BackendEvent, 16)
+	done := make(chan error, 1)
+	go func() { done <- backend.Watch(ctx, events) }()
+	// Give the watcher time to add its watches.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(backend.Root, "DATABASE", "DB_PASSWORD"), []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	select {
+	case e := <-events:
+		if expected := (BackendEvent{Group: "DATABASE", Name: "DB_PASSWORD"}); e != expected {
+			t.Errorf("Event %v, expected %v", e, expected)
+		}
+	case err := <-done:
+		t.Fatalf("Watch returned %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event observed")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch returned %v after cancel, expected nil", err)
+	}
+}
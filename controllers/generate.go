@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// generate generates the KeychainSecret's Keychain secret when it does not exist, stores it with backend, provided the
+// namespace may store it, and fetches the data again. The secret is only created, so when another controller or
+// KeychainSecret generates it first, theirs is fetched instead. Dry runs use the generated value without storing it.
+// It returns notFound, the error of the fetch which failed, if the Keychain secret exists, i.e. another one is missing,
+// and the error of checking whether it exists if that fails.
+func (r *KeychainSecretReconciler) generate(ctx context.Context, backend Backend, keychainSecret *aqueductv1.KeychainSecret, now time.Time, notFound error) (SecretData, error) {
+	params := GetKeychainSecretParams{Name: keychainSecret.Spec.Name, Group: keychainSecret.Spec.Group}
+	if _, err := backend.Get(ctx, params); err == nil {
+		return SecretData{}, notFound
+	} else if !IsNotFound(err) {
+		return SecretData{}, err
+	}
+
+	value, err := GenerateValue(*keychainSecret.Spec.Generate, rand.Reader)
+	if err != nil {
+		return SecretData{}, err
+	}
+	if keychainSecret.Spec.DryRun {
+		return FetchSecretData(ctx, generatedBackend{Backend: backend, params: params, value: value}, *keychainSecret)
+	}
+
+	if r.AccessPolicies != nil {
+		allowed, reason, err := r.AccessPolicies.AllowedPut(ctx, keychainSecret.Namespace, params.Group, params.Name)
+		if err != nil {
+			return SecretData{}, err
+		}
+		if !allowed {
+			return SecretData{}, errors.New(reason)
+		}
+	}
+	putParams := PutKeychainSecretParams{Name: params.Name, Group: params.Group, IfAbsent: true}
+	version, err := Put(ctx, backend, putParams, value)
+	if IsAlreadyExists(err) {
+		r.Log.Info("Keychain secret was generated concurrently, fetching it", "keychainsecret", keychainSecret.Namespace+"/"+keychainSecret.Name)
+		return FetchSecretData(ctx, backend, *keychainSecret)
+	}
+	if err != nil {
+		return SecretData{}, err
+	}
+	r.Log.Info("generated Keychain secret", "keychainsecret", keychainSecret.Namespace+"/"+keychainSecret.Name,
+		"type", keychainSecret.Spec.Generate.Type, "version", version)
+	keychainSecret.Status.Generated = &metav1.Time{Time: now}
+	return FetchSecretData(ctx, backend, *keychainSecret)
+}
+
+// generatedBackend is a Backend in which a generated secret exists, for dry runs.
+type generatedBackend struct {
+	Backend
+	params GetKeychainSecretParams
+	value  []byte
+}
+
+// Get implements Backend.
+func (b generatedBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	if params == b.params {
+		return b.value, nil
+	}
+	return b.Backend.Get(ctx, params)
+}
+
+const (
+	alphanumericCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	asciiCharacters        = "!\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+)
+
+// GenerateValue generates a value as described by spec, reading randomness from random, e.g. crypto/rand.Reader.
+// Defaults which the API server would have applied are applied to spec.
+func GenerateValue(spec aqueductv1.GenerateSpec, random io.Reader) ([]byte, error) {
+	switch spec.Type {
+	case aqueductv1.GeneratePassword:
+		return generatePassword(spec, random)
+	case aqueductv1.GenerateRSA:
+		bits := int(spec.Bits)
+		if bits == 0 {
+			bits = 2048
+		}
+		key, err := rsa.GenerateKey(random, bits)
+		if err != nil {
+			return nil, err
+		}
+		return marshalPKCS8(key)
+	case aqueductv1.GenerateECDSA:
+		curves := map[string]elliptic.Curve{"": elliptic.P256(), "P256": elliptic.P256(), "P384": elliptic.P384(), "P521": elliptic.P521()}
+		curve, ok := curves[spec.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", spec.Curve)
+		}
+		key, err := ecdsa.GenerateKey(curve, random)
+		if err != nil {
+			return nil, err
+		}
+		return marshalPKCS8(key)
+	case aqueductv1.GenerateSSH:
+		public, private, err := ed25519.GenerateKey(random)
+		if err != nil {
+			return nil, err
+		}
+		return marshalOpenSSHEd25519(public, private, random)
+	default:
+		return nil, fmt.Errorf("unknown type %q", spec.Type)
+	}
+}
+
+// generatePassword returns a password of spec.Length characters, each drawn uniformly from the allowed characters.
+func generatePassword(spec aqueductv1.GenerateSpec, random io.Reader) ([]byte, error) {
+	characters := spec.Characters
+	if characters == "" {
+		switch spec.Charset {
+		case "", aqueductv1.CharsetAlphanumeric:
+			characters = alphanumericCharacters
+		case aqueductv1.CharsetASCII:
+			characters = asciiCharacters
+		default:
+			return nil, fmt.Errorf("unknown charset %q", spec.Charset)
+		}
+	}
+	runes := []rune(characters)
+	length := int(spec.Length)
+	if length == 0 {
+		length = 32
+	}
+
+	var password bytes.Buffer
+	max := big.NewInt(int64(len(runes)))
+	for i := 0; i < length; i++ {
+		n, err := randInt(random, max)
+		if err != nil {
+			return nil, err
+		}
+		password.WriteRune(runes[n])
+	}
+	return password.Bytes(), nil
+}
+
+// randInt returns a uniform random integer in [0, max).
+func randInt(random io.Reader, max *big.Int) (int, error) {
+	// Rejection sampling, as crypto/rand.Int does, but reading from random.
+	bits := max.BitLen()
+	buf := make([]byte, (bits+7)/8)
+	for {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return 0, err
+		}
+		// Clear the bits above the bit length of max, so at least half of the samples are accepted.
+		if extra := uint(len(buf)*8 - bits); extra > 0 {
+			buf[0] &= byte(int(1<<(8-extra)) - 1)
+		}
+		n := new(big.Int).SetBytes(buf)
+		if n.Cmp(max) < 0 {
+			return int(n.Int64()), nil
+		}
+	}
+}
+
+// marshalPKCS8 returns the PEM encoded PKCS #8 form of key.
+func marshalPKCS8(key crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// marshalOpenSSHEd25519 returns the unencrypted OpenSSH private key format of an Ed25519 key, as ssh-keygen writes it,
+// see https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.key.
+func marshalOpenSSHEd25519(public ed25519.PublicKey, private ed25519.PrivateKey, random io.Reader) ([]byte, error) {
+	var publicKey bytes.Buffer
+	writeSSHString(&publicKey, []byte("ssh-ed25519"))
+	writeSSHString(&publicKey, public)
+
+	// The two check integers are equal, to tell whether decryption succeeded.
+	check := make([]byte, 4)
+	if _, err := io.ReadFull(random, check); err != nil {
+		return nil, err
+	}
+	var privateKeys bytes.Buffer
+	privateKeys.Write(check)
+	privateKeys.Write(check)
+	writeSSHString(&privateKeys, []byte("ssh-ed25519"))
+	writeSSHString(&privateKeys, public)
+	writeSSHString(&privateKeys, private)
+	writeSSHString(&privateKeys, nil) // Comment
+	for i := byte(1); privateKeys.Len()%8 != 0; i++ {
+		privateKeys.WriteByte(i)
+	}
+
+	var key bytes.Buffer
+	key.WriteString("openssh-key-v1\x00")
+	writeSSHString(&key, []byte("none")) // Cipher
+	writeSSHString(&key, []byte("none")) // KDF
+	writeSSHString(&key, nil)            // KDF options
+	binary.Write(&key, binary.BigEndian, uint32(1))
+	writeSSHString(&key, publicKey.Bytes())
+	writeSSHString(&key, privateKeys.Bytes())
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key.Bytes()}), nil
+}
+
+// writeSSHString writes s in the SSH wire format, i.e. prefixed with its length.
+func writeSSHString(buf *bytes.Buffer, s []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.Write(s)
+}
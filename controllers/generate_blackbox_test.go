@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestGenerateValue(t *testing.T) {
+	var testsTable = []struct {
+		name  string
+		spec  aqueductv1.GenerateSpec
+		check func(t *testing.T, value []byte)
+		valid bool
+	}{
+		{name: "default password", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword}, valid: true,
+			check: passwordOf(32, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")},
+		{name: "custom characters", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword, Length: 64, Characters: "ab"},
+			valid: true, check: passwordOf(64, "ab")},
+		{name: "ASCII password", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword, Length: 16, Charset: aqueductv1.CharsetASCII},
+			valid: true, check: func(t *testing.T, value []byte) {
+				if len(value) != 16 || strings.ContainsAny(string(value), " \t\n") {
+					t.Errorf("Password %q, expected 16 printable characters", value)
+				}
+			}},
+		{name: "RSA key", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GenerateRSA, Bits: 2048}, valid: true,
+			check: func(t *testing.T, value []byte) {
+				if key, ok := parsePKCS8(t, value).(*rsa.PrivateKey); !ok || key.N.BitLen() != 2048 {
+					t.Errorf("Key %T, expected a 2048 bit RSA key", key)
+				}
+			}},
+		{name: "ECDSA key", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GenerateECDSA, Curve: "P384"}, valid: true,
+			check: func(t *testing.T, value []byte) {
+				if key, ok := parsePKCS8(t, value).(*ecdsa.PrivateKey); !ok || key.Curve.Params().Name != "P-384" {
+					t.Errorf("Key %T, expected a P-384 ECDSA key", key)
+				}
+			}},
+		{name: "SSH key", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GenerateSSH}, valid: true,
+			check: func(t *testing.T, value []byte) {
+				block, _ := pem.Decode(value)
+				if block == nil || block.Type != "OPENSSH PRIVATE KEY" || !strings.HasPrefix(string(block.Bytes), "openssh-key-v1\x00") {
+					t.Errorf("Key %q, expected an OpenSSH private key", value)
+				}
+			}},
+		{name: "unknown curve", spec: aqueductv1.GenerateSpec{Type: aqueductv1.GenerateECDSA, Curve: "P224"}, valid: false},
+		{name: "unknown type", spec: aqueductv1.GenerateSpec{Type: "DSA"}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := GenerateValue(tt.spec, rand.Reader)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if tt.valid {
+				tt.check(t, value)
+			}
+		})
+	}
+}
+
+// passwordOf returns a check that values are passwords of length characters drawn from characters.
+func passwordOf(length int, characters string) func(t *testing.T, value []byte) {
+	return func(t *testing.T, value []byte) {
+		if len(value) != length {
+			t.Errorf("Password %q has %d characters, expected %d", value, len(value), length)
+		}
+		for _, c := range string(value) {
+			if !strings.ContainsRune(characters, c) {
+				t.Errorf("Password %q has character %q, expected only %q", value, c, characters)
+			}
+		}
+	}
+}
+
+func parsePKCS8(t *testing.T, value []byte) interface{} {
+	block, _ := pem.Decode(value)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("Value %q is not a PEM encoded private key", value)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePKCS8PrivateKey failed: %v", err)
+	}
+	return key
+}
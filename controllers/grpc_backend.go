@@ -0,0 +1,373 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	backendv1alpha1 "github.com/davidewatson/keychain/api/backend/v1alpha1"
+)
+
+// GRPCBackend is a Backend which forwards requests to a backend plugin serving the Backend service of
+// api/backend/v1alpha1 on a Unix socket, typically a sidecar of the manager. Which optional features it supports is
+// asked once, with the Capabilities call, and remembered.
+type GRPCBackend struct {
+	Timeout time.Duration // Optional, five minutes if zero
+
+	conn   *grpc.ClientConn
+	client backendv1alpha1.BackendClient
+
+	mu           sync.Mutex
+	capabilities *backendv1alpha1.CapabilitiesResponse
+}
+
+// NewGRPCBackend returns a GRPCBackend for the plugin listening on socketPath. The plugin need not be listening yet,
+// the connection is made, and remade, as requests need it.
+func NewGRPCBackend(socketPath string) (*GRPCBackend, error) {
+	conn, err := grpc.Dial(socketPath,
+		grpc.WithInsecure(), // The socket is only reachable from within the pod
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", addr)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCBackend{conn: conn, client: backendv1alpha1.NewBackendClient(conn)}, nil
+}
+
+// Close closes the connection to the plugin.
+func (b *GRPCBackend) Close() error {
+	return b.conn.Close()
+}
+
+// Get implements Backend.
+func (b *GRPCBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	value, _, err := b.GetWithExpiry(ctx, params)
+	return value, err
+}
+
+// GetWithExpiry implements Expirer.
+func (b *GRPCBackend) GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	secret, err := b.Fetch(ctx, params)
+	return secret.Value, secret.Expiry, err
+}
+
+// Fetch implements Fetcher.
+func (b *GRPCBackend) Fetch(ctx context.Context, params GetKeychainSecretParams) (FetchedSecret, error) {
+	resp, err := b.fetch(ctx, params)
+	if err != nil {
+		return FetchedSecret{}, err
+	}
+	secret := FetchedSecret{Value: resp.Value, Version: resp.Version}
+	if resp.Expiry == nil {
+		return secret, nil
+	}
+	if err := resp.Expiry.CheckValid(); err != nil {
+		return FetchedSecret{}, fmt.Errorf("invalid expiry: %v", err)
+	}
+	secret.Expiry = resp.Expiry.AsTime()
+	return secret, nil
+}
+
+// LatestVersion implements Versioner. It returns an empty string for plugins which do not support versions.
+func (b *GRPCBackend) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	capabilities, err := b.Capabilities(ctx)
+	if err != nil || !capabilities.Versions {
+		return "", err
+	}
+	params.Version = ""
+	resp, err := b.fetch(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return resp.Version, nil
+}
+
+// List implements Backend.
+func (b *GRPCBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	capabilities, err := b.Capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !capabilities.List {
+		return nil, fmt.Errorf("backend plugin does not support listing secrets")
+	}
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	resp, err := b.client.List(ctx, &backendv1alpha1.ListRequest{Group: params.Group})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Names, nil
+}
+
+// Put implements Writer. It fails for plugins which do not support put.
+func (b *GRPCBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	capabilities, err := b.Capabilities(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !capabilities.Put {
+		return "", fmt.Errorf("backend plugin cannot store secrets: %w", ErrNotSupported)
+	}
+	// Plugins unaware of if_absent or if_version would overwrite the secret.
+	if params.IfAbsent && !capabilities.PutIfAbsent {
+		return "", fmt.Errorf("backend plugin cannot only create secrets: %w", ErrNotSupported)
+	}
+	if params.IfVersion != "" && !capabilities.PutIfVersion {
+		return "", fmt.Errorf("backend plugin cannot replace versions: %w", ErrNotSupported)
+	}
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	resp, err := b.client.Put(ctx, &backendv1alpha1.PutRequest{Name: params.Name, Group: params.Group, Value: value,
+		IfAbsent: params.IfAbsent, IfVersion: params.IfVersion})
+	switch status.Code(err) {
+	case codes.AlreadyExists:
+		return "", fmt.Errorf("%s: %w", status.Convert(err).Message(), ErrAlreadyExists)
+	case codes.FailedPrecondition:
+		return "", fmt.Errorf("%s: %w", status.Convert(err).Message(), ErrConflict)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.Version, nil
+}
+
+// Watch implements Watcher. It returns ErrWatchNotSupported for plugins which do not support watch.
+func (b *GRPCBackend) Watch(ctx context.Context, events chan<- BackendEvent) error {
+	capabilities, err := b.Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !capabilities.Watch {
+		return ErrWatchNotSupported
+	}
+
+	// The stream lasts until ctx is done, so no timeout applies.
+	stream, err := b.client.Watch(ctx, &backendv1alpha1.WatchRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if status.Code(err) == codes.Unimplemented {
+				return ErrWatchNotSupported
+			}
+			return err
+		}
+		select {
+		case events <- BackendEvent{Group: e.Group, Name: e.Name, Version: e.Version}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Health implements HealthChecker, returning an error unless the plugin reports that it is serving.
+func (b *GRPCBackend) Health(ctx context.Context) error {
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	resp, err := b.client.Health(ctx, &backendv1alpha1.HealthRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != backendv1alpha1.HealthResponse_SERVING {
+		return fmt.Errorf("backend plugin is not serving: %s", resp.Message)
+	}
+	return nil
+}
+
+// Capabilities returns the capabilities of the plugin. It fails if the plugin speaks another protocol version.
+func (b *GRPCBackend) Capabilities(ctx context.Context) (*backendv1alpha1.CapabilitiesResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.capabilities != nil {
+		return b.capabilities, nil
+	}
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	capabilities, err := b.client.Capabilities(ctx, &backendv1alpha1.CapabilitiesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if capabilities.ProtocolVersion != backendv1alpha1.ProtocolVersion {
+		return nil, fmt.Errorf("backend plugin speaks protocol %q, expected %q",
+			capabilities.ProtocolVersion, backendv1alpha1.ProtocolVersion)
+	}
+	b.capabilities = capabilities
+	return capabilities, nil
+}
+
+// fetch fetches a secret, after checking the plugin speaks our protocol version.
+func (b *GRPCBackend) fetch(ctx context.Context, params GetKeychainSecretParams) (*backendv1alpha1.FetchResponse, error) {
+	if _, err := b.Capabilities(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	resp, err := b.client.Fetch(ctx, &backendv1alpha1.FetchRequest{
+		Name:    params.Name,
+		Group:   params.Group,
+		Version: params.Version,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%s: %w", status.Convert(err).Message(), ErrNotFound)
+	}
+	return resp, err
+}
+
+func (b *GRPCBackend) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := b.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// backendServer serves a Backend over the Backend service of api/backend/v1alpha1.
+type backendServer struct {
+	backendv1alpha1.UnimplementedBackendServer
+	backend Backend
+}
+
+// NewBackendServer returns a BackendServer serving backend, for use by backend plugins. It advertises versions, expiry,
+// watch and put when backend implements Versioner, Expirer, Watcher and Writer respectively.
+func NewBackendServer(backend Backend) backendv1alpha1.BackendServer {
+	return backendServer{backend: backend}
+}
+
+// Fetch implements BackendServer.
+func (s backendServer) Fetch(ctx context.Context, req *backendv1alpha1.FetchRequest) (*backendv1alpha1.FetchResponse, error) {
+	params := GetKeychainSecretParams{Name: req.Name, Group: req.Group, Version: req.Version}
+	secret, err := Fetch(ctx, s.backend, params)
+	if IsNotFound(err) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A version which could not be looked up is reported as unknown, rather than failing the fetch.
+	resp := &backendv1alpha1.FetchResponse{Value: secret.Value, Version: secret.Version}
+	if !secret.Expiry.IsZero() {
+		resp.Expiry = timestamppb.New(secret.Expiry)
+	}
+	return resp, nil
+}
+
+// Put implements BackendServer.
+func (s backendServer) Put(ctx context.Context, req *backendv1alpha1.PutRequest) (*backendv1alpha1.PutResponse, error) {
+	writer, ok := s.backend.(Writer)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "put is not supported")
+	}
+	params := PutKeychainSecretParams{Name: req.Name, Group: req.Group, IfAbsent: req.IfAbsent, IfVersion: req.IfVersion}
+	version, err := writer.Put(ctx, params, req.Value)
+	switch {
+	case IsAlreadyExists(err):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case IsConflict(err):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case IsNotSupported(err):
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &backendv1alpha1.PutResponse{Version: version}, nil
+}
+
+// List implements BackendServer.
+func (s backendServer) List(ctx context.Context, req *backendv1alpha1.ListRequest) (*backendv1alpha1.ListResponse, error) {
+	names, err := s.backend.List(ctx, ListKeychainSecretsParams{Group: req.Group})
+	if err != nil {
+		return nil, err
+	}
+	return &backendv1alpha1.ListResponse{Names: names}, nil
+}
+
+// Watch implements BackendServer.
+func (s backendServer) Watch(req *backendv1alpha1.WatchRequest, stream backendv1alpha1.Backend_WatchServer) error {
+	watcher, ok := s.backend.(Watcher)
+	if !ok {
+		return status.Error(codes.Unimplemented, "watch is not supported")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	events := make(chan BackendEvent)
+	done := make(chan error, 1)
+	go func() { done <- watcher.Watch(ctx, events) }()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case e := <-events:
+			if req.Group != "" && e.Group != req.Group {
+				continue
+			}
+			if err := stream.Send(&backendv1alpha1.WatchEvent{Group: e.Group, Name: e.Name, Version: e.Version}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Health implements BackendServer, reporting the health of the backend, see HealthChecker.
+func (s backendServer) Health(ctx context.Context, req *backendv1alpha1.HealthRequest) (*backendv1alpha1.HealthResponse, error) {
+	if err := Health(ctx, s.backend); err != nil {
+		return &backendv1alpha1.HealthResponse{Status: backendv1alpha1.HealthResponse_NOT_SERVING, Message: err.Error()}, nil
+	}
+	return &backendv1alpha1.HealthResponse{Status: backendv1alpha1.HealthResponse_SERVING}, nil
+}
+
+// Capabilities implements BackendServer.
+func (s backendServer) Capabilities(context.Context, *backendv1alpha1.CapabilitiesRequest) (*backendv1alpha1.CapabilitiesResponse, error) {
+	_, versions := s.backend.(Versioner)
+	_, expiry := s.backend.(Expirer)
+	_, watch := s.backend.(Watcher)
+	_, put := s.backend.(Writer)
+	return &backendv1alpha1.CapabilitiesResponse{
+		ProtocolVersion: backendv1alpha1.ProtocolVersion,
+		Versions:        versions,
+		Expiry:          expiry,
+		List:            true,
+		Watch:           watch,
+		Put:             put,
+		PutIfAbsent:     put,
+		PutIfVersion:    put, // Writers which cannot check versions fail with UNIMPLEMENTED
+	}, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	backendv1alpha1 "github.com/davidewatson/keychain/api/backend/v1alpha1"
+	. "github.com/davidewatson/keychain/controllers"
+	"github.com/davidewatson/keychain/controllers/keychaintest"
+)
+
+// newGRPCBackend returns a GRPCBackend for a plugin serving backend, and a function stopping it.
+func newGRPCBackend(t *testing.T, backend Backend) (*GRPCBackend, func()) {
+	dir, err := ioutil.TempDir("", "keychain-backend")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	socket := filepath.Join(dir, "backend.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server := grpc.NewServer()
+	backendv1alpha1.RegisterBackendServer(server, NewBackendServer(backend))
+	go server.Serve(listener)
+
+	client, err := NewGRPCBackend(socket)
+	if err != nil {
+		t.Fatalf("NewGRPCBackend failed: %v", err)
+	}
+	return client, func() {
+		client.Close()
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGRPCBackend(t *testing.T) {
+	keychain := keychaintest.NewServer()
+	defer keychain.Close()
+
+	expiry := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	keychain.Put("DATABASE", "DB_PASSWORD", HTTPSecret{Value: []byte("old"), Version: "1"})
+	keychain.Put("DATABASE", "DB_PASSWORD", HTTPSecret{Value: []byte("new"), Version: "2", Expiry: &expiry})
+	keychain.Put("DATABASE", "DB_USER", HTTPSecret{Value: []byte("app")})
+
+	// The plugin serves the HTTP backend, which supports every optional feature but Watch.
+	httpBackend, err := NewHTTPBackend(keychain.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	backend, cleanup := newGRPCBackend(t, httpBackend)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := backend.Health(ctx); err != nil {
+		t.Errorf("Health failed: %v", err)
+	}
+	capabilities, err := backend.Capabilities(ctx)
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if !capabilities.Versions || !capabilities.Expiry || !capabilities.List || capabilities.Watch ||
+		!capabilities.PutIfAbsent || !capabilities.PutIfVersion {
+		t.Errorf("Capabilities %v, expected versions, expiry, list and put if absent or version", capabilities)
+	}
+
+	var testsTable = []struct {
+		name    string
+		params  GetKeychainSecretParams
+		value   string
+		version string
+		expiry  time.Time
+		valid   bool
+	}{
+		{name: "latest version", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD"},
+			value: "new", version: "2", expiry: expiry, valid: true},
+		{name: "pinned version", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD", Version: "1"},
+			value: "old", version: "1", valid: true},
+		{name: "missing secret", params: GetKeychainSecretParams{Name: "MISSING"}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := Fetch(ctx, backend, tt.params)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if string(secret.Value) != tt.value || !secret.Expiry.Equal(tt.expiry) {
+				t.Errorf("Value %q expiring %v, expected %q expiring %v", secret.Value, secret.Expiry, tt.value, tt.expiry)
+			}
+			if secret.Version != tt.version || secret.VersionErr != nil {
+				t.Errorf("Version %q (%v), expected %q", secret.Version, secret.VersionErr, tt.version)
+			}
+		})
+	}
+
+	version, err := LatestVersion(ctx, backend, GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD", Version: "1"})
+	if err != nil || version != "2" {
+		t.Errorf("Latest version %q (%v), expected 2", version, err)
+	}
+	names, err := backend.List(ctx, ListKeychainSecretsParams{Group: "DATABASE"})
+	if expected := []string{"DB_PASSWORD", "DB_USER"}; err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("List %v (%v), expected %v", names, err, expected)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "DATABASE", Name: "DB_USER", IfAbsent: true}, []byte("other")); !IsAlreadyExists(err) {
+		t.Errorf("Put if absent of an existing secret returned %v, expected already exists", err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD", IfVersion: "1"}, []byte("other")); !IsConflict(err) {
+		t.Errorf("Put if version of another version returned %v, expected conflict", err)
+	}
+}
+
+func TestGRPCBackendWatch(t *testing.T) {
+	fileBackend, cleanup := newFileBackend(t, map[string]string{"DATABASE/DB_PASSWORD": "old"})
+	defer cleanup()
+	backend, stop := newGRPCBackend(t, fileBackend)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan BackendEvent, 16)
+	done := make(chan error, 1)
+	go func() { done <- backend.Watch(ctx, events) }()
+	// Give the plugin time to add its watches.
+	time.Sleep(200 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(fileBackend.Root, "DATABASE", "DB_PASSWORD"), []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	select {
+	case e := <-events:
+		if expected := (BackendEvent{Group: "DATABASE", Name: "DB_PASSWORD"}); e != expected {
+			t.Errorf("Event %v, expected %v", e, expected)
+		}
+	case err := <-done:
+		t.Fatalf("Watch returned %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event observed")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch returned %v after cancel, expected nil", err)
+	}
+}
+
+func TestGRPCBackendWatchNotSupported(t *testing.T) {
+	keychain := keychaintest.NewServer()
+	defer keychain.Close()
+	httpBackend, err := NewHTTPBackend(keychain.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	backend, stop := newGRPCBackend(t, httpBackend)
+	defer stop()
+
+	if err := backend.Watch(context.Background(), make(chan BackendEvent)); err != ErrWatchNotSupported {
+		t.Errorf("Watch returned %v, expected %v", err, ErrWatchNotSupported)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// healthCheckTimeout bounds health checks which make requests, so probes answer before they time out.
+const healthCheckTimeout = 5 * time.Second
+
+// commandEnvs are the environment variables holding command templates.
+var commandEnvs = []string{
+	GenerateCertCommandEnv,
+	GetSecretCommandEnv,
+	ListSecretsCommandEnv,
+	GetSecretVersionCommandEnv,
+	GetSecretExpiryCommandEnv,
+	PutSecretCommandEnv,
+}
+
+// CheckCommands returns an error unless the environment variables in required are set, and the binaries of every
+// configured command template exist and are executable.
+func CheckCommands(required ...string) error {
+	for _, env := range required {
+		if os.Getenv(env) == "" {
+			return fmt.Errorf("%s is not set", env)
+		}
+	}
+	for _, env := range commandEnvs {
+		if tmpl := os.Getenv(env); tmpl != "" {
+			if err := CheckCommandTemplate(tmpl); err != nil {
+				return fmt.Errorf("%s: %v", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckCommandTemplate returns an error unless the binary a command template runs, either a path or a name looked up
+// in PATH, exists and is executable. Binaries which are themselves templated are only known when rendered, and are
+// not checked.
+func CheckCommandTemplate(tmpl string) error {
+	fields := strings.Fields(tmpl)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	if strings.Contains(fields[0], "{{") {
+		return nil
+	}
+	_, err := exec.LookPath(fields[0])
+	return err
+}
+
+// CommandsChecker returns a healthz.Checker of CheckCommands.
+func CommandsChecker(required ...string) healthz.Checker {
+	return func(*http.Request) error {
+		return CheckCommands(required...)
+	}
+}
+
+// BackendChecker returns a healthz.Checker of the health of backend, see HealthChecker.
+func BackendChecker(backend Backend) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), healthCheckTimeout)
+		defer cancel()
+		return Health(ctx, backend)
+	}
+}
+
+// NamespaceChecker returns a healthz.Checker verifying that the Secrets of namespace, e.g. the controller namespace
+// holding identities, can be listed. reader should not be cached, so the API server is reached.
+func NamespaceChecker(reader client.Reader, namespace string) healthz.Checker {
+	return func(req *http.Request) error {
+		if namespace == "" {
+			return fmt.Errorf("controller namespace is not set")
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), healthCheckTimeout)
+		defer cancel()
+		var secrets corev1.SecretList
+		return reader.List(ctx, &secrets, client.InNamespace(namespace), client.Limit(1))
+	}
+}
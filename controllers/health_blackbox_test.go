@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestCheckCommandTemplate(t *testing.T) {
+	file, err := ioutil.TempFile("", "keychain-not-executable")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	var testsTable = []struct {
+		name  string
+		tmpl  string
+		valid bool
+	}{
+		{name: "binary in PATH", tmpl: "cat {{.Group}}/{{.Name}}", valid: true},
+		{name: "absolute path", tmpl: "/bin/sh -c true", valid: true},
+		{name: "templated binary", tmpl: "{{.Group}} {{.Name}}", valid: true},
+		{name: "missing binary", tmpl: "keychain-missing-binary {{.Name}}", valid: false},
+		{name: "not executable", tmpl: file.Name(), valid: false},
+		{name: "empty template", tmpl: " ", valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckCommandTemplate(tt.tmpl); (err == nil) != tt.valid {
+				t.Errorf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestBackendChecker(t *testing.T) {
+	healthy, cleanup := newFileBackend(t, map[string]string{"SUPER_SECRET": "hunter2"})
+	defer cleanup()
+	unmounted := &FileBackend{Root: "/var/run/keychain-unmounted"}
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	var testsTable = []struct {
+		name     string
+		backends []Backend
+		valid    bool
+	}{
+		{name: "healthy backend", backends: []Backend{healthy}, valid: true},
+		{name: "unhealthy backend", backends: []Backend{unmounted}, valid: false},
+		{name: "any healthy backend", backends: []Backend{unmounted, healthy}, valid: true},
+		{name: "backends without health checks", backends: []Backend{fakeBackend{}}, valid: true},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &FallbackBackend{}
+			for _, b := range tt.backends {
+				backend.Backends = append(backend.Backends, NamedBackend{Name: "test", Backend: b})
+			}
+			if err := BackendChecker(backend)(req); (err == nil) != tt.valid {
+				t.Errorf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckCommands(t *testing.T) {
+	defer os.Setenv(GetSecretCommandEnv, os.Getenv(GetSecretCommandEnv))
+
+	os.Unsetenv(GetSecretCommandEnv)
+	if err := CheckCommands(GetSecretCommandEnv); err == nil {
+		t.Errorf("CheckCommands succeeded without %s, expected an error", GetSecretCommandEnv)
+	}
+	os.Setenv(GetSecretCommandEnv, "keychain-missing-binary {{.Name}}")
+	if err := CheckCommands(); err == nil {
+		t.Errorf("CheckCommands succeeded with a missing binary, expected an error")
+	}
+	os.Setenv(GetSecretCommandEnv, "cat {{.Name}}")
+	if err := CheckCommands(GetSecretCommandEnv); err != nil {
+		t.Errorf("CheckCommands failed: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// HTTPSecret is the response of an HTTP backend to GET /v1/secrets/{group}/{name}. Secrets outside of any group use
+// the group "-". The optional version query parameter selects a version other than the latest. It is also the body of
+// PUT /v1/secrets/{group}/{name}, which stores a new version of a secret, and of its response, which holds the version.
+// PUTs with the header "If-None-Match: *" only create the secret, and fail with 412 Precondition Failed if it exists.
+// PUTs with the header `If-Match: "{version}"` only replace that version, and fail with 412 Precondition Failed if the
+// latest version is another one.
+type HTTPSecret struct {
+	Value   []byte     `json:"value"`             // Base64 encoded in JSON
+	Version string     `json:"version,omitempty"` // Optional
+	Expiry  *time.Time `json:"expiry,omitempty"`  // Optional, RFC 3339 in JSON
+}
+
+// HTTPSecretList is the response of an HTTP backend to GET /v1/groups/{group}/secrets.
+type HTTPSecretList struct {
+	Names []string `json:"names"`
+}
+
+// HTTPBackend is a Backend which fetches secrets from a REST API, see HTTPSecret and HTTPSecretList. Requests made
+// with an identity in their context, see WithIdentity, authenticate with it using mutual TLS.
+type HTTPBackend struct {
+	BaseURL *url.URL
+	RootCAs *x509.CertPool // Optional, the system roots are used if nil
+	Timeout time.Duration  // Optional, five minutes if zero
+
+	mu      sync.Mutex
+	clients map[types.NamespacedName]identityClient // Clients with warm connections per identity
+}
+
+// identityClient is an http.Client presenting the identity Secret with the given resource version.
+type identityClient struct {
+	resourceVersion string
+	client          *http.Client
+}
+
+// NewHTTPBackend returns an HTTPBackend for the API at baseURL.
+func NewHTTPBackend(baseURL string, rootCAs *x509.CertPool) (*HTTPBackend, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("backend URL %q is not http or https", baseURL)
+	}
+	return &HTTPBackend{BaseURL: u, RootCAs: rootCAs}, nil
+}
+
+// Get implements Backend.
+func (b *HTTPBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	value, _, err := b.GetWithExpiry(ctx, params)
+	return value, err
+}
+
+// GetWithExpiry implements Expirer.
+func (b *HTTPBackend) GetWithExpiry(ctx context.Context, params GetKeychainSecretParams) ([]byte, time.Time, error) {
+	secret, err := b.Fetch(ctx, params)
+	return secret.Value, secret.Expiry, err
+}
+
+// Fetch implements Fetcher.
+func (b *HTTPBackend) Fetch(ctx context.Context, params GetKeychainSecretParams) (FetchedSecret, error) {
+	secret, err := b.getSecret(ctx, params)
+	if err != nil {
+		return FetchedSecret{}, err
+	}
+	fetched := FetchedSecret{Value: secret.Value, Version: secret.Version}
+	if secret.Expiry != nil {
+		fetched.Expiry = *secret.Expiry
+	}
+	return fetched, nil
+}
+
+// LatestVersion implements Versioner.
+func (b *HTTPBackend) LatestVersion(ctx context.Context, params GetKeychainSecretParams) (string, error) {
+	params.Version = ""
+	secret, err := b.getSecret(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return secret.Version, nil
+}
+
+// List implements Backend.
+func (b *HTTPBackend) List(ctx context.Context, params ListKeychainSecretsParams) ([]string, error) {
+	group, err := groupSegment(params.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	var list HTTPSecretList
+	if err := b.do(ctx, http.MethodGet, path.Join("/v1/groups", group, "secrets"), nil, nil, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Names, nil
+}
+
+// Put implements Writer.
+func (b *HTTPBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	p, err := secretPath(params.Group, params.Name)
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{}
+	if params.IfAbsent {
+		header.Set("If-None-Match", "*")
+	}
+	if params.IfVersion != "" {
+		header.Set("If-Match", strconv.Quote(params.IfVersion))
+	}
+
+	var secret HTTPSecret
+	err = b.do(ctx, http.MethodPut, p, nil, header, HTTPSecret{Value: value}, &secret)
+	switch {
+	case params.IfAbsent && errors.Is(err, errPreconditionFailed):
+		return "", fmt.Errorf("%s: %w", params.Name, ErrAlreadyExists)
+	case params.IfVersion != "" && errors.Is(err, errPreconditionFailed):
+		return "", fmt.Errorf("%s: %w", params.Name, ErrConflict)
+	}
+	if err != nil {
+		return "", err
+	}
+	return secret.Version, nil
+}
+
+// getSecret fetches a secret.
+func (b *HTTPBackend) getSecret(ctx context.Context, params GetKeychainSecretParams) (*HTTPSecret, error) {
+	p, err := secretPath(params.Group, params.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if params.Version != "" {
+		query.Set("version", params.Version)
+	}
+
+	var secret HTTPSecret
+	if err := b.do(ctx, http.MethodGet, p, query, nil, nil, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// errPreconditionFailed is wrapped by the errors of requests whose preconditions, e.g. If-None-Match, failed.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// do makes a request for p, relative to the base URL, with header, if not nil, and the JSON encoding of body, if not
+// nil, and decodes the JSON response into v.
+func (b *HTTPBackend) do(ctx context.Context, method, p string, query url.Values, header http.Header, body, v interface{}) error {
+	u := *b.BaseURL
+	u.Path = path.Join(u.Path, p)
+	u.RawQuery = query.Encode()
+
+	client, err := b.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	timeout := b.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain a little of the body so the connection can be reused.
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s %s: %w", method, u.Path, ErrNotFound)
+		}
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("%s %s: %w", method, u.Path, errPreconditionFailed)
+		}
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("%s %s: %s: %w", method, u.Path, resp.Status, ErrUnavailable)
+		}
+		return fmt.Errorf("%s %s: %s", method, u.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// client returns the http.Client for the identity in ctx, creating it if the identity is new or has changed.
+func (b *HTTPBackend) client(ctx context.Context) (*http.Client, error) {
+	identity := IdentityFrom(ctx)
+
+	var key types.NamespacedName
+	var resourceVersion string
+	if identity != nil {
+		key = types.NamespacedName{Namespace: identity.Namespace, Name: identity.Name}
+		resourceVersion = identity.ResourceVersion
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cached, ok := b.clients[key]; ok && cached.resourceVersion == resourceVersion {
+		return cached.client, nil
+	}
+
+	tlsConfig := &tls.Config{RootCAs: b.RootCAs}
+	if identity != nil {
+		// The identity holds both the certificate and its private key.
+		pemData := identity.Data[IdentityCertKey]
+		cert, err := tls.X509KeyPair(pemData, pemData)
+		if err != nil {
+			return nil, fmt.Errorf("identity %s: %v", key, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}}
+
+	if b.clients == nil {
+		b.clients = map[types.NamespacedName]identityClient{}
+	}
+	if previous, ok := b.clients[key]; ok {
+		previous.client.CloseIdleConnections()
+	}
+	b.clients[key] = identityClient{resourceVersion: resourceVersion, client: client}
+	return client, nil
+}
+
+// secretPath returns the path of the secret name in group, after validating both, see ValidateKeychainName.
+func secretPath(group, name string) (string, error) {
+	segment, err := groupSegment(group)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateKeychainName(name); err != nil {
+		return "", err
+	}
+	return path.Join("/v1/secrets", segment, name), nil
+}
+
+// groupSegment returns the path segment for group, which is "-" for secrets outside of any group, after validating it.
+func groupSegment(group string) (string, error) {
+	if group == "" {
+		return "-", nil
+	}
+	if err := ValidateKeychainName(group); err != nil {
+		return "", err
+	}
+	return group, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"crypto/x509"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/davidewatson/keychain/controllers"
+	"github.com/davidewatson/keychain/controllers/keychaintest"
+)
+
+func TestHTTPBackend(t *testing.T) {
+	server := keychaintest.NewServer()
+	defer server.Close()
+
+	expiry := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	server.Put("DATABASE", "DB_PASSWORD", HTTPSecret{Value: []byte("old"), Version: "1"})
+	server.Put("DATABASE", "DB_PASSWORD", HTTPSecret{Value: []byte("new"), Version: "2", Expiry: &expiry})
+	server.Put("DATABASE", "DB_USER", HTTPSecret{Value: []byte("app")})
+	server.Put("", "SUPER_SECRET", HTTPSecret{Value: []byte("hunter2")})
+
+	backend, err := NewHTTPBackend(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	ctx := context.Background()
+
+	var testsTable = []struct {
+		name    string
+		params  GetKeychainSecretParams
+		value   string
+		version string
+		expiry  time.Time
+		valid   bool
+	}{
+		{name: "latest version", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD"},
+			value: "new", version: "2", expiry: expiry, valid: true},
+		{name: "pinned version", params: GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD", Version: "1"},
+			value: "old", version: "1", valid: true},
+		{name: "no group", params: GetKeychainSecretParams{Name: "SUPER_SECRET"}, value: "hunter2", valid: true},
+		{name: "missing secret", params: GetKeychainSecretParams{Name: "MISSING"}, valid: false},
+		{name: "missing version", params: GetKeychainSecretParams{Name: "SUPER_SECRET", Version: "9"}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := Fetch(ctx, backend, tt.params)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if string(secret.Value) != tt.value || !secret.Expiry.Equal(tt.expiry) {
+				t.Errorf("Value %q expiring %v, expected %q expiring %v", secret.Value, secret.Expiry, tt.value, tt.expiry)
+			}
+			if secret.Version != tt.version || secret.VersionErr != nil {
+				t.Errorf("Version %q (%v), expected %q", secret.Version, secret.VersionErr, tt.version)
+			}
+		})
+	}
+
+	version, err := LatestVersion(ctx, backend, GetKeychainSecretParams{Group: "DATABASE", Name: "DB_PASSWORD", Version: "1"})
+	if err != nil || version != "2" {
+		t.Errorf("Latest version %q (%v), expected 2", version, err)
+	}
+	names, err := backend.List(ctx, ListKeychainSecretsParams{Group: "DATABASE"})
+	if expected := []string{"DB_PASSWORD", "DB_USER"}; err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("List %v (%v), expected %v", names, err, expected)
+	}
+
+	if _, err := backend.Get(ctx, GetKeychainSecretParams{Name: "GENERATED"}); !IsNotFound(err) {
+		t.Errorf("Get of a missing secret returned %v, expected not found", err)
+	}
+	version, err = backend.Put(ctx, PutKeychainSecretParams{Name: "GENERATED"}, []byte("generated"))
+	if err != nil || version != "1" {
+		t.Errorf("Put version %q (%v), expected 1", version, err)
+	}
+	if value, err := backend.Get(ctx, GetKeychainSecretParams{Name: "GENERATED"}); err != nil || string(value) != "generated" {
+		t.Errorf("Get %q (%v) after Put, expected generated", value, err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Name: "GENERATED", IfAbsent: true}, []byte("again")); !IsAlreadyExists(err) {
+		t.Errorf("Put if absent of an existing secret returned %v, expected already exists", err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Name: "CREATED", IfAbsent: true}, []byte("created")); err != nil {
+		t.Errorf("Put if absent of a missing secret failed: %v", err)
+	}
+	if _, err := backend.Put(ctx, PutKeychainSecretParams{Name: "GENERATED", IfVersion: "9"}, []byte("again")); !IsConflict(err) {
+		t.Errorf("Put if version of another version returned %v, expected conflict", err)
+	}
+	if version, err := backend.Put(ctx, PutKeychainSecretParams{Name: "GENERATED", IfVersion: "1"}, []byte("replaced")); err != nil || version != "2" {
+		t.Errorf("Put if version of the latest version returned %q (%v), expected 2", version, err)
+	}
+}
+
+func TestHTTPBackendMutualTLS(t *testing.T) {
+	now := time.Now()
+	_, _, ca, caKey := newCertificate(t, "ca", true, now.Add(time.Hour), nil, nil)
+	certPEM, keyPEM, _, _ := newCertificate(t, "team-a", false, now.Add(time.Hour), ca, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	server := keychaintest.NewTLSServer(clientCAs)
+	defer server.Close()
+	server.Put("", "SUPER_SECRET", HTTPSecret{Value: []byte("hunter2")})
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	backend, err := NewHTTPBackend(server.URL, rootCAs)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	params := GetKeychainSecretParams{Name: "SUPER_SECRET"}
+
+	if _, err := backend.Get(context.Background(), params); err == nil {
+		t.Errorf("Get without an identity succeeded, expected the server to require a client certificate")
+	}
+
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "keychain-system", Name: "team-a", ResourceVersion: "1"},
+		Data:       map[string][]byte{IdentityCertKey: append(certPEM, keyPEM...)},
+	}
+	ctx := WithIdentity(context.Background(), identity)
+	for i := 0; i < 2; i++ {
+		value, err := backend.Get(ctx, params)
+		if err != nil || string(value) != "hunter2" {
+			t.Fatalf("Get %q (%v), expected hunter2", value, err)
+		}
+	}
+	if clients := server.Clients(); !reflect.DeepEqual(clients, []string{"team-a", "team-a"}) {
+		t.Errorf("Clients %v, expected the identity on every request", clients)
+	}
+}
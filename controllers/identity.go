@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// IdentityCertKey is the key within an identity Secret holding the PEM encoded certificate (and private key).
+	IdentityCertKey = "cert"
+)
+
+// IdentitySecretName returns the name of the identity Secret, within the controller's namespace, for a namespace.
+// Namespace names are unique within a cluster, so this name will be unique as well.
+func IdentitySecretName(namespace string) string {
+	return namespace
+}
+
+// IdentityRenewBefore is how long before its certificate expires an identity is provisioned again.
+const IdentityRenewBefore = 7 * 24 * time.Hour
+
+// IdentityParams returns the parameters used to provision the identity for a namespace. The namespace is part of the
+// common name, so backends can tell the identities of namespaces apart.
+func IdentityParams(namespace string) ProvisionServiceIdentityParams {
+	return ProvisionServiceIdentityParams{
+		Algorithm: "rsa:4096",
+		Days:      365,
+		Subject:   "'/CN=" + identityCommonName(namespace) + "/O=Facebook/C=US'",
+	}
+}
+
+// identityCommonName returns the common name of the identity of a namespace.
+func identityCommonName(namespace string) string {
+	return namespace + ".judkins.house"
+}
+
+// CheckIdentity returns an error if the identity Secret of namespace cannot be used at now, and must be provisioned
+// again: its certificate lacks a private key, e.g. because it predates mutual TLS, expires within IdentityRenewBefore,
+// or names another namespace, e.g. because it predates namespaces being part of the common name.
+func CheckIdentity(identitySecret *corev1.Secret, namespace string, now time.Time) error {
+	pemData := identitySecret.Data[IdentityCertKey]
+	if _, err := tls.X509KeyPair(pemData, pemData); err != nil {
+		return fmt.Errorf("identity secret %s/%s has no key pair: %v", identitySecret.Namespace, identitySecret.Name, err)
+	}
+	cert, err := ParseIdentityCertificate(identitySecret)
+	if err != nil {
+		return err
+	}
+	if now.Add(IdentityRenewBefore).After(cert.NotAfter) {
+		return fmt.Errorf("identity secret %s/%s expires at %s", identitySecret.Namespace, identitySecret.Name, cert.NotAfter)
+	}
+	if cert.Subject.CommonName != identityCommonName(namespace) {
+		return fmt.Errorf("identity secret %s/%s is for %q", identitySecret.Namespace, identitySecret.Name, cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// getOrCreateIdentity gets or creates the identity of namespace. See KeychainSecretReconciler.GetOrCreateIdentity.
+func getOrCreateIdentity(ctx context.Context, c client.Client, namespace string) (*corev1.Secret, error) {
+	// We store the identity within the controllers namespace and not the KeychainSecrets namespace. This is so we may
+	// control who may create Secrets from KeychainSecrets.
+	controllerNamespace := os.Getenv("CONTROLLER_NAMESPACE")
+	certName := IdentitySecretName(namespace)
+
+	// Check if we already have an identity
+	identitySecret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: controllerNamespace, Name: certName}, identitySecret); err != nil {
+		// Either we don't have an identity, or there was another error.
+		if client.IgnoreNotFound(err) != nil {
+			// Something else is wrong, give up!
+			return nil, err
+		}
+
+		// We need to create an identity
+		cert, err := ProvisionServiceIdentity(ctx, IdentityParams(namespace))
+		if err != nil {
+			return nil, err
+		}
+
+		data := map[string][]byte{IdentityCertKey: cert}
+
+		identitySecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: controllerNamespace,
+				Name:      certName,
+			},
+			Data: data,
+		}
+		err = c.Create(ctx, identitySecret)
+		if err != nil {
+			return nil, err
+		}
+		return identitySecret, nil
+	}
+
+	// An identity which cannot be used is replaced, in place, so clients of backends pick up the new one.
+	if err := CheckIdentity(identitySecret, namespace, time.Now()); err != nil {
+		cert, err := ProvisionServiceIdentity(ctx, IdentityParams(namespace))
+		if err != nil {
+			return nil, err
+		}
+		identitySecret.Data = map[string][]byte{IdentityCertKey: cert}
+		if err := c.Update(ctx, identitySecret); err != nil {
+			return nil, err
+		}
+	}
+
+	return identitySecret, nil
+}
+
+// ParseIdentityCertificate returns the first certificate in an identity Secret created by GetOrCreateIdentity.
+func ParseIdentityCertificate(identitySecret *corev1.Secret) (*x509.Certificate, error) {
+	rest := identitySecret.Data[IdentityCertKey]
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("identity secret %s/%s has no certificate", identitySecret.Namespace, identitySecret.Name)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
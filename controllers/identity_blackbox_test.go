@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestIdentityParams(t *testing.T) {
+	var testsTable = []struct {
+		namespace string
+		subject   string
+	}{
+		{namespace: "default", subject: "'/CN=default.judkins.house/O=Facebook/C=US'"},
+		{namespace: "payments", subject: "'/CN=payments.judkins.house/O=Facebook/C=US'"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.namespace, func(t *testing.T) {
+			if subject := IdentityParams(tt.namespace).Subject; subject != tt.subject {
+				t.Errorf("Subject %q, expected %q", subject, tt.subject)
+			}
+		})
+	}
+}
+
+func TestCheckIdentity(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM, _, _ := newCertificate(t, "default.judkins.house", false, now.Add(365*24*time.Hour), nil, nil)
+	expiringPEM, expiringKeyPEM, _, _ := newCertificate(t, "default.judkins.house", false, now.Add(24*time.Hour), nil, nil)
+	otherPEM, otherKeyPEM, _, _ := newCertificate(t, "judkins.house", false, now.Add(365*24*time.Hour), nil, nil)
+
+	var testsTable = []struct {
+		name   string
+		data   []byte
+		usable bool
+	}{
+		{name: "certificate and key", data: append(append([]byte{}, certPEM...), keyPEM...), usable: true},
+		{name: "certificate without key", data: certPEM, usable: false},
+		{name: "empty", data: nil, usable: false},
+		{name: "expiring certificate", data: append(append([]byte{}, expiringPEM...), expiringKeyPEM...), usable: false},
+		{name: "certificate without the namespace", data: append(append([]byte{}, otherPEM...), otherKeyPEM...), usable: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &corev1.Secret{Data: map[string][]byte{IdentityCertKey: tt.data}}
+			if err := CheckIdentity(identity, "default", now); (err == nil) != tt.usable {
+				t.Errorf("Error observed %v, expected usable %v", err, tt.usable)
+			}
+		})
+	}
+}
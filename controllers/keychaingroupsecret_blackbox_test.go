@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestFilterGroupEntries(t *testing.T) {
+	names := []string{"DB_USER", "DB_PASSWORD", "API_TOKEN", "API_TOKEN_OLD"}
+
+	var testsTable = []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+		valid    bool
+	}{
+		{name: "no patterns include everything", expected: []string{"API_TOKEN", "API_TOKEN_OLD", "DB_PASSWORD", "DB_USER"}, valid: true},
+		{name: "include filters", include: []string{"DB_*"}, expected: []string{"DB_PASSWORD", "DB_USER"}, valid: true},
+		{name: "exclude wins", include: []string{"API_*"}, exclude: []string{"*_OLD"}, expected: []string{"API_TOKEN"}, valid: true},
+		{name: "bad patterns are errors", include: []string{"["}, valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := FilterGroupEntries(names, tt.include, tt.exclude)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if tt.valid && !reflect.DeepEqual(entries, tt.expected) {
+				t.Errorf("Entries observed %v, expected %v", entries, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPerEntrySecretName(t *testing.T) {
+	var testsTable = []struct {
+		prefix   string
+		entry    string
+		expected string
+		valid    bool
+	}{
+		{prefix: "db-", entry: "DB_PASSWORD", expected: "db-db-password", valid: true},
+		{prefix: "", entry: "_LEADING", valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.entry, func(t *testing.T) {
+			name, err := PerEntrySecretName(tt.prefix, tt.entry)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if name != tt.expected {
+				t.Errorf("Name observed %q, expected %q", name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKeychainGroupSecretReconcile(t *testing.T) {
+	backend, cleanup := newFileBackend(t, map[string]string{
+		"DATABASE/DB_USER":     "app",
+		"DATABASE/DB_PASSWORD": "hunter2",
+		"DATABASE/Db_Host":     "invalid Keychain name",
+		"DATABASE/DB_":         "invalid Secret name",
+	})
+	defer cleanup()
+
+	// The fake client only assigns resource versions on writes, but existing objects always have one.
+	unowned := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-db-password", ResourceVersion: "1"},
+		Data: map[string][]byte{"password": []byte("mine")}}
+	c, scheme := newFakeClient(t, unowned, &aqueductv1.KeychainGroupSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", UID: "group-uid"},
+		Spec:       aqueductv1.KeychainGroupSecretSpec{Group: "DATABASE", Mode: aqueductv1.SecretPerEntry, SecretName: "db-", TTL: "24h"},
+	})
+	r := &KeychainGroupSecretReconciler{Client: c, Log: ctrl.Log, Scheme: scheme, Backend: backend}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "db"}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	var testsTable = []struct {
+		name   string
+		secret string
+		key    string
+		value  string
+	}{
+		{name: "owned Secrets are synced", secret: "db-db-user", key: "DB_USER", value: "app"},
+		{name: "Secrets somebody else created are left as is", secret: "db-db-password", key: "password", value: "mine"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var secret corev1.Secret
+			if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: tt.secret}, &secret); err != nil {
+				t.Fatalf("Get Secret failed: %v", err)
+			}
+			if value := string(secret.Data[tt.key]); value != tt.value || len(secret.Data) != 1 {
+				t.Errorf("Data %v, expected only %s=%s", secret.Data, tt.key, tt.value)
+			}
+		})
+	}
+
+	var groupSecret aqueductv1.KeychainGroupSecret
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &groupSecret); err != nil {
+		t.Fatalf("Get KeychainGroupSecret failed: %v", err)
+	}
+	if ready := groupSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "Conflict" {
+		t.Errorf("Ready %+v, expected a Conflict", ready)
+	}
+	expected := []corev1.SecretReference{{Namespace: "default", Name: "db-db-user"}}
+	if !reflect.DeepEqual(groupSecret.Status.SecretRefs, expected) {
+		t.Errorf("SecretRefs %v, expected %v", groupSecret.Status.SecretRefs, expected)
+	}
+	if invalid := []string{"DB_", "Db_Host"}; !reflect.DeepEqual(groupSecret.Status.InvalidEntries, invalid) {
+		t.Errorf("InvalidEntries %v, expected %v", groupSecret.Status.InvalidEntries, invalid)
+	}
+}
+
+func TestKeychainGroupSecretInvalidTTL(t *testing.T) {
+	c, scheme := newFakeClient(t, &aqueductv1.KeychainGroupSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec:       aqueductv1.KeychainGroupSecretSpec{Group: "DATABASE", TTL: "soon"},
+	})
+	r := &KeychainGroupSecretReconciler{Client: c, Log: ctrl.Log, Scheme: scheme, Backend: fakeBackend{}}
+	key := types.NamespacedName{Namespace: "default", Name: "db"}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err == nil {
+		t.Errorf("Reconcile succeeded, expected the invalid TTL to fail it")
+	}
+	var groupSecret aqueductv1.KeychainGroupSecret
+	if err := c.Get(context.Background(), key, &groupSecret); err != nil {
+		t.Fatalf("Get KeychainGroupSecret failed: %v", err)
+	}
+	if ready := groupSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "InvalidTTL" {
+		t.Errorf("Ready %v, expected InvalidTTL", ready)
+	}
+}
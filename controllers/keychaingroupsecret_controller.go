@@ -0,0 +1,339 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// errNotControlled is returned by the mutate functions of CreateOrUpdate for objects the reconciler does not control.
+var errNotControlled = errors.New("object exists and is not controlled by the reconciler")
+
+// KeychainGroupSecretReconciler reconciles a KeychainGroupSecret object
+type KeychainGroupSecretReconciler struct {
+	client.Client
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Scheduler *RotationScheduler // Optional, syncs happen exactly on TTL if nil
+	Backend   Backend            // Optional, a CommandBackend is used if nil
+
+	AccessPolicies *AccessPolicyChecker // Optional, access to Keychain secrets is not restricted if nil
+}
+
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychaingroupsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychaingroupsecrets/status,verbs=get;update;patch
+
+// Reconcile is called when a watched resource needs to be reconciled.
+func (r *KeychainGroupSecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	var groupSecret aqueductv1.KeychainGroupSecret
+
+	ctx := context.Background()
+	log := r.Log.WithValues("keychaingroupsecret", req.NamespacedName)
+
+	if err := r.Get(ctx, req.NamespacedName, &groupSecret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	duration, err := time.ParseDuration(groupSecret.Spec.TTL)
+	if err != nil {
+		return r.fail(ctx, &groupSecret, "InvalidTTL", fmt.Errorf("invalid TTL %q: %v", groupSecret.Spec.TTL, err))
+	}
+
+	scheduler := r.Scheduler
+	if scheduler == nil {
+		scheduler = &RotationScheduler{}
+	}
+	now := time.Now()
+	if !groupSecret.Status.LastUpdate.IsZero() {
+		next := scheduler.NextRotation(req.NamespacedName, groupSecret.Status.LastUpdate.Time, duration)
+		if now.Before(next) {
+			return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+		}
+		if ok, wait := scheduler.Admit(req.NamespacedName, now); !ok {
+			log.Info("sync deferred", "wait", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	identity, err := getOrCreateIdentity(ctx, r.Client, req.Namespace)
+	if err != nil {
+		return r.fail(ctx, &groupSecret, "IdentityFailed", err)
+	}
+	ctx = WithIdentity(ctx, identity)
+
+	entries, denied, invalid, err := r.allowedEntries(ctx, groupSecret)
+	if err != nil {
+		return r.fail(ctx, &groupSecret, "ListFailed", err)
+	}
+	if len(denied) > 0 {
+		log.Info("access denied", "entries", denied)
+	}
+	if len(invalid) > 0 {
+		log.Info("invalid entries skipped", "entries", invalid)
+	}
+
+	values, err := r.fetchEntries(ctx, groupSecret, entries)
+	if err != nil {
+		return r.fail(ctx, &groupSecret, "FetchFailed", err)
+	}
+
+	secretRefs, conflicts, err := r.syncSecrets(ctx, groupSecret, values)
+	if err != nil {
+		return r.fail(ctx, &groupSecret, "SyncFailed", err)
+	}
+
+	next := scheduler.NextRotation(req.NamespacedName, now, duration)
+	groupSecret.Status.Entries = entries
+	groupSecret.Status.DeniedEntries = denied
+	groupSecret.Status.InvalidEntries = invalid
+	groupSecret.Status.SecretRefs = secretRefs
+	groupSecret.Status.LastUpdate = metav1.NewTime(now)
+	groupSecret.Status.NextRotation = metav1.NewTime(next)
+	if len(conflicts) > 0 {
+		log.Info("secrets not owned", "secrets", conflicts)
+		groupSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, "Conflict",
+			fmt.Sprintf("Secrets %s exist and are not owned by this KeychainGroupSecret", strings.Join(conflicts, ", ")))
+	} else {
+		groupSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionTrue, "Synced",
+			fmt.Sprintf("%d entries synced from Keychain", len(entries)))
+	}
+	if err := r.Status().Update(ctx, &groupSecret); err != nil {
+		log.Error(err, "unable to update KeychainGroupSecret status")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// fail records a failed sync in the KeychainGroupSecret's status and schedules a retry.
+func (r *KeychainGroupSecretReconciler) fail(ctx context.Context, groupSecret *aqueductv1.KeychainGroupSecret, reason string, err error) (ctrl.Result, error) {
+	groupSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, reason, err.Error())
+	if updateErr := r.Status().Update(ctx, groupSecret); updateErr != nil {
+		r.Log.Error(updateErr, "unable to update KeychainGroupSecret status", "keychaingroupsecret", groupSecret.ObjectMeta.Name)
+	}
+	return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+}
+
+// allowedEntries lists the group's entries and filters them by the include and exclude patterns. Entries the
+// namespace's access policies deny, and entries which are invalid, see ValidateGroupEntry, are returned separately.
+// Backends may list anything, so invalid entries are skipped rather than failing the group.
+func (r *KeychainGroupSecretReconciler) allowedEntries(ctx context.Context, groupSecret aqueductv1.KeychainGroupSecret) ([]string, []string, []string, error) {
+	group := groupSecret.Spec.Group
+
+	names, err := r.backend().List(ctx, ListKeychainSecretsParams{Group: group})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	entries, err := FilterGroupEntries(names, groupSecret.Spec.Include, groupSecret.Spec.Exclude)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var allowed, denied, invalid []string
+	for _, entry := range entries {
+		if err := ValidateGroupEntry(groupSecret, entry); err != nil {
+			invalid = append(invalid, entry)
+			continue
+		}
+		if r.AccessPolicies != nil {
+			ok, _, err := r.AccessPolicies.Allowed(ctx, groupSecret.Namespace, group, entry)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if !ok {
+				denied = append(denied, entry)
+				continue
+			}
+		}
+		allowed = append(allowed, entry)
+	}
+	return allowed, denied, invalid, nil
+}
+
+// ValidateGroupEntry returns an error unless entry is a valid Keychain name and, in PerEntry mode, makes a valid
+// Secret name.
+func ValidateGroupEntry(groupSecret aqueductv1.KeychainGroupSecret, entry string) error {
+	if err := ValidateKeychainName(entry); err != nil {
+		return err
+	}
+	if groupSecret.Spec.Mode == aqueductv1.SecretPerEntry {
+		_, err := PerEntrySecretName(perEntryPrefix(groupSecret), entry)
+		return err
+	}
+	return nil
+}
+
+// perEntryPrefix returns the prefix of the Secret names in PerEntry mode.
+func perEntryPrefix(groupSecret aqueductv1.KeychainGroupSecret) string {
+	if groupSecret.Spec.SecretName != "" {
+		return groupSecret.Spec.SecretName
+	}
+	return groupSecret.Name + "-"
+}
+
+// fetchEntries fetches the value of each entry.
+func (r *KeychainGroupSecretReconciler) fetchEntries(ctx context.Context, groupSecret aqueductv1.KeychainGroupSecret, entries []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		value, err := r.backend().Get(ctx, GetKeychainSecretParams{Group: groupSecret.Spec.Group, Name: entry})
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s: %v", entry, err)
+		}
+		values[entry] = value
+	}
+	return values, nil
+}
+
+// backend returns the configured Backend, or a CommandBackend.
+func (r *KeychainGroupSecretReconciler) backend() Backend {
+	if r.Backend == nil {
+		return CommandBackend{}
+	}
+	return r.Backend
+}
+
+// syncSecrets creates or updates the Secrets for the group's values, and deletes Secrets for entries which are no
+// longer synced. It returns references to the synced Secrets, and the names of Secrets which were not synced because
+// somebody else created them: those are never taken over.
+func (r *KeychainGroupSecretReconciler) syncSecrets(ctx context.Context, groupSecret aqueductv1.KeychainGroupSecret, values map[string][]byte) ([]corev1.SecretReference, []string, error) {
+	desired := map[string]map[string][]byte{}
+	switch groupSecret.Spec.Mode {
+	case aqueductv1.SecretPerEntry:
+		prefix := perEntryPrefix(groupSecret)
+		for entry, value := range values {
+			name, err := PerEntrySecretName(prefix, entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			desired[name] = map[string][]byte{entry: value}
+		}
+	default:
+		name := groupSecret.Spec.SecretName
+		if name == "" {
+			name = groupSecret.Name
+		}
+		desired[name] = values
+	}
+
+	var secretRefs []corev1.SecretReference
+	var conflicts []string
+	for name, data := range desired {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: groupSecret.Namespace, Name: name}}
+		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			if secret.ResourceVersion != "" && !metav1.IsControlledBy(secret, &groupSecret) {
+				return errNotControlled
+			}
+			if secret.ObjectMeta.Labels == nil {
+				secret.ObjectMeta.Labels = map[string]string{}
+			}
+			secret.ObjectMeta.Labels[aqueductv1.KeychainGroupSecretLabel] = groupSecret.Name
+			secret.Data = data
+			return controllerutil.SetControllerReference(&groupSecret, secret, r.Scheme)
+		})
+		if err == errNotControlled {
+			conflicts = append(conflicts, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		secretRefs = append(secretRefs, corev1.SecretReference{Namespace: secret.Namespace, Name: secret.Name})
+	}
+	sort.Slice(secretRefs, func(i, j int) bool { return secretRefs[i].Name < secretRefs[j].Name })
+	sort.Strings(conflicts)
+
+	// Remove Secrets for entries which were deleted from the group or filtered out.
+	var owned corev1.SecretList
+	if err := r.List(ctx, &owned, client.InNamespace(groupSecret.Namespace),
+		client.MatchingLabels{aqueductv1.KeychainGroupSecretLabel: groupSecret.Name}); err != nil {
+		return nil, nil, err
+	}
+	for i := range owned.Items {
+		secret := &owned.Items[i]
+		if _, ok := desired[secret.Name]; ok || !metav1.IsControlledBy(secret, &groupSecret) {
+			continue
+		}
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return nil, nil, err
+		}
+	}
+
+	return secretRefs, conflicts, nil
+}
+
+// FilterGroupEntries returns the sorted names matching any include pattern, or all names if there are none, and no
+// exclude pattern.
+func FilterGroupEntries(names, include, exclude []string) ([]string, error) {
+	var entries []string
+	for _, name := range names {
+		included := len(include) == 0
+		for _, pattern := range include {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, err
+			}
+			included = included || matched
+		}
+		for _, pattern := range exclude {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, err
+			}
+			included = included && !matched
+		}
+		if included {
+			entries = append(entries, name)
+		}
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// PerEntrySecretName returns the name of the Secret for a Keychain entry in PerEntry mode. Keychain names are upper
+// case with underscores, so they are converted to a valid Secret name.
+func PerEntrySecretName(prefix, entry string) (string, error) {
+	name := prefix + strings.ReplaceAll(strings.ToLower(entry), "_", "-")
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("entry %s does not make a valid Secret name %q: %s", entry, name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// SetupWithManager sets up the controller with manager.
+func (r *KeychainGroupSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aqueductv1.KeychainGroupSecret{}).
+		Owns(&corev1.Secret{}).
+		Complete(r)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+	"github.com/davidewatson/keychain/controllers/keychaintest"
+)
+
+func TestDecidePublishAction(t *testing.T) {
+	var testsTable = []struct {
+		name      string
+		current   string
+		exists    bool
+		published string
+		policy    aqueductv1.ConflictPolicy
+		expected  PublishAction
+	}{
+		{name: "missing secret is published", expected: PublishPut},
+		{name: "equal value is left alone", current: "new", exists: true, expected: PublishNone},
+		{name: "own value is updated", current: "old", exists: true, published: HashValue([]byte("old")), expected: PublishPut},
+		{name: "changed value conflicts", current: "other", exists: true, published: HashValue([]byte("old")), expected: PublishConflict},
+		{name: "existing value conflicts", current: "other", exists: true, expected: PublishConflict},
+		{name: "changed value is overwritten", current: "other", exists: true, published: HashValue([]byte("old")),
+			policy: aqueductv1.ConflictOverwrite, expected: PublishPut},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			action := DecidePublishAction([]byte(tt.current), tt.exists, []byte("new"), tt.published, tt.policy)
+			if action != tt.expected {
+				t.Errorf("Action observed %v, expected %v", action, tt.expected)
+			}
+		})
+	}
+}
+
+// interferingBackend is an HTTPBackend failing to store the secrets named in fail, and in which someone else stores
+// the secrets named in race right before they are.
+type interferingBackend struct {
+	*HTTPBackend
+	server     *keychaintest.Server
+	fail, race map[string]bool
+}
+
+func (b interferingBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	if b.fail[params.Name] {
+		return "", fmt.Errorf("%s: %w", params.Name, ErrUnavailable)
+	}
+	if b.race[params.Name] {
+		b.server.Put(params.Group, params.Name, HTTPSecret{Value: []byte("theirs"), Version: "theirs"})
+	}
+	return b.HTTPBackend.Put(ctx, params, value)
+}
+
+func TestReconcilePublication(t *testing.T) {
+	server := keychaintest.NewServer()
+	defer server.Close()
+	httpBackend, err := NewHTTPBackend(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	c, scheme := newFakeClient(t,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}},
+		&aqueductv1.KeychainPublication{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec: aqueductv1.KeychainPublicationSpec{SecretName: "app", TTL: "1h", Keys: []aqueductv1.PublicationKey{
+				{Key: "a", Name: "PUB_A"}, {Key: "b", Name: "PUB_B"}}},
+		})
+	r := &KeychainPublicationReconciler{Client: c, Log: ctrl.Log, Scheme: scheme}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "app"}
+
+	// Each step sets the Secret's values and how the backend interferes before reconciling.
+	var testsTable = []struct {
+		name      string
+		a         string
+		fail      string
+		race      string
+		published []string
+		conflicts []string
+		reason    string
+		keychain  string // The value of PUB_A afterwards
+	}{
+		{name: "failure keeps what was published", a: "a1", fail: "PUB_B", published: []string{"PUB_A"},
+			reason: "PublishFailed", keychain: "a1"},
+		{name: "own values are updated", a: "a2", published: []string{"PUB_A", "PUB_B"}, reason: "Published", keychain: "a2"},
+		{name: "concurrent changes are not overwritten", a: "a3", race: "PUB_A", published: []string{"PUB_A", "PUB_B"},
+			conflicts: []string{"PUB_A"}, reason: "Conflict", keychain: "theirs"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var secret corev1.Secret
+			if err := c.Get(ctx, key, &secret); err != nil {
+				t.Fatalf("Get Secret failed: %v", err)
+			}
+			secret.Data = map[string][]byte{"a": []byte(tt.a), "b": []byte("b")}
+			if err := c.Update(ctx, &secret); err != nil {
+				t.Fatalf("Update Secret failed: %v", err)
+			}
+			r.Backend = interferingBackend{HTTPBackend: httpBackend, server: server,
+				fail: map[string]bool{tt.fail: true}, race: map[string]bool{tt.race: true}}
+
+			// Failures are retried, returning the error.
+			r.Reconcile(ctrl.Request{NamespacedName: key})
+			var publication aqueductv1.KeychainPublication
+			if err := c.Get(ctx, key, &publication); err != nil {
+				t.Fatalf("Get KeychainPublication failed: %v", err)
+			}
+			var published []string
+			for _, entry := range publication.Status.Published {
+				published = append(published, entry.Name)
+			}
+			if !reflect.DeepEqual(published, tt.published) || !reflect.DeepEqual(publication.Status.Conflicts, tt.conflicts) {
+				t.Errorf("Published %v with conflicts %v, expected %v with conflicts %v",
+					published, publication.Status.Conflicts, tt.published, tt.conflicts)
+			}
+			if ready := publication.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != tt.reason {
+				t.Errorf("Ready %v, expected %s", ready, tt.reason)
+			}
+			value, err := httpBackend.Get(ctx, GetKeychainSecretParams{Name: "PUB_A"})
+			if err != nil || string(value) != tt.keychain {
+				t.Errorf("PUB_A holds %q (%v), expected %q", value, err, tt.keychain)
+			}
+		})
+	}
+}
+
+func TestReconcilePublicationInvalidTTL(t *testing.T) {
+	c, scheme := newFakeClient(t, &aqueductv1.KeychainPublication{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       aqueductv1.KeychainPublicationSpec{SecretName: "app", TTL: "soon"},
+	})
+	r := &KeychainPublicationReconciler{Client: c, Log: ctrl.Log, Scheme: scheme, Backend: fakeBackend{}}
+	key := types.NamespacedName{Namespace: "default", Name: "app"}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err == nil {
+		t.Errorf("Reconcile succeeded, expected the invalid TTL to fail it")
+	}
+	var publication aqueductv1.KeychainPublication
+	if err := c.Get(context.Background(), key, &publication); err != nil {
+		t.Fatalf("Get KeychainPublication failed: %v", err)
+	}
+	if ready := publication.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "InvalidTTL" {
+		t.Errorf("Ready %v, expected InvalidTTL", ready)
+	}
+}
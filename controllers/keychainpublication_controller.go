@@ -0,0 +1,285 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// PublicationSecretField is the name of the KeychainPublication field index of the Secrets they publish.
+const PublicationSecretField = "spec.secretName"
+
+// KeychainPublicationReconciler reconciles a KeychainPublication object
+type KeychainPublicationReconciler struct {
+	client.Client
+	Log     logr.Logger
+	Scheme  *runtime.Scheme
+	Backend Backend // Optional, a CommandBackend is used if nil
+
+	AccessPolicies *AccessPolicyChecker // Optional, access to Keychain secrets is not restricted if nil
+}
+
+// PublishAction is what a KeychainPublication does with a Keychain secret.
+type PublishAction string
+
+const (
+	// PublishNone leaves the Keychain secret as it is, because it already has the Secret's value.
+	PublishNone PublishAction = "None"
+	// PublishPut writes the Secret's value to Keychain.
+	PublishPut PublishAction = "Put"
+	// PublishConflict leaves the Keychain secret as it is, because it was changed by someone else.
+	PublishConflict PublishAction = "Conflict"
+)
+
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychainpublications,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychainpublications/status,verbs=get;update;patch
+
+// Reconcile is called when a watched resource needs to be reconciled.
+func (r *KeychainPublicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	var publication aqueductv1.KeychainPublication
+
+	ctx := context.Background()
+	log := r.Log.WithValues("keychainpublication", req.NamespacedName)
+
+	if err := r.Get(ctx, req.NamespacedName, &publication); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	duration, err := time.ParseDuration(publication.Spec.TTL)
+	if err != nil {
+		return r.fail(ctx, &publication, "InvalidTTL", fmt.Errorf("invalid TTL %q: %v", publication.Spec.TTL, err))
+	}
+
+	var secret corev1.Secret
+	secretKey := types.NamespacedName{Namespace: req.Namespace, Name: publication.Spec.SecretName}
+	if err := r.Get(ctx, secretKey, &secret); apierrors.IsNotFound(err) {
+		// The Secret may not have been minted yet. Watching it reconciles again once it is.
+		publication.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, "SecretNotFound",
+			fmt.Sprintf("Secret %s not found", publication.Spec.SecretName))
+		if err := r.Status().Update(ctx, &publication); err != nil {
+			log.Error(err, "unable to update KeychainPublication status")
+			return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+		}
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		return r.fail(ctx, &publication, "SecretFailed", err)
+	}
+
+	identity, err := getOrCreateIdentity(ctx, r.Client, req.Namespace)
+	if err != nil {
+		return r.fail(ctx, &publication, "IdentityFailed", err)
+	}
+	ctx = WithIdentity(ctx, identity)
+
+	// Each key's entry is recorded as soon as it is published, and kept even if a later key fails, so the next pass
+	// does not mistake the values published for someone else's.
+	records := map[string]aqueductv1.PublishedSecret{}
+	for _, published := range publication.Status.Published {
+		records[published.Name] = published
+	}
+	fail := func(reason string, err error) (ctrl.Result, error) {
+		publication.Status.Published = publishedEntries(publication.Spec.Keys, records)
+		return r.fail(ctx, &publication, reason, err)
+	}
+
+	now := time.Now()
+	group := publication.Spec.Group
+	var conflicts []string
+	for _, key := range publication.Spec.Keys {
+		value, ok := secret.Data[key.Key]
+		if !ok {
+			return fail("KeyNotFound", fmt.Errorf("secret %s has no key %s", secret.Name, key.Key))
+		}
+		if r.AccessPolicies != nil {
+			allowed, reason, err := r.AccessPolicies.AllowedPut(ctx, req.Namespace, group, key.Name)
+			if err != nil {
+				return fail("AccessPolicyFailed", err)
+			}
+			if !allowed {
+				return fail("AccessDenied", fmt.Errorf("%s: %s", key.Name, reason))
+			}
+		}
+
+		current, err := Fetch(ctx, r.backend(), GetKeychainSecretParams{Name: key.Name, Group: group})
+		exists := err == nil
+		if err != nil && !IsNotFound(err) {
+			return fail("FetchFailed", fmt.Errorf("fetching %s: %v", key.Name, err))
+		}
+
+		last, wasPublished := records[key.Name]
+		entry := aqueductv1.PublishedSecret{Key: key.Key, Name: key.Name, Hash: HashValue(value)}
+		switch DecidePublishAction(current.Value, exists, value, last.Hash, publication.Spec.ConflictPolicy) {
+		case PublishNone:
+			if wasPublished && last.Hash == entry.Hash {
+				entry.Version, entry.LastPublished = last.Version, last.LastPublished
+			}
+			records[key.Name] = entry
+		case PublishPut:
+			// Only what was fetched is replaced, unless the backend cannot check, so a concurrent write is not lost.
+			params := PutKeychainSecretParams{Name: key.Name, Group: group, IfAbsent: !exists, IfVersion: current.Version}
+			version, err := Put(ctx, r.backend(), params, value)
+			if IsNotSupported(err) && (params.IfAbsent || params.IfVersion != "") {
+				version, err = Put(ctx, r.backend(), PutKeychainSecretParams{Name: key.Name, Group: group}, value)
+			}
+			if IsAlreadyExists(err) || IsConflict(err) {
+				log.Info("Keychain secret was changed concurrently, not publishing", "group", group, "name", key.Name)
+				conflicts = append(conflicts, key.Name)
+				continue
+			}
+			if err != nil {
+				return fail("PublishFailed", fmt.Errorf("publishing %s: %v", key.Name, err))
+			}
+			log.Info("published Keychain secret", "group", group, "name", key.Name, "key", key.Key, "version", version)
+			entry.Version, entry.LastPublished = version, metav1.NewTime(now)
+			records[key.Name] = entry
+		case PublishConflict:
+			// The record of the last publication, if any, is kept, so the conflict persists until resolved.
+			log.Info("Keychain secret was changed by someone else, not publishing", "group", group, "name", key.Name)
+			conflicts = append(conflicts, key.Name)
+		}
+	}
+
+	published := publishedEntries(publication.Spec.Keys, records)
+	publication.Status.Published = published
+	publication.Status.Conflicts = conflicts
+	publication.Status.LastUpdate = metav1.NewTime(now)
+	if len(conflicts) > 0 {
+		message := fmt.Sprintf("Keychain secrets changed by someone else: %s", strings.Join(conflicts, ", "))
+		publication.Status.SetCondition(aqueductv1.ConditionConflict, corev1.ConditionTrue, "Conflict", message)
+		publication.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, "Conflict", message)
+	} else {
+		publication.Status.SetCondition(aqueductv1.ConditionConflict, corev1.ConditionFalse, "NoConflict", "")
+		publication.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionTrue, "Published",
+			fmt.Sprintf("%d keys published to Keychain", len(published)))
+	}
+	if err := r.Status().Update(ctx, &publication); err != nil {
+		log.Error(err, "unable to update KeychainPublication status")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+
+	return ctrl.Result{RequeueAfter: duration}, nil
+}
+
+// fail records a failed publication in the KeychainPublication's status and schedules a retry.
+func (r *KeychainPublicationReconciler) fail(ctx context.Context, publication *aqueductv1.KeychainPublication, reason string, err error) (ctrl.Result, error) {
+	publication.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, reason, err.Error())
+	if updateErr := r.Status().Update(ctx, publication); updateErr != nil {
+		r.Log.Error(updateErr, "unable to update KeychainPublication status", "keychainpublication", publication.ObjectMeta.Name)
+	}
+	return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+}
+
+// publishedEntries returns the recorded entries of the published keys, in their order.
+func publishedEntries(keys []aqueductv1.PublicationKey, records map[string]aqueductv1.PublishedSecret) []aqueductv1.PublishedSecret {
+	var published []aqueductv1.PublishedSecret
+	for _, key := range keys {
+		if entry, ok := records[key.Name]; ok {
+			published = append(published, entry)
+		}
+	}
+	return published
+}
+
+// backend returns the configured Backend, or a CommandBackend.
+func (r *KeychainPublicationReconciler) backend() Backend {
+	if r.Backend == nil {
+		return CommandBackend{}
+	}
+	return r.Backend
+}
+
+// DecidePublishAction returns what to do with a Keychain secret, which has the value current if exists, to publish
+// value. published is the hash of the value last published, if any. The Keychain secret was changed by someone else,
+// and so conflicts, if its value is neither value nor the one last published.
+func DecidePublishAction(current []byte, exists bool, value []byte, published string, policy aqueductv1.ConflictPolicy) PublishAction {
+	if !exists {
+		return PublishPut
+	}
+	hash := HashValue(current)
+	switch {
+	case hash == HashValue(value):
+		return PublishNone
+	case hash == published || policy == aqueductv1.ConflictOverwrite:
+		return PublishPut
+	default:
+		return PublishConflict
+	}
+}
+
+// HashValue returns the SHA-256 hash of a value, as recorded in KeychainPublication statuses.
+func HashValue(value []byte) string {
+	sum := sha256.Sum256(value)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// IndexPublicationSecret is the PublicationSecretField index function, returning the name of the Secret a
+// KeychainPublication publishes.
+func IndexPublicationSecret(obj runtime.Object) []string {
+	publication, ok := obj.(*aqueductv1.KeychainPublication)
+	if !ok {
+		return nil
+	}
+	return []string{publication.Spec.SecretName}
+}
+
+// publicationsFor returns requests for the KeychainPublications publishing a Secret.
+func (r *KeychainPublicationReconciler) publicationsFor(o handler.MapObject) []reconcile.Request {
+	var publications aqueductv1.KeychainPublicationList
+	if err := r.List(context.Background(), &publications, client.InNamespace(o.Meta.GetNamespace()),
+		client.MatchingFields{PublicationSecretField: o.Meta.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list KeychainPublications", "secret", o.Meta.GetNamespace()+"/"+o.Meta.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(publications.Items))
+	for _, publication := range publications.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: publication.Namespace, Name: publication.Name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with manager.
+func (r *KeychainPublicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&aqueductv1.KeychainPublication{}, PublicationSecretField, IndexPublicationSecret); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aqueductv1.KeychainPublication{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.publicationsFor),
+		}).
+		Complete(r)
+}
@@ -18,17 +18,23 @@ package controllers
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-
-	//types "apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	aqueductv1 "github.com/davidewatson/keychain/api/v1"
 )
@@ -40,13 +46,24 @@ const (
 // KeychainSecretReconciler reconciles a KeychainSecret object
 type KeychainSecretReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Scheduler *RotationScheduler // Optional, rotations happen exactly on TTL if nil
+	Backend   Backend            // Optional, a CommandBackend is used if nil
+
+	AccessPolicies *AccessPolicyChecker // Optional, access to Keychain secrets is not restricted if nil
+	Recorder       record.EventRecorder // Optional, events are not recorded if nil
+	// StaleGracePeriod is how long values the backend fails to refresh are served before the failure escalates. It
+	// escalates immediately if zero.
+	StaleGracePeriod time.Duration
+
+	changes backendChanges // Changes reported by the backend, see Watcher
 }
 
 // +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychainsecrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=aqueduct.k8s.facebook.com,resources=keychainsecrets/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 
 // Reconcile is called when a watched resource needs to be reconciled.
 func (r *KeychainSecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -58,141 +75,441 @@ func (r *KeychainSecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 	// Get current version of the spec.
 	if err := r.Get(ctx, req.NamespacedName, &keychainSecret); err != nil {
 		log.Error(err, "unable to fetch KeychainSecret")
+		if apierrors.IsNotFound(err) {
+			expiries.set(req.NamespacedName, time.Time{})
+			stales.set(req.NamespacedName, time.Time{})
+		}
 		// we'll ignore not-found errors, since they can't be fixed by an immediate
 		// requeue (we'll need to wait for a new notification), and we can get them
 		// on deleted requests.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	duration, err := time.ParseDuration(keychainSecret.Spec.TTL)
+	if err != nil {
+		panic("TTL was not a valid Duration. This should have been caught during validation!?")
+	}
+
+	if keychainSecret.Spec.Suspend {
+		return r.suspend(ctx, &keychainSecret)
+	}
+	if keychainSecret.Status.IsConditionTrue(aqueductv1.ConditionSuspended) {
+		log.Info("resumed")
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionSuspended, corev1.ConditionFalse, "Resumed", "Syncing resumed")
+		if err := r.Status().Update(ctx, &keychainSecret); err != nil {
+			return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+		}
+	}
+
+	// Policies are checked before the schedule, so that revoked access takes effect right away rather than at the next
+	// rotation. Changes to policies and namespaces requeue the KeychainSecrets they may affect.
+	if r.AccessPolicies != nil {
+		allowed, reason, err := r.AccessPolicies.AllowedSecrets(ctx, req.Namespace, RequestedSecrets(keychainSecret))
+		if err != nil {
+			return r.fail(ctx, &keychainSecret, "AccessCheckFailed", err)
+		}
+		if !allowed {
+			// Values a namespace may no longer request are withdrawn, rather than left in place. Not an error worth
+			// backing off for, the policy may change at any time, in which case the target is written again.
+			log.Info("access denied, deleting target", "reason", reason)
+			if err := r.deleteTarget(ctx, &keychainSecret); err != nil {
+				return r.fail(ctx, &keychainSecret, "DeleteFailed", err)
+			}
+			r.fail(ctx, &keychainSecret, "AccessDenied", errors.New(reason))
+			return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, nil
+		}
+	}
+
+	// Secrets which have never been synced, whose refresh was explicitly requested, or which the backend reported
+	// changed, are synced immediately. Existing ones wait for their turn. Dry runs are repeated whenever the spec changes.
+	scheduler := r.scheduler()
+	now := time.Now()
+	refreshRequest := keychainSecret.ObjectMeta.Annotations[aqueductv1.RefreshRequestedAnnotation]
+	refreshRequested := refreshRequest != "" && refreshRequest != keychainSecret.Status.LastRefreshRequest
+	if refreshRequested {
+		log.Info("refresh requested", "requestedAt", refreshRequest)
+	} else if r.changes.pending(req.NamespacedName) {
+		log.Info("backend reported a change")
+	} else if keychainSecret.Spec.DryRun {
+		if result := keychainSecret.Status.DryRun; result != nil && result.ObservedGeneration == keychainSecret.Generation {
+			next := scheduler.NextRotation(req.NamespacedName, result.Time.Time, duration)
+			if now.Before(next) {
+				return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+			}
+		}
+	} else if !keychainSecret.Status.LastUpdate.IsZero() {
+		// A target which was deleted or modified behind our back is restored immediately, regardless of schedule.
+		current, exists, err := r.targetData(ctx, &keychainSecret)
+		if err != nil {
+			return r.fail(ctx, &keychainSecret, "GetFailed", err)
+		}
+		if drifted := !exists || HashSecretData(current) != keychainSecret.Status.ContentHash; drifted {
+			log.Info("target drifted", "kind", keychainSecret.Spec.TargetKind(), "exists", exists)
+		} else if versionChangeRequested(&keychainSecret) {
+			log.Info("version change requested", "version", keychainSecret.Spec.Version)
+		} else {
+			lastUpdate, expiry := keychainSecret.Status.LastUpdate.Time, keychainSecret.Status.ExpiryTime()
+			next := scheduler.NextRefresh(req.NamespacedName, lastUpdate, duration, expiry)
+			if now.Before(next) {
+				return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+			}
+			// Expiring values cannot wait for the window or rate limit.
+			if scheduler.ExpiringSoon(lastUpdate, expiry, now) {
+				log.Info("values expiring", "expiry", expiry)
+			} else if ok, wait := scheduler.Admit(req.NamespacedName, now); !ok {
+				log.Info("rotation deferred", "wait", wait)
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+	}
+
 	identity, err := r.GetOrCreateIdentity(ctx, keychainSecret)
 	if err != nil {
-		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+		return r.fail(ctx, &keychainSecret, "IdentityFailed", err)
 	}
+	ctx = WithIdentity(ctx, identity)
 
-	_, err = r.CreateSecretFromKeychain(ctx, identity, keychainSecret)
+	backend, err := r.backendFor(keychainSecret)
 	if err != nil {
-		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+		return r.fail(ctx, &keychainSecret, "BackendNotFound", err)
 	}
+	ctx, servedBy := WithServedBy(ctx)
 
-	duration, err := time.ParseDuration(keychainSecret.Spec.TTL)
+	fetched, err := FetchSecretData(ctx, backend, keychainSecret)
+	if IsNotFound(err) && keychainSecret.Spec.Generate != nil && keychainSecret.Spec.PinnedVersion() == "" {
+		if fetched, err = r.generate(ctx, backend, &keychainSecret, now, err); err != nil {
+			return r.fail(ctx, &keychainSecret, "GenerateFailed", err)
+		}
+	}
+	setBackendUnavailable(&keychainSecret, err)
+	if errors.Is(err, ErrCircuitOpen) {
+		return r.serveStale(ctx, &keychainSecret, "BackendUnavailable", err, now)
+	}
+	if IsUnavailable(err) {
+		return r.serveStale(ctx, &keychainSecret, "SyncFailed", err, now)
+	}
+	// Anything else is an answer, e.g. that the secret no longer exists, which serving what was last synced would hide.
+	if IsNotFound(err) {
+		return r.fail(ctx, &keychainSecret, "NotFound", err)
+	}
 	if err != nil {
-		panic("TTL was not a valid Duration. This should have been caught during validation!?")
+		return r.fail(ctx, &keychainSecret, "SyncFailed", err)
+	}
+	data, expiry := fetched.Data, fetched.Expiry
+
+	// Certificates are validated before they can replace the current ones. A certificate expires along with its values.
+	var certificate *aqueductv1.CertificateStatus
+	if keychainSecret.Spec.Type == aqueductv1.TypeTLS {
+		if keychainSecret.Spec.TargetKind() != aqueductv1.TargetSecret {
+			return r.fail(ctx, &keychainSecret, "CertificateRejected", errors.New("TLS values can only be written to a Secret"))
+		}
+		tlsData, leaf, err := BuildTLSData(data, now)
+		if err != nil {
+			log.Info("certificate rejected", "reason", err.Error())
+			return r.fail(ctx, &keychainSecret, "CertificateRejected", err)
+		}
+		data, certificate = tlsData, CertificateStatus(leaf)
+		if expiry.IsZero() || leaf.NotAfter.Before(expiry) {
+			expiry = leaf.NotAfter
+		}
+	}
+
+	if keychainSecret.Spec.DryRun {
+		r.changes.synced(req.NamespacedName, now)
+		return r.dryRun(ctx, &keychainSecret, data, now, duration, refreshRequest)
+	}
+
+	// Versions are only known if the backend knows them. The version synced is the one fetched along with the values,
+	// which is also the latest unless an older one is pinned. Versions which cannot be looked up do not hold back the
+	// values; the ones last known are kept instead.
+	if fetched.VersionErr == nil {
+		keychainSecret.Status.CurrentVersion = fetched.Version
+	}
+	available, versionErr := fetched.Version, fetched.VersionErr
+	if versionErr == nil && keychainSecret.Spec.PinnedVersion() != "" {
+		available, versionErr = LatestVersion(ctx, backend, GetKeychainSecretParams{Name: keychainSecret.Spec.Name, Group: keychainSecret.Spec.Group})
+	}
+	if versionErr != nil {
+		log.Info("version unknown", "reason", versionErr.Error())
+	} else {
+		keychainSecret.Status.AvailableVersion = available
+	}
+	setVersionUnknown(&keychainSecret, versionErr)
+
+	hash, err := r.writeTarget(ctx, &keychainSecret, data, fetched.Version)
+	if errors.Is(err, errNotControlled) {
+		// Someone else's object is not taken over; the conflict is resolved by deleting it or renaming the target.
+		r.fail(ctx, &keychainSecret, "Conflict", err)
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, nil
+	}
+	if err != nil {
+		return r.fail(ctx, &keychainSecret, "SyncFailed", err)
+	}
+
+	// Restart consumers only when existing data changed, not when it was first created.
+	if previous := keychainSecret.Status.ContentHash; previous != "" && previous != hash {
+		if err := r.RolloutWorkloads(ctx, keychainSecret, hash); err != nil {
+			log.Error(err, "unable to roll out workloads")
+			return r.fail(ctx, &keychainSecret, "RolloutFailed", err)
+		}
+	}
+
+	next := scheduler.NextRefresh(req.NamespacedName, now, duration, expiry)
+	keychainSecret.Status.Expiry = nil
+	if !expiry.IsZero() {
+		keychainSecret.Status.Expiry = &metav1.Time{Time: expiry}
+	}
+	expiries.set(req.NamespacedName, expiry)
+	keychainSecret.Status.Certificate = certificate
+	keychainSecret.Status.ContentHash = hash
+	keychainSecret.Status.LastUpdate = metav1.NewTime(now)
+	keychainSecret.Status.NextRotation = metav1.NewTime(next)
+	keychainSecret.Status.LastRefreshRequest = refreshRequest
+	keychainSecret.Status.DryRun = nil
+	keychainSecret.Status.ServedBy = servedBy()
+	r.freshen(&keychainSecret)
+	keychainSecret.Status.Reason = ""
+	keychainSecret.Status.Message = "Secret synced from Keychain"
+	keychainSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionTrue, "Synced", keychainSecret.Status.Message)
+	r.setExpiringSoon(&keychainSecret, now)
+	if err := r.Status().Update(ctx, &keychainSecret); err != nil {
+		log.Error(err, "unable to update KeychainSecret status")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+	}
+	r.changes.synced(req.NamespacedName, now)
+
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// fail records a failed sync in the KeychainSecret's status and schedules a retry.
+func (r *KeychainSecretReconciler) fail(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, reason string, err error) (ctrl.Result, error) {
+	keychainSecret.Status.Reason = reason
+	keychainSecret.Status.Message = err.Error()
+	keychainSecret.Status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, reason, err.Error())
+	r.setExpiringSoon(keychainSecret, time.Now())
+	if updateErr := r.Status().Update(ctx, keychainSecret); updateErr != nil {
+		r.Log.Error(updateErr, "unable to update KeychainSecret status", "keychainsecret", keychainSecret.ObjectMeta.Name)
+	}
+	return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, err
+}
+
+// setExpiringSoon sets the ExpiringSoon condition, if the KeychainSecret's values expire.
+func (r *KeychainSecretReconciler) setExpiringSoon(keychainSecret *aqueductv1.KeychainSecret, now time.Time) {
+	expiry := keychainSecret.Status.ExpiryTime()
+	if expiry.IsZero() {
+		if keychainSecret.Status.GetCondition(aqueductv1.ConditionExpiringSoon) != nil {
+			keychainSecret.Status.SetCondition(aqueductv1.ConditionExpiringSoon, corev1.ConditionFalse, "NoExpiry", "Values do not expire")
+		}
+		return
+	}
+
+	switch {
+	case !now.Before(expiry):
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionExpiringSoon, corev1.ConditionTrue, "Expired",
+			fmt.Sprintf("Values expired at %s", expiry.Format(time.RFC3339)))
+	case r.scheduler().ExpiringSoon(keychainSecret.Status.LastUpdate.Time, expiry, now):
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionExpiringSoon, corev1.ConditionTrue, "ExpiringSoon",
+			fmt.Sprintf("Values expire in %s", expiry.Sub(now).Round(time.Second)))
+	default:
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionExpiringSoon, corev1.ConditionFalse, "NotExpiring",
+			fmt.Sprintf("Values expire at %s", expiry.Format(time.RFC3339)))
 	}
-	return ctrl.Result{RequeueAfter: duration}, nil
 }
 
-// CreateSecretFromKeychain creates a Kubernetes Secret corresponding to the KeychainSecret.
-// It will also update an existing Secret if the TTL has expired, for rotation purposes.
-func (r *KeychainSecretReconciler) CreateSecretFromKeychain(ctx context.Context, identitySecret *corev1.Secret, keychainSecret aqueductv1.KeychainSecret) (*corev1.Secret, error) {
-	newSecret := &corev1.Secret{}
+// scheduler returns the configured RotationScheduler, or one which rotates exactly on TTL.
+func (r *KeychainSecretReconciler) scheduler() *RotationScheduler {
+	if r.Scheduler == nil {
+		return &RotationScheduler{}
+	}
+	return r.Scheduler
+}
+
+// backend returns the configured Backend, or a CommandBackend.
+func (r *KeychainSecretReconciler) backend() Backend {
+	if r.Backend == nil {
+		return CommandBackend{}
+	}
+	return r.Backend
+}
+
+// backendFor returns the Backend the KeychainSecret's values are fetched from: the configured one, or the chain of
+// its backends the KeychainSecret selects.
+func (r *KeychainSecretReconciler) backendFor(keychainSecret aqueductv1.KeychainSecret) (Backend, error) {
+	if len(keychainSecret.Spec.Backends) == 0 {
+		return r.backend(), nil
+	}
+	chain, ok := r.backend().(*FallbackBackend)
+	if !ok {
+		return nil, errors.New("the controller is not configured with named backends to select from")
+	}
+	return chain.Select(keychainSecret.Spec.Backends)
+}
 
-	log := r.Log.WithValues("CreateSecretFromKeychain") //, types.NamespacedName{Namespace: n, Name: n})
+// CreateSecretFromKeychain creates a Kubernetes Secret corresponding to the KeychainSecret, or updates the existing
+// Secret with data. When to call it, for rotation purposes, is decided by Reconcile.
+func (r *KeychainSecretReconciler) CreateSecretFromKeychain(ctx context.Context, keychainSecret aqueductv1.KeychainSecret, data map[string][]byte) (*corev1.Secret, error) {
+	log := r.Log.WithValues("keychainsecret", keychainSecret.ObjectMeta.Namespace+"/"+keychainSecret.ObjectMeta.Name)
 
 	// Get current Secret, if any.
+	found := true
 	originalSecret := &corev1.Secret{}
 	if err := r.Get(ctx, client.ObjectKey{Namespace: keychainSecret.ObjectMeta.Namespace, Name: keychainSecret.Spec.Name}, originalSecret); err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			log.Error(err, "unable to fetch Secret")
 			return nil, err
 		}
+		found = false
 	}
 
-	// Get the keychain secret
-	secret, err := GetKeychainSecret(ctx, GetKeychainSecretParams{
-		Group: keychainSecret.Spec.Group,
-		Name:  keychainSecret.Spec.Name,
-	})
-	if err != nil {
-		return nil, err
-	}
-	data := map[string][]byte{keychainSecret.Spec.Name: secret}
-
-	duration, err := time.ParseDuration(keychainSecret.Spec.TTL)
-	if err != nil {
-		panic("TTL was not a valid Duration. This should have been caught during validation!?")
+	// The type of a Secret cannot be changed, so it is replaced instead.
+	if found && originalSecret.Type != NewSecret(keychainSecret, data).Type {
+		log.Info("replacing Secret to change its type", "type", originalSecret.Type)
+		if err := r.Delete(ctx, originalSecret); client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		found = false
 	}
 
 	// Either we need to create the secret, or we need to refresh it.
-	if originalSecret == nil {
-		newSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: keychainSecret.ObjectMeta.Namespace,
-				Name:      keychainSecret.Spec.Name,
-			},
-			Data: data,
-		}
-		err := r.Create(ctx, newSecret)
-		if err != nil {
+	if !found {
+		newSecret := NewSecret(keychainSecret, data)
+		// The Secret is garbage collected along with the KeychainSecret.
+		if err := controllerutil.SetControllerReference(&keychainSecret, newSecret, r.Scheme); err != nil {
 			return nil, err
 		}
-	} else if keychainSecret.Status.LastUpdate.Add(duration).After(time.Now()) {
-		newSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: keychainSecret.ObjectMeta.Namespace,
-				Name:      keychainSecret.Spec.Name,
-			},
-			Data: data,
-		}
-		err := r.Patch(ctx, newSecret, client.MergeFrom(originalSecret))
-		if err != nil {
+		if err := r.Create(ctx, newSecret); err != nil {
 			return nil, err
 		}
+		return newSecret, nil
 	}
 
+	newSecret := originalSecret.DeepCopy()
+	newSecret.Data = data
+	if err := r.Patch(ctx, newSecret, client.MergeFrom(originalSecret)); err != nil {
+		return nil, err
+	}
 	return newSecret, nil
 }
 
-// GetOrCreateIdentity gets or creates a certificate and stores it in a Secret within the controllers namespace.
-// NOTE: This is a hack (maybe) until we have an admission webhook. Since Namespaces are core types and not CRDs,
-// kubebuilder will be of little help in creating such a webhook. See here for more: https://github.com/kubernetes-sigs/controller-runtime/tree/master/examples
-// TODO: Document the pros and cons of using an admission webhook.
-func (r *KeychainSecretReconciler) GetOrCreateIdentity(ctx context.Context, keychainSecret aqueductv1.KeychainSecret) (*corev1.Secret, error) {
-	// We store the identity within the controllers namespace and not the KeychainSecrets namespace. This is so we may
-	// control who may create Secrets from KeychainSecrets.
-	controllerNamespace := os.Getenv("CONTROLLER_NAMESPACE")
-	// Namespace names are unique within a cluster, so this name will be unique as well...
-	certName := keychainSecret.ObjectMeta.Namespace
-
-	// Check if we already have an identity
-	identitySecret := &corev1.Secret{}
-	if err := r.Get(ctx, client.ObjectKey{Namespace: controllerNamespace, Name: certName}, identitySecret); err != nil {
-		// Either we don't have an identity, or there was another error.
-		if client.IgnoreNotFound(err) != nil {
-			// Something else is wrong, give up!
-			return nil, err
-		}
+// BuildSecretData fetches the KeychainSecret's values from backend and returns the data of the Secret it produces.
+// Templates are only rendered once every value has been fetched.
+func BuildSecretData(ctx context.Context, backend Backend, keychainSecret aqueductv1.KeychainSecret) (map[string][]byte, error) {
+	fetched, err := FetchSecretData(ctx, backend, keychainSecret)
+	return fetched.Data, err
+}
 
-		// We need to create an identity
-		cert, err := ProvisionServiceIdentity(ctx, ProvisionServiceIdentityParams{
-			Algorithm: "rsa:4096",
-			Days:      365,
-			Subject:   "'/CN=judkins.house/O=Facebook/C=US'",
-		})
-		if err != nil {
-			return nil, err
-		}
+// SecretData is the data of the Secret a KeychainSecret produces, along with what was fetched with its values.
+type SecretData struct {
+	Data   map[string][]byte
+	Expiry time.Time // The earliest expiry of the values, zero if none of them expire
+	// Version is the version of the KeychainSecret's own Keychain secret, fetched along with its value. It is empty if
+	// unknown, and VersionErr is why, if looking it up failed.
+	Version    string
+	VersionErr error
+}
 
-		data := map[string][]byte{"cert": cert}
+// FetchSecretData is BuildSecretData, but also returns when the data expires and its version.
+func FetchSecretData(ctx context.Context, backend Backend, keychainSecret aqueductv1.KeychainSecret) (SecretData, error) {
+	requestedSecrets := RequestedSecrets(keychainSecret)
+	if err := ValidateRequestedSecrets(requestedSecrets); err != nil {
+		return SecretData{}, err
+	}
 
-		identitySecret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: controllerNamespace,
-				Name:      certName,
-			},
-			Data: data,
-		}
-		err = r.Create(ctx, identitySecret)
+	var fetched SecretData
+	values := map[string][]byte{}
+	for i, requested := range requestedSecrets {
+		secret, err := Fetch(ctx, backend, requested.Params)
 		if err != nil {
-			return nil, err
+			return SecretData{}, err
+		}
+		values[requested.Key] = secret.Value
+		if !secret.Expiry.IsZero() && (fetched.Expiry.IsZero() || secret.Expiry.Before(fetched.Expiry)) {
+			fetched.Expiry = secret.Expiry
+		}
+		// The KeychainSecret's own secret comes first.
+		if i == 0 {
+			fetched.Version, fetched.VersionErr = secret.Version, secret.VersionErr
 		}
 	}
 
-	return identitySecret, nil
+	if keychainSecret.Spec.Template == nil {
+		fetched.Data = values
+		return fetched, nil
+	}
+	data, err := RenderSecretTemplate(keychainSecret.Spec.Template, values)
+	if err != nil {
+		return SecretData{}, err
+	}
+	fetched.Data = data
+	return fetched, nil
+}
+
+// NewSecret returns the Secret produced by the KeychainSecret, with the given data.
+func NewSecret(keychainSecret aqueductv1.KeychainSecret, data map[string][]byte) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: keychainSecret.ObjectMeta.Namespace,
+			Name:      keychainSecret.Spec.Name,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	if keychainSecret.Spec.Type == aqueductv1.TypeTLS {
+		secret.Type = corev1.SecretTypeTLS
+	}
+	return secret
+}
+
+// GetOrCreateIdentity gets or creates a certificate and stores it in a Secret within the controllers namespace.
+// NOTE: This is a hack (maybe) until we have an admission webhook. Since Namespaces are core types and not CRDs,
+// kubebuilder will be of little help in creating such a webhook. See here for more: https://github.com/kubernetes-sigs/controller-runtime/tree/master/examples
+// TODO: Document the pros and cons of using an admission webhook.
+func (r *KeychainSecretReconciler) GetOrCreateIdentity(ctx context.Context, keychainSecret aqueductv1.KeychainSecret) (*corev1.Secret, error) {
+	return getOrCreateIdentity(ctx, r.Client, keychainSecret.ObjectMeta.Namespace)
 }
 
 // SetupWithManager sets up the controller with manager.
 func (r *KeychainSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&aqueductv1.KeychainSecret{}).
-		Complete(r)
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{})
+
+	// Backends which report changes have the KeychainSecrets requesting them synced immediately.
+	changes, err := r.watchBackend(mgr)
+	if err != nil {
+		return err
+	}
+	if changes != nil {
+		builder = builder.Watches(changes, &handler.EnqueueRequestForObject{})
+	}
+
+	// Access is checked again as soon as policies or the namespace labels they select by change.
+	if r.AccessPolicies != nil {
+		toRequests := &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.keychainSecretsFor)}
+		builder = builder.
+			Watches(&source.Kind{Type: &aqueductv1.KeychainAccessPolicy{}}, toRequests).
+			Watches(&source.Kind{Type: &corev1.Namespace{}}, toRequests)
+	}
+	return builder.Complete(r)
+}
+
+// keychainSecretsFor returns requests for the KeychainSecrets whose access a change to a KeychainAccessPolicy or
+// Namespace may affect: those in the Namespace, or every one for policies, which may have selected any namespace.
+func (r *KeychainSecretReconciler) keychainSecretsFor(o handler.MapObject) []reconcile.Request {
+	var opts []client.ListOption
+	if _, ok := o.Object.(*corev1.Namespace); ok {
+		opts = append(opts, client.InNamespace(o.Meta.GetName()))
+	}
+	var keychainSecrets aqueductv1.KeychainSecretList
+	if err := r.List(context.Background(), &keychainSecrets, opts...); err != nil {
+		r.Log.Error(err, "unable to list KeychainSecrets", "object", o.Meta.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(keychainSecrets.Items))
+	for _, keychainSecret := range keychainSecrets.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: keychainSecret.Namespace, Name: keychainSecret.Name}})
+	}
+	return requests
 }
@@ -0,0 +1,395 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+const testControllerNamespace = "keychain-system"
+
+// newFakeClient returns a fake client holding objs along with an identity for the default namespace, and its scheme.
+func newFakeClient(t *testing.T, objs ...runtime.Object) (client.Client, *runtime.Scheme) {
+	os.Setenv("CONTROLLER_NAMESPACE", testControllerNamespace)
+	certPEM, keyPEM, _, _ := newCertificate(t, "default.judkins.house", false, time.Now().Add(365*24*time.Hour), nil, nil)
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testControllerNamespace, Name: IdentitySecretName("default")},
+		Data:       map[string][]byte{IdentityCertKey: append(certPEM, keyPEM...)},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = aqueductv1.AddToScheme(scheme)
+	return fake.NewFakeClientWithScheme(scheme, append(objs, identity)...), scheme
+}
+
+// newReconciler returns a KeychainSecretReconciler fetching from backend, whose fake client holds objs along with an
+// identity for the default namespace.
+func newReconciler(t *testing.T, backend Backend, objs ...runtime.Object) (*KeychainSecretReconciler, client.Client) {
+	c, scheme := newFakeClient(t, objs...)
+	return &KeychainSecretReconciler{Client: c, Log: ctrl.Log, Scheme: scheme, Backend: backend}, c
+}
+
+// reconcile reconciles the KeychainSecret default/name, returning it and its target Secret afterwards.
+func reconcile(t *testing.T, r *KeychainSecretReconciler, c client.Client, name string) (aqueductv1.KeychainSecret, corev1.Secret) {
+	key := types.NamespacedName{Namespace: "default", Name: name}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := c.Get(context.Background(), key, &keychainSecret); err != nil {
+		t.Fatalf("Get KeychainSecret failed: %v", err)
+	}
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: keychainSecret.Spec.Name}, &secret); client.IgnoreNotFound(err) != nil {
+		t.Fatalf("Get Secret failed: %v", err)
+	}
+	return keychainSecret, secret
+}
+
+func TestReconcileRefreshRequested(t *testing.T) {
+	backend := fakeBackend{values: map[string]string{}}
+	r, c := newReconciler(t, backend, &aqueductv1.KeychainSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h"},
+	})
+
+	// Each step changes the backend's value, and optionally the refresh annotation, before reconciling.
+	var testsTable = []struct {
+		name               string
+		value              string
+		refreshRequest     string
+		synced             string
+		lastRefreshRequest string
+	}{
+		{name: "first sync", value: "v1", synced: "v1"},
+		{name: "not due before the TTL", value: "v2", synced: "v1"},
+		{name: "new request syncs", value: "v3", refreshRequest: "2020-01-01T00:00:00Z", synced: "v3",
+			lastRefreshRequest: "2020-01-01T00:00:00Z"},
+		{name: "same request syncs only once", value: "v4", refreshRequest: "2020-01-01T00:00:00Z", synced: "v3",
+			lastRefreshRequest: "2020-01-01T00:00:00Z"},
+		{name: "another request syncs again", value: "v5", refreshRequest: "2020-01-02T00:00:00Z", synced: "v5",
+			lastRefreshRequest: "2020-01-02T00:00:00Z"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.values["DB_PASSWORD"] = tt.value
+			if tt.refreshRequest != "" {
+				var keychainSecret aqueductv1.KeychainSecret
+				if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &keychainSecret); err != nil {
+					t.Fatalf("Get KeychainSecret failed: %v", err)
+				}
+				keychainSecret.Annotations = map[string]string{aqueductv1.RefreshRequestedAnnotation: tt.refreshRequest}
+				if err := c.Update(context.Background(), &keychainSecret); err != nil {
+					t.Fatalf("Update KeychainSecret failed: %v", err)
+				}
+			}
+
+			keychainSecret, secret := reconcile(t, r, c, "db")
+			if synced := string(secret.Data["DB_PASSWORD"]); synced != tt.synced {
+				t.Errorf("Synced %q, expected %q", synced, tt.synced)
+			}
+			if keychainSecret.Status.LastRefreshRequest != tt.lastRefreshRequest {
+				t.Errorf("LastRefreshRequest %q, expected %q", keychainSecret.Status.LastRefreshRequest, tt.lastRefreshRequest)
+			}
+		})
+	}
+}
+
+func TestReconcileAccessDenied(t *testing.T) {
+	policy := &aqueductv1.KeychainAccessPolicy{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: aqueductv1.KeychainAccessPolicySpec{
+		Namespaces: []string{"default"},
+		Rules:      []aqueductv1.KeychainAccessRule{{Names: []string{"DB_*"}}},
+	}}
+	r, c := newReconciler(t, fakeBackend{values: map[string]string{"DB_PASSWORD": "hunter2"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&aqueductv1.KeychainSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+			Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h"},
+		})
+	r.AccessPolicies = &AccessPolicyChecker{Reader: c, DefaultDeny: true}
+
+	// Each step allows or denies access before reconciling. Only the first sync is due, the others take effect
+	// regardless of schedule.
+	var testsTable = []struct {
+		name    string
+		allowed bool
+		synced  string
+		reason  string
+	}{
+		{name: "allowed", allowed: true, synced: "hunter2", reason: "Synced"},
+		{name: "denied deletes the Secret", allowed: false, synced: "", reason: "AccessDenied"},
+		{name: "allowed again restores it", allowed: true, synced: "hunter2", reason: "Synced"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Delete(context.Background(), policy.DeepCopy()); client.IgnoreNotFound(err) != nil {
+				t.Fatalf("Delete KeychainAccessPolicy failed: %v", err)
+			}
+			if tt.allowed {
+				if err := c.Create(context.Background(), policy.DeepCopy()); err != nil {
+					t.Fatalf("Create KeychainAccessPolicy failed: %v", err)
+				}
+			}
+
+			keychainSecret, secret := reconcile(t, r, c, "db")
+			if synced := string(secret.Data["DB_PASSWORD"]); synced != tt.synced {
+				t.Errorf("Synced %q, expected %q", synced, tt.synced)
+			}
+			if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != tt.reason {
+				t.Errorf("Ready %+v, expected reason %s", ready, tt.reason)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigMapConflict(t *testing.T) {
+	// The fake client leaves ResourceVersion empty for initial objects, which would look like a ConfigMap to create.
+	unowned := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "CA_BUNDLE", ResourceVersion: "1"},
+		Data:       map[string]string{"CA_BUNDLE": "someone else's"},
+	}
+	r, c := newReconciler(t, fakeBackend{values: map[string]string{"CA_BUNDLE": "bundle"}},
+		unowned,
+		&aqueductv1.KeychainSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ca"},
+			Spec: aqueductv1.KeychainSecretSpec{Name: "CA_BUNDLE", TTL: "24h",
+				Target: &aqueductv1.TargetSpec{Kind: aqueductv1.TargetConfigMap}},
+		})
+
+	keychainSecret, _ := reconcile(t, r, c, "ca")
+	if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "Conflict" {
+		t.Errorf("Ready %+v, expected a Conflict", ready)
+	}
+	var configMap corev1.ConfigMap
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "CA_BUNDLE"}, &configMap); err != nil {
+		t.Fatalf("Get ConfigMap failed: %v", err)
+	}
+	if configMap.Data["CA_BUNDLE"] != "someone else's" || len(configMap.OwnerReferences) != 0 {
+		t.Errorf("ConfigMap %+v was taken over", configMap)
+	}
+}
+
+func TestReconcileFetchFailed(t *testing.T) {
+	var testsTable = []struct {
+		name   string
+		err    error
+		stale  bool
+		reason string
+	}{
+		{name: "unavailable backends serve stale values", err: fmt.Errorf("GET /v1/secrets: 503 Service Unavailable: %w", ErrUnavailable),
+			stale: true, reason: "Synced"},
+		{name: "timeouts serve stale values", err: context.DeadlineExceeded, stale: true, reason: "Synced"},
+		{name: "deleted secrets fail", err: fmt.Errorf("DB_PASSWORD: %w", ErrNotFound), reason: "NotFound"},
+		{name: "other errors fail", err: errors.New("GET /v1/secrets: 403 Forbidden"), reason: "SyncFailed"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			r, c := newReconciler(t, fakeBackend{values: map[string]string{"DB_PASSWORD": "hunter2"}},
+				&aqueductv1.KeychainSecret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+					Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h"},
+				})
+			r.StaleGracePeriod = time.Hour
+			reconcile(t, r, c, "db")
+
+			// The next sync fails.
+			r.Backend = fakeBackend{err: tt.err}
+			var keychainSecret aqueductv1.KeychainSecret
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &keychainSecret); err != nil {
+				t.Fatalf("Get KeychainSecret failed: %v", err)
+			}
+			keychainSecret.Annotations = map[string]string{aqueductv1.RefreshRequestedAnnotation: time.Unix(1, 0).Format(time.RFC3339)}
+			if err := c.Update(context.Background(), &keychainSecret); err != nil {
+				t.Fatalf("Update KeychainSecret failed: %v", err)
+			}
+			// Stale values are retried with their own backoff, other failures return their error.
+			r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "db"}})
+
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &keychainSecret); err != nil {
+				t.Fatalf("Get KeychainSecret failed: %v", err)
+			}
+			var secret corev1.Secret
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "DB_PASSWORD"}, &secret); err != nil {
+				t.Fatalf("Get Secret failed: %v", err)
+			}
+			if stale := keychainSecret.Status.GetCondition(aqueductv1.ConditionStale); (stale != nil && stale.Status == corev1.ConditionTrue) != tt.stale {
+				t.Errorf("Stale %+v, expected %v", stale, tt.stale)
+			}
+			if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != tt.reason {
+				t.Errorf("Ready %+v, expected reason %s", ready, tt.reason)
+			}
+			if synced := string(secret.Data["DB_PASSWORD"]); synced != "hunter2" {
+				t.Errorf("Serving %q, expected the last synced value", synced)
+			}
+		})
+	}
+}
+
+// racingBackend is a FileBackend in which someone else stores a secret right before every Put.
+type racingBackend struct {
+	*FileBackend
+	theirs []byte
+}
+
+func (b racingBackend) Put(ctx context.Context, params PutKeychainSecretParams, value []byte) (string, error) {
+	if _, err := b.FileBackend.Put(ctx, PutKeychainSecretParams{Name: params.Name, Group: params.Group}, b.theirs); err != nil {
+		return "", err
+	}
+	return b.FileBackend.Put(ctx, params, value)
+}
+
+func TestReconcileGenerateRace(t *testing.T) {
+	fileBackend, cleanup := newFileBackend(t, map[string]string{"OTHER": "other"})
+	defer cleanup()
+	r, c := newReconciler(t, racingBackend{FileBackend: fileBackend, theirs: []byte("theirs")},
+		&aqueductv1.KeychainSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+			Spec: aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h",
+				Generate: &aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword}},
+		})
+
+	// The secret generated concurrently is synced, rather than overwritten.
+	keychainSecret, secret := reconcile(t, r, c, "db")
+	if synced := string(secret.Data["DB_PASSWORD"]); synced != "theirs" {
+		t.Errorf("Synced %q, expected the concurrently generated value", synced)
+	}
+	if value, err := fileBackend.Get(context.Background(), GetKeychainSecretParams{Name: "DB_PASSWORD"}); err != nil || string(value) != "theirs" {
+		t.Errorf("Keychain holds %q (%v), expected the concurrently generated value", value, err)
+	}
+	if keychainSecret.Status.Generated != nil {
+		t.Errorf("Generated %v, expected nothing to be generated", keychainSecret.Status.Generated)
+	}
+}
+
+// unreachableBackend is a fakeBackend which becomes unavailable after its first Get.
+type unreachableBackend struct {
+	fakeBackend
+	gets *int
+}
+
+func (b unreachableBackend) Get(ctx context.Context, params GetKeychainSecretParams) ([]byte, error) {
+	if *b.gets++; *b.gets > 1 {
+		return nil, fmt.Errorf("%s: %w", params.Name, ErrUnavailable)
+	}
+	return b.fakeBackend.Get(ctx, params)
+}
+
+func TestReconcileGenerateUnavailable(t *testing.T) {
+	r, c := newReconciler(t, unreachableBackend{fakeBackend: fakeBackend{values: map[string]string{}}, gets: new(int)},
+		&aqueductv1.KeychainSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+			Spec: aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h",
+				Generate: &aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword}},
+		})
+
+	// Failing to check whether the secret exists is reported, rather than the secret being missing.
+	key := types.NamespacedName{Namespace: "default", Name: "db"}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); !IsUnavailable(err) {
+		t.Errorf("Reconcile returned %v, expected the backend to be unavailable", err)
+	}
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := c.Get(context.Background(), key, &keychainSecret); err != nil {
+		t.Fatalf("Get KeychainSecret failed: %v", err)
+	}
+	if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "GenerateFailed" ||
+		!strings.Contains(ready.Message, ErrUnavailable.Error()) {
+		t.Errorf("Ready %+v, expected generation to fail as the backend is unavailable", ready)
+	}
+	if keychainSecret.Status.Generated != nil {
+		t.Errorf("Generated %v, expected nothing to be generated", keychainSecret.Status.Generated)
+	}
+}
+
+func TestReconcileVersionUnknown(t *testing.T) {
+	values := fakeBackend{values: map[string]string{}}
+	r, c := newReconciler(t, nil, &aqueductv1.KeychainSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec:       aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h"},
+	})
+
+	// Each step changes the backend's value and versions, and requests a refresh before reconciling.
+	var testsTable = []struct {
+		name           string
+		value          string
+		latest         func() (string, error)
+		current        string
+		versionUnknown corev1.ConditionStatus
+	}{
+		{name: "version known", value: "v1", latest: versions("1", "1"), current: "1"},
+		{name: "version lookup failed", value: "v2", latest: versions(), current: "1", versionUnknown: corev1.ConditionTrue},
+		{name: "version changed while fetching", value: "v3", latest: versions("2", "3"), current: "1",
+			versionUnknown: corev1.ConditionTrue},
+		{name: "version known again", value: "v4", latest: versions("4", "4"), current: "4",
+			versionUnknown: corev1.ConditionFalse},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			values.values["DB_PASSWORD"] = tt.value
+			r.Backend = versionedBackend{values, tt.latest}
+			var keychainSecret aqueductv1.KeychainSecret
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &keychainSecret); err != nil {
+				t.Fatalf("Get KeychainSecret failed: %v", err)
+			}
+			keychainSecret.Annotations = map[string]string{aqueductv1.RefreshRequestedAnnotation: tt.name}
+			if err := c.Update(context.Background(), &keychainSecret); err != nil {
+				t.Fatalf("Update KeychainSecret failed: %v", err)
+			}
+
+			// Values are synced regardless of their version.
+			keychainSecret, secret := reconcile(t, r, c, "db")
+			if synced := string(secret.Data["DB_PASSWORD"]); synced != tt.value {
+				t.Errorf("Synced %q, expected %q", synced, tt.value)
+			}
+			if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "Synced" {
+				t.Errorf("Ready %v, expected Synced", ready)
+			}
+			if current := keychainSecret.Status.CurrentVersion; current != tt.current {
+				t.Errorf("CurrentVersion %q, expected %q", current, tt.current)
+			}
+			var versionUnknown corev1.ConditionStatus
+			if condition := keychainSecret.Status.GetCondition(aqueductv1.ConditionVersionUnknown); condition != nil {
+				versionUnknown = condition.Status
+			}
+			if versionUnknown != tt.versionUnknown {
+				t.Errorf("VersionUnknown %q, expected %q", versionUnknown, tt.versionUnknown)
+			}
+		})
+	}
+}
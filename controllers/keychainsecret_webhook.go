@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+const (
+	// KeychainSecretValidatorPath is the path the KeychainSecretValidator is served on.
+	KeychainSecretValidatorPath = "/validate-aqueduct-k8s-facebook-com-v1-keychainsecret"
+	// ClusterKeychainSecretValidatorPath is the path the ClusterKeychainSecretValidator is served on.
+	ClusterKeychainSecretValidatorPath = "/validate-aqueduct-k8s-facebook-com-v1-clusterkeychainsecret"
+	// KeychainGroupSecretValidatorPath is the path the KeychainGroupSecretValidator is served on.
+	KeychainGroupSecretValidatorPath = "/validate-aqueduct-k8s-facebook-com-v1-keychaingroupsecret"
+	// KeychainPublicationValidatorPath is the path the KeychainPublicationValidator is served on.
+	KeychainPublicationValidatorPath = "/validate-aqueduct-k8s-facebook-com-v1-keychainpublication"
+)
+
+// The reconcilers check KeychainAccessPolicies again on every sync, since policies may change at any time. These
+// webhooks reject requests which are denied already, so they fail early and visibly.
+
+// +kubebuilder:webhook:path=/validate-aqueduct-k8s-facebook-com-v1-keychainsecret,mutating=false,failurePolicy=fail,groups=aqueduct.k8s.facebook.com,resources=keychainsecrets,verbs=create;update,versions=v1,name=vkeychainsecret.aqueduct.k8s.facebook.com
+// +kubebuilder:webhook:path=/validate-aqueduct-k8s-facebook-com-v1-clusterkeychainsecret,mutating=false,failurePolicy=fail,groups=aqueduct.k8s.facebook.com,resources=clusterkeychainsecrets,verbs=create;update,versions=v1,name=vclusterkeychainsecret.aqueduct.k8s.facebook.com
+// +kubebuilder:webhook:path=/validate-aqueduct-k8s-facebook-com-v1-keychaingroupsecret,mutating=false,failurePolicy=fail,groups=aqueduct.k8s.facebook.com,resources=keychaingroupsecrets,verbs=create;update,versions=v1,name=vkeychaingroupsecret.aqueduct.k8s.facebook.com
+// +kubebuilder:webhook:path=/validate-aqueduct-k8s-facebook-com-v1-keychainpublication,mutating=false,failurePolicy=fail,groups=aqueduct.k8s.facebook.com,resources=keychainpublications,verbs=create;update,versions=v1,name=vkeychainpublication.aqueduct.k8s.facebook.com
+
+// KeychainSecretValidator is a validating admission webhook which rejects KeychainSecrets requesting, or generating,
+// secrets their namespace's KeychainAccessPolicies do not allow.
+type KeychainSecretValidator struct {
+	AccessPolicies *AccessPolicyChecker
+	decoder        *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (v *KeychainSecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var keychainSecret aqueductv1.KeychainSecret
+	if err := v.decoder.Decode(req, &keychainSecret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	requested := RequestedSecrets(keychainSecret)
+	if err := ValidateRequestedSecrets(requested); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return accessResponse(allowedSpec(ctx, v.AccessPolicies, req.Namespace, keychainSecret.Spec, requested))
+}
+
+// allowedSpec reports whether namespace may request every one of requested, and store the value the spec generates,
+// if any.
+func allowedSpec(ctx context.Context, policies *AccessPolicyChecker, namespace string, spec aqueductv1.KeychainSecretSpec, requested []RequestedSecret) (bool, string, error) {
+	if spec.Generate != nil && !spec.DryRun {
+		allowed, reason, err := policies.AllowedPut(ctx, namespace, spec.Group, spec.Name)
+		if err != nil || !allowed {
+			return allowed, reason, err
+		}
+	}
+	return policies.AllowedSecrets(ctx, namespace, requested)
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *KeychainSecretValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// ClusterKeychainSecretValidator is a validating admission webhook which rejects ClusterKeychainSecrets whose template
+// requests secrets the KeychainAccessPolicies of any namespace they currently select do not allow.
+type ClusterKeychainSecretValidator struct {
+	AccessPolicies *AccessPolicyChecker
+	decoder        *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (v *ClusterKeychainSecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var clusterKeychainSecret aqueductv1.ClusterKeychainSecret
+	if err := v.decoder.Decode(req, &clusterKeychainSecret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var namespaceList corev1.NamespaceList
+	if err := v.AccessPolicies.List(ctx, &namespaceList); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	selected, err := SelectNamespaces(clusterKeychainSecret.Spec.NamespaceSelector, namespaceList.Items)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	namespaces := make([]string, 0, len(selected))
+	for namespace := range selected {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	requested := RequestedSecrets(aqueductv1.KeychainSecret{Spec: clusterKeychainSecret.Spec.Template})
+	if err := ValidateRequestedSecrets(requested); err != nil {
+		return admission.Denied(err.Error())
+	}
+	for _, namespace := range namespaces {
+		allowed, reason, err := allowedSpec(ctx, v.AccessPolicies, namespace, clusterKeychainSecret.Spec.Template, requested)
+		if err != nil || !allowed {
+			return accessResponse(allowed, reason, err)
+		}
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *ClusterKeychainSecretValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// KeychainGroupSecretValidator is a validating admission webhook which rejects KeychainGroupSecrets for groups their
+// namespace's KeychainAccessPolicies allow no secrets of.
+type KeychainGroupSecretValidator struct {
+	AccessPolicies *AccessPolicyChecker
+	decoder        *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (v *KeychainGroupSecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var groupSecret aqueductv1.KeychainGroupSecret
+	if err := v.decoder.Decode(req, &groupSecret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	return accessResponse(v.AccessPolicies.AllowedGroup(ctx, req.Namespace, groupSecret.Spec.Group))
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *KeychainGroupSecretValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// KeychainPublicationValidator is a validating admission webhook which rejects KeychainPublications publishing secrets
+// their namespace's KeychainAccessPolicies do not allow storing.
+type KeychainPublicationValidator struct {
+	AccessPolicies *AccessPolicyChecker
+	decoder        *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (v *KeychainPublicationValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var publication aqueductv1.KeychainPublication
+	if err := v.decoder.Decode(req, &publication); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	for _, key := range publication.Spec.Keys {
+		allowed, reason, err := v.AccessPolicies.AllowedPut(ctx, req.Namespace, publication.Spec.Group, key.Name)
+		if err != nil || !allowed {
+			return accessResponse(allowed, reason, err)
+		}
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *KeychainPublicationValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// accessResponse returns the admission response for the result of an AccessPolicyChecker.
+func accessResponse(allowed bool, reason string, err error) admission.Response {
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !allowed {
+		return admission.Denied(reason)
+	}
+	return admission.Allowed("")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+// validator is implemented by the validating admission webhooks.
+type validator interface {
+	admission.Handler
+	admission.DecoderInjector
+}
+
+func TestValidators(t *testing.T) {
+	// Only the payments namespace is covered by a policy, and may only request PAYMENTS secrets, and store API_KEY.
+	c, scheme := newFakeClient(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+		&aqueductv1.KeychainAccessPolicy{ObjectMeta: metav1.ObjectMeta{Name: "payments"}, Spec: aqueductv1.KeychainAccessPolicySpec{
+			Namespaces: []string{"payments"},
+			Rules: []aqueductv1.KeychainAccessRule{
+				{Groups: []string{"PAYMENTS"}},
+				{Groups: []string{"PAYMENTS"}, Names: []string{"API_KEY"}, Verbs: []aqueductv1.AccessVerb{aqueductv1.AccessPut}},
+			},
+		}})
+	policies := &AccessPolicyChecker{Reader: c, DefaultDeny: true}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatalf("NewDecoder failed: %v", err)
+	}
+
+	clusterKeychainSecret := func(team string) runtime.Object {
+		return &aqueductv1.ClusterKeychainSecret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "ClusterKeychainSecret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "api"},
+			Spec: aqueductv1.ClusterKeychainSecretSpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": team}},
+				Template:          aqueductv1.KeychainSecretSpec{Name: "API_KEY", Group: "PAYMENTS"},
+			},
+		}
+	}
+	groupSecret := func(group string) runtime.Object {
+		return &aqueductv1.KeychainGroupSecret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainGroupSecret"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "group"},
+			Spec:       aqueductv1.KeychainGroupSecretSpec{Group: group},
+		}
+	}
+	publication := func(group, name string) runtime.Object {
+		return &aqueductv1.KeychainPublication{
+			TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainPublication"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "publication"},
+			Spec: aqueductv1.KeychainPublicationSpec{SecretName: "api", Group: group,
+				Keys: []aqueductv1.PublicationKey{{Key: "key", Name: name}}},
+		}
+	}
+	generated := func(name string) runtime.Object {
+		return &aqueductv1.KeychainSecret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainSecret"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "generated"},
+			Spec: aqueductv1.KeychainSecretSpec{Name: name, Group: "PAYMENTS",
+				Generate: &aqueductv1.GenerateSpec{Type: aqueductv1.GeneratePassword}},
+		}
+	}
+
+	var testsTable = []struct {
+		name      string
+		validator validator
+		namespace string
+		object    runtime.Object
+		allowed   bool
+	}{
+		{name: "KeychainSecret allowed", validator: &KeychainSecretValidator{AccessPolicies: policies}, namespace: "payments",
+			object: &aqueductv1.KeychainSecret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainSecret"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "api"},
+				Spec:       aqueductv1.KeychainSecretSpec{Name: "API_KEY", Group: "PAYMENTS"}},
+			allowed: true},
+		{name: "KeychainSecret denied", validator: &KeychainSecretValidator{AccessPolicies: policies}, namespace: "web",
+			object: &aqueductv1.KeychainSecret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainSecret"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "api"},
+				Spec:       aqueductv1.KeychainSecretSpec{Name: "API_KEY", Group: "PAYMENTS"}},
+			allowed: false},
+		{name: "KeychainSecret with an invalid group", validator: &KeychainSecretValidator{AccessPolicies: policies}, namespace: "payments",
+			object: &aqueductv1.KeychainSecret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: aqueductv1.GroupVersion.String(), Kind: "KeychainSecret"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "api"},
+				Spec: aqueductv1.KeychainSecretSpec{Name: "API_KEY", Group: "PAYMENTS", Template: &aqueductv1.SecretTemplate{
+					Sources: []aqueductv1.KeychainSource{{Key: "admin", Name: "ROOT", Group: "../ADMIN"}}}}},
+			allowed: false},
+		{name: "ClusterKeychainSecret allowed in every selected namespace",
+			validator: &ClusterKeychainSecretValidator{AccessPolicies: policies}, object: clusterKeychainSecret("payments"), allowed: true},
+		{name: "ClusterKeychainSecret denied in a selected namespace",
+			validator: &ClusterKeychainSecretValidator{AccessPolicies: policies}, object: clusterKeychainSecret("web"), allowed: false},
+		{name: "KeychainGroupSecret allowed", validator: &KeychainGroupSecretValidator{AccessPolicies: policies},
+			namespace: "payments", object: groupSecret("PAYMENTS"), allowed: true},
+		{name: "KeychainGroupSecret denied", validator: &KeychainGroupSecretValidator{AccessPolicies: policies},
+			namespace: "payments", object: groupSecret("WEB"), allowed: false},
+		{name: "generating KeychainSecret allowed", validator: &KeychainSecretValidator{AccessPolicies: policies},
+			namespace: "payments", object: generated("API_KEY"), allowed: true},
+		{name: "generating KeychainSecret denied by a rule only allowing get",
+			validator: &KeychainSecretValidator{AccessPolicies: policies}, namespace: "payments", object: generated("DB_PASSWORD"), allowed: false},
+		{name: "KeychainPublication allowed", validator: &KeychainPublicationValidator{AccessPolicies: policies},
+			namespace: "payments", object: publication("PAYMENTS", "API_KEY"), allowed: true},
+		{name: "KeychainPublication denied", validator: &KeychainPublicationValidator{AccessPolicies: policies},
+			namespace: "payments", object: publication("WEB", "API_KEY"), allowed: false},
+		{name: "KeychainPublication denied by a rule only allowing get", validator: &KeychainPublicationValidator{AccessPolicies: policies},
+			namespace: "payments", object: publication("PAYMENTS", "DB_PASSWORD"), allowed: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.object)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if err := tt.validator.InjectDecoder(decoder); err != nil {
+				t.Fatalf("InjectDecoder failed: %v", err)
+			}
+			response := tt.validator.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Namespace: tt.namespace, Operation: admissionv1beta1.Create, Object: runtime.RawExtension{Raw: raw}}})
+			if response.Allowed != tt.allowed {
+				t.Errorf("Allowed observed %v (%+v), expected %v", response.Allowed, response.Result, tt.allowed)
+			}
+		})
+	}
+}
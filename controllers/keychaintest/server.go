@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keychaintest provides a fake Keychain server, speaking the protocol of controllers.HTTPBackend, for tests.
+package keychaintest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/davidewatson/keychain/controllers"
+)
+
+// Server is a fake Keychain server holding secrets in memory. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	secrets  map[string][]controllers.HTTPSecret // Versions of each secret by group/name, oldest first
+	requests int
+	clients  []string // Common names of the client certificates seen, in request order
+}
+
+// NewServer starts and returns a new Server over plain HTTP. The caller should call Close when finished.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts and returns a new Server over HTTPS. If clientCAs is not nil, clients must present a
+// certificate signed by one of them. Server.Client returns a client trusting the server.
+func NewTLSServer(clientCAs *x509.CertPool) *Server {
+	s := newServer()
+	s.Server = httptest.NewUnstartedServer(s)
+	if clientCAs != nil {
+		s.Server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	}
+	s.Server.StartTLS()
+	return s
+}
+
+func newServer() *Server {
+	return &Server{secrets: map[string][]controllers.HTTPSecret{}}
+}
+
+// Put adds a version of a secret, which becomes its latest version, as an HTTP PUT request does. Secrets outside of any group use the group "".
+func (s *Server) Put(group, name string, secret controllers.HTTPSecret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := secretKey(group, name)
+	s.secrets[key] = append(s.secrets[key], secret)
+}
+
+// Delete removes every version of a secret.
+func (s *Server) Delete(group, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, secretKey(group, name))
+}
+
+// Requests returns how many requests the server has handled.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Clients returns the common names of the client certificates presented, one per request which presented one.
+func (s *Server) Clients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.clients...)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		s.clients = append(s.clients, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	isSecret := len(segments) == 4 && segments[0] == "v1" && segments[1] == "secrets"
+	switch {
+	case isSecret && r.Method == http.MethodPut:
+		s.putSecret(w, r, fromSegment(segments[2]), segments[3])
+	case r.Method != http.MethodGet:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case isSecret:
+		s.getSecret(w, fromSegment(segments[2]), segments[3], r.URL.Query().Get("version"))
+	case len(segments) == 4 && segments[0] == "v1" && segments[1] == "groups" && segments[3] == "secrets":
+		s.listSecrets(w, fromSegment(segments[2]))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) getSecret(w http.ResponseWriter, group, name, version string) {
+	versions := s.secrets[secretKey(group, name)]
+	if len(versions) == 0 {
+		http.Error(w, "secret not found", http.StatusNotFound)
+		return
+	}
+	if version == "" {
+		writeJSON(w, versions[len(versions)-1])
+		return
+	}
+	for _, secret := range versions {
+		if secret.Version == version {
+			writeJSON(w, secret)
+			return
+		}
+	}
+	http.Error(w, "version not found", http.StatusNotFound)
+}
+
+// putSecret stores a new version of a secret, numbering it unless the request names it. Requests with
+// "If-None-Match: *" only create secrets, and requests with If-Match only replace the version it names.
+func (s *Server) putSecret(w http.ResponseWriter, r *http.Request, group, name string) {
+	var secret controllers.HTTPSecret
+	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := secretKey(group, name)
+	versions := s.secrets[key]
+	if r.Header.Get("If-None-Match") == "*" && len(versions) > 0 {
+		http.Error(w, "secret already exists", http.StatusPreconditionFailed)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" &&
+		(len(versions) == 0 || strconv.Quote(versions[len(versions)-1].Version) != match) {
+		http.Error(w, "secret changed", http.StatusPreconditionFailed)
+		return
+	}
+	if secret.Version == "" {
+		secret.Version = strconv.Itoa(len(s.secrets[key]) + 1)
+	}
+	s.secrets[key] = append(s.secrets[key], secret)
+	writeJSON(w, secret)
+}
+
+func (s *Server) listSecrets(w http.ResponseWriter, group string) {
+	list := controllers.HTTPSecretList{Names: []string{}}
+	prefix := group + "/"
+	for key := range s.secrets {
+		if strings.HasPrefix(key, prefix) {
+			list.Names = append(list.Names, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.Strings(list.Names)
+	writeJSON(w, list)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func secretKey(group, name string) string {
+	return group + "/" + name
+}
+
+// fromSegment reverses the mapping of groups to path segments, where "-" means no group.
+func fromSegment(segment string) string {
+	if segment == "-" {
+		return ""
+	}
+	return segment
+}
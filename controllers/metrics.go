@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	expiryTimestampDesc = prometheus.NewDesc("keychain_secret_expiry_timestamp_seconds",
+		"When the values of a KeychainSecret expire, in seconds since the epoch.", []string{"namespace", "name"}, nil)
+	timeToExpiryDesc = prometheus.NewDesc("keychain_secret_time_to_expiry_seconds",
+		"How long until the values of a KeychainSecret expire. Negative once they have expired.", []string{"namespace", "name"}, nil)
+	staleAgeDesc = prometheus.NewDesc("keychain_secret_stale_age_seconds",
+		"How long ago the values of a KeychainSecret, which the backend failed to refresh for longer than the grace period, were synced.",
+		[]string{"namespace", "name"}, nil)
+
+	// circuitState is the state of the CircuitBreaker of each backend.
+	circuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "keychain_backend_circuit_state",
+		Help: "State of the circuit breaker of a backend: 0 when closed, 1 when half-open and 2 when open.",
+	}, []string{"backend"})
+
+	// expiries tracks the expiry of every KeychainSecret whose values expire.
+	expiries = &expiryCollector{expiries: map[types.NamespacedName]time.Time{}}
+	// stales tracks when the values of every KeychainSecret stale past the grace period were synced.
+	stales = &staleCollector{synced: map[types.NamespacedName]time.Time{}}
+)
+
+func init() {
+	metrics.Registry.MustRegister(expiries, stales, circuitState)
+}
+
+// expiryCollector is a prometheus.Collector reporting KeychainSecret expiries. The time to expiry is computed when
+// scraped, so it is current even between reconciles.
+type expiryCollector struct {
+	mu       sync.Mutex
+	expiries map[types.NamespacedName]time.Time
+}
+
+// set records the expiry of a KeychainSecret, or forgets it if expiry is zero.
+func (c *expiryCollector) set(key types.NamespacedName, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if expiry.IsZero() {
+		delete(c.expiries, key)
+		return
+	}
+	c.expiries[key] = expiry
+}
+
+// Describe implements prometheus.Collector.
+func (c *expiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- expiryTimestampDesc
+	ch <- timeToExpiryDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *expiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, expiry := range c.expiries {
+		ch <- prometheus.MustNewConstMetric(expiryTimestampDesc, prometheus.GaugeValue,
+			float64(expiry.UnixNano())/float64(time.Second), key.Namespace, key.Name)
+		ch <- prometheus.MustNewConstMetric(timeToExpiryDesc, prometheus.GaugeValue,
+			expiry.Sub(now).Seconds(), key.Namespace, key.Name)
+	}
+}
+
+// staleCollector is a prometheus.Collector reporting the age of KeychainSecret values served stale past the grace
+// period. Ages are computed when scraped, like times to expiry.
+type staleCollector struct {
+	mu     sync.Mutex
+	synced map[types.NamespacedName]time.Time
+}
+
+// set records when the stale values of a KeychainSecret were synced, or forgets them if synced is zero.
+func (c *staleCollector) set(key types.NamespacedName, synced time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if synced.IsZero() {
+		delete(c.synced, key)
+		return
+	}
+	c.synced[key] = synced
+}
+
+// Describe implements prometheus.Collector.
+func (c *staleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- staleAgeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *staleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, synced := range c.synced {
+		ch <- prometheus.MustNewConstMetric(staleAgeDesc, prometheus.GaugeValue, now.Sub(synced).Seconds(), key.Namespace, key.Name)
+	}
+}
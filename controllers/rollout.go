@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+const (
+	// SecretHashAnnotation is patched into the pod template of rollout targets. Changing it causes their controllers
+	// to perform a rolling restart.
+	SecretHashAnnotation = "keychain.aqueduct/secret-hash"
+)
+
+// HashSecretData returns a stable SHA-256 hash of a Secret's data.
+func HashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		// Length prefixes keep {"a": "bc"} and {"ab": "c"} distinct.
+		fmt.Fprintf(h, "%d:%s%d:", len(key), key, len(data[key]))
+		h.Write(data[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+
+// RolloutWorkloads patches hash into the pod template of each workload selected by the KeychainSecret's rollout spec,
+// triggering a rolling restart of workloads whose template does not already carry it.
+func (r *KeychainSecretReconciler) RolloutWorkloads(ctx context.Context, keychainSecret aqueductv1.KeychainSecret, hash string) error {
+	rollout := keychainSecret.Spec.Rollout
+	if rollout == nil {
+		return nil
+	}
+	log := r.Log.WithValues("RolloutWorkloads", keychainSecret.ObjectMeta.Namespace+"/"+keychainSecret.ObjectMeta.Name)
+	namespace := keychainSecret.ObjectMeta.Namespace
+
+	var workloads []workload
+	for _, target := range rollout.Targets {
+		w, err := newWorkload(target.Kind)
+		if err != nil {
+			return err
+		}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: target.Name}, w.object); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			log.Info("rollout target not found", "kind", target.Kind, "name", target.Name)
+			continue
+		}
+		workloads = append(workloads, w)
+	}
+
+	if rollout.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(rollout.Selector)
+		if err != nil {
+			return err
+		}
+		selected, err := r.listWorkloads(ctx, namespace, selector)
+		if err != nil {
+			return err
+		}
+		workloads = append(workloads, selected...)
+	}
+
+	for _, w := range workloads {
+		if w.template.Annotations[SecretHashAnnotation] == hash {
+			continue
+		}
+		original := w.object.DeepCopyObject()
+		if w.template.Annotations == nil {
+			w.template.Annotations = map[string]string{}
+		}
+		w.template.Annotations[SecretHashAnnotation] = hash
+		if err := r.Patch(ctx, w.object, client.MergeFrom(original)); err != nil {
+			return err
+		}
+		log.Info("restarting workload", "kind", w.kind, "name", w.meta.Name)
+	}
+
+	return nil
+}
+
+// workload is a Deployment, StatefulSet or DaemonSet along with a pointer into its pod template.
+type workload struct {
+	kind     string
+	object   runtime.Object
+	meta     *metav1.ObjectMeta
+	template *corev1.PodTemplateSpec
+}
+
+// newWorkload returns an empty workload of the given kind, suitable for passing to Get.
+func newWorkload(kind string) (workload, error) {
+	switch kind {
+	case "Deployment":
+		d := &appsv1.Deployment{}
+		return workload{kind: kind, object: d, meta: &d.ObjectMeta, template: &d.Spec.Template}, nil
+	case "StatefulSet":
+		s := &appsv1.StatefulSet{}
+		return workload{kind: kind, object: s, meta: &s.ObjectMeta, template: &s.Spec.Template}, nil
+	case "DaemonSet":
+		d := &appsv1.DaemonSet{}
+		return workload{kind: kind, object: d, meta: &d.ObjectMeta, template: &d.Spec.Template}, nil
+	}
+	return workload{}, fmt.Errorf("unsupported rollout kind %q", kind)
+}
+
+// listWorkloads returns every Deployment, StatefulSet and DaemonSet in namespace matching selector.
+func (r *KeychainSecretReconciler) listWorkloads(ctx context.Context, namespace string, selector labels.Selector) ([]workload, error) {
+	opts := []client.ListOption{client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}}
+	var workloads []workload
+
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments, opts...); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		workloads = append(workloads, workload{kind: "Deployment", object: d, meta: &d.ObjectMeta, template: &d.Spec.Template})
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	if err := r.List(ctx, &statefulSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		workloads = append(workloads, workload{kind: "StatefulSet", object: s, meta: &s.ObjectMeta, template: &s.Spec.Template})
+	}
+
+	var daemonSets appsv1.DaemonSetList
+	if err := r.List(ctx, &daemonSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		d := &daemonSets.Items[i]
+		workloads = append(workloads, workload{kind: "DaemonSet", object: d, meta: &d.ObjectMeta, template: &d.Spec.Template})
+	}
+
+	return workloads, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestHashSecretData(t *testing.T) {
+	var testsTable = []struct {
+		name  string
+		a     map[string][]byte
+		b     map[string][]byte
+		equal bool
+	}{
+		{name: "same data hashes equal", a: map[string][]byte{"a": []byte("1"), "b": []byte("2")},
+			b: map[string][]byte{"b": []byte("2"), "a": []byte("1")}, equal: true},
+		{name: "changed value hashes differ", a: map[string][]byte{"a": []byte("1")},
+			b: map[string][]byte{"a": []byte("2")}, equal: false},
+		{name: "key boundaries are significant", a: map[string][]byte{"a": []byte("bc")},
+			b: map[string][]byte{"ab": []byte("c")}, equal: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if equal := HashSecretData(tt.a) == HashSecretData(tt.b); equal != tt.equal {
+				t.Errorf("Hashes equal %v, expected %v", equal, tt.equal)
+			}
+		})
+	}
+}
+
+func TestRolloutWorkloads(t *testing.T) {
+	const hash = "new-hash"
+	labelled := func(app string) map[string]string { return map[string]string{"app": app} }
+	workloads := func() []runtime.Object {
+		return []runtime.Object{
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: labelled("web")}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker", Labels: labelled("worker")}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: labelled("web")}},
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agent", Labels: labelled("web")}},
+			// cache already carries the hash, so it is never patched.
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cache", Labels: labelled("web")},
+				Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{SecretHashAnnotation: hash}}}}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "web", Labels: labelled("web")}},
+		}
+	}
+
+	var testsTable = []struct {
+		name      string
+		rollout   *aqueductv1.RolloutSpec
+		restarted []string
+		valid     bool
+	}{
+		{name: "no rollout spec restarts nothing", valid: true},
+		{name: "targets are selected by kind and name",
+			rollout: &aqueductv1.RolloutSpec{Targets: []aqueductv1.RolloutTarget{
+				{Kind: "Deployment", Name: "web"}, {Kind: "StatefulSet", Name: "web"}}},
+			restarted: []string{"Deployment/default/web", "StatefulSet/default/web"}, valid: true},
+		{name: "missing targets are skipped",
+			rollout:   &aqueductv1.RolloutSpec{Targets: []aqueductv1.RolloutTarget{{Kind: "Deployment", Name: "absent"}}},
+			restarted: []string{}, valid: true},
+		{name: "selector matches every kind in the namespace",
+			rollout:   &aqueductv1.RolloutSpec{Selector: &metav1.LabelSelector{MatchLabels: labelled("web")}},
+			restarted: []string{"DaemonSet/default/agent", "Deployment/default/web", "StatefulSet/default/web"}, valid: true},
+		{name: "workloads already carrying the hash are not patched",
+			rollout:   &aqueductv1.RolloutSpec{Targets: []aqueductv1.RolloutTarget{{Kind: "Deployment", Name: "cache"}}},
+			restarted: []string{}, valid: true},
+		{name: "unsupported kinds are errors",
+			rollout: &aqueductv1.RolloutSpec{Targets: []aqueductv1.RolloutTarget{{Kind: "ReplicaSet", Name: "web"}}},
+			valid:   false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			c := fake.NewFakeClientWithScheme(scheme, workloads()...)
+			r := &KeychainSecretReconciler{Client: c, Log: ctrl.Log}
+			ks := aqueductv1.KeychainSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+				Spec: aqueductv1.KeychainSecretSpec{Rollout: tt.rollout}}
+
+			err := r.RolloutWorkloads(context.Background(), ks, hash)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+
+			// The fake client only assigns a resource version when an object is written, i.e. patched.
+			restarted := []string{}
+			check := func(kind string, meta metav1.ObjectMeta, template corev1.PodTemplateSpec) {
+				if meta.ResourceVersion == "" {
+					return
+				}
+				if template.Annotations[SecretHashAnnotation] != hash {
+					t.Errorf("%s %s/%s patched without the hash annotation", kind, meta.Namespace, meta.Name)
+				}
+				restarted = append(restarted, kind+"/"+meta.Namespace+"/"+meta.Name)
+			}
+			var deployments appsv1.DeploymentList
+			var statefulSets appsv1.StatefulSetList
+			var daemonSets appsv1.DaemonSetList
+			if err := c.List(context.Background(), &deployments); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.List(context.Background(), &statefulSets); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.List(context.Background(), &daemonSets); err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range deployments.Items {
+				check("Deployment", d.ObjectMeta, d.Spec.Template)
+			}
+			for _, s := range statefulSets.Items {
+				check("StatefulSet", s.ObjectMeta, s.Spec.Template)
+			}
+			for _, d := range daemonSets.Items {
+				check("DaemonSet", d.ObjectMeta, d.Spec.Template)
+			}
+			expected := append([]string{}, tt.restarted...)
+			sort.Strings(restarted)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(restarted, expected) {
+				t.Errorf("Restarted %v, expected %v", restarted, expected)
+			}
+		})
+	}
+}
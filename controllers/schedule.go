@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	day = 24 * time.Hour
+
+	// minRefreshInterval is the least time between refreshes ahead of expiry, so values which are fetched already close
+	// to expiring are not refreshed in a tight loop.
+	minRefreshInterval = time.Minute
+)
+
+// RotationWindow restricts rotations to a daily time of day range, e.g. business hours. A window whose End is
+// before its Start wraps around midnight.
+type RotationWindow struct {
+	Start    time.Duration  // Offset from midnight when the window opens
+	End      time.Duration  // Offset from midnight when the window closes
+	Location *time.Location // Time zone the offsets are relative to
+}
+
+// ParseRotationWindow parses a window of the form "HH:MM-HH:MM" in the given location.
+func ParseRotationWindow(window string, location *time.Location) (*RotationWindow, error) {
+	bounds := strings.Split(window, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("rotation window %q is not of the form HH:MM-HH:MM", window)
+	}
+
+	var offsets [2]time.Duration
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("rotation window %q: %v", window, err)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return nil, fmt.Errorf("rotation window %q is empty", window)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	return &RotationWindow{Start: offsets[0], End: offsets[1], Location: location}, nil
+}
+
+// length returns how long the window is open each day.
+func (w *RotationWindow) length() time.Duration {
+	if w.End > w.Start {
+		return w.End - w.Start
+	}
+	return day - w.Start + w.End
+}
+
+// Contains reports whether t falls within the window.
+func (w *RotationWindow) Contains(t time.Time) bool {
+	offset := sinceMidnight(t.In(w.Location))
+	if w.End > w.Start {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Next returns t if it falls within the window. Otherwise it returns a time within the next opening of the window,
+// offset from the opening by spread (a fraction in [0, 1)) of the window length so that deferred rotations do not all
+// fire the moment the window opens.
+func (w *RotationWindow) Next(t time.Time, spread float64) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	local := t.In(w.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.Location)
+	opening := midnight.Add(w.Start)
+	if !opening.After(local) {
+		opening = opening.AddDate(0, 0, 1)
+	}
+
+	return opening.Add(time.Duration(spread * float64(w.length())))
+}
+
+// RotationScheduler decides when KeychainSecrets are rotated. It spreads rotations using a deterministic per-object
+// jitter, optionally restricts them to a RotationWindow, and optionally limits how many rotations happen per minute
+// across all objects. The zero value rotates exactly on TTL.
+type RotationScheduler struct {
+	Jitter  float64         // Fraction of the TTL by which rotations are spread, in [0, 1)
+	Window  *RotationWindow // Optional window rotations are restricted to
+	Limiter *rate.Limiter   // Optional global limit on rotations
+
+	// RefreshBeforeExpiry is the fraction of an expiring value's lifetime before its expiry at which it is refreshed,
+	// in [0, 1). Zero refreshes on expiry.
+	RefreshBeforeExpiry float64
+}
+
+// NewRotationScheduler returns a RotationScheduler. A rotationsPerMinute of zero disables rate limiting.
+func NewRotationScheduler(jitter float64, window *RotationWindow, rotationsPerMinute int) (*RotationScheduler, error) {
+	if jitter < 0 || jitter >= 1 {
+		return nil, fmt.Errorf("rotation jitter %v is not in [0, 1)", jitter)
+	}
+	if rotationsPerMinute < 0 {
+		return nil, fmt.Errorf("rotations per minute %d is negative", rotationsPerMinute)
+	}
+
+	scheduler := &RotationScheduler{Jitter: jitter, Window: window}
+	if rotationsPerMinute > 0 {
+		scheduler.Limiter = rate.NewLimiter(rate.Limit(float64(rotationsPerMinute)/60), rotationsPerMinute)
+	}
+	return scheduler, nil
+}
+
+// NextRotation returns when the object identified by key, last updated at lastUpdate, should next be rotated.
+func (s *RotationScheduler) NextRotation(key types.NamespacedName, lastUpdate time.Time, ttl time.Duration) time.Time {
+	spread := spreadFraction(key)
+
+	// Spread symmetrically around the TTL so the average rotation period is unchanged.
+	next := lastUpdate.Add(ttl + time.Duration((2*spread-1)*s.Jitter*float64(ttl)))
+	if s.Window != nil {
+		next = s.Window.Next(next, spread)
+	}
+	return next
+}
+
+// RefreshPoint returns when a value fetched at lastUpdate, which expires at expiry, should be refreshed.
+func (s *RotationScheduler) RefreshPoint(lastUpdate, expiry time.Time) time.Time {
+	lifetime := expiry.Sub(lastUpdate)
+	return expiry.Add(-time.Duration(s.RefreshBeforeExpiry * float64(lifetime)))
+}
+
+// NextRefresh returns when the object identified by key should next be refreshed: on its NextRotation, or at its
+// RefreshPoint if its value expires and that comes first, but no sooner than a minute after lastUpdate. Values which do
+// not expire have a zero expiry.
+func (s *RotationScheduler) NextRefresh(key types.NamespacedName, lastUpdate time.Time, ttl time.Duration, expiry time.Time) time.Time {
+	next := s.NextRotation(key, lastUpdate, ttl)
+	if expiry.IsZero() {
+		return next
+	}
+	refresh := s.RefreshPoint(lastUpdate, expiry)
+	if earliest := lastUpdate.Add(minRefreshInterval); refresh.Before(earliest) {
+		refresh = earliest
+	}
+	if refresh.Before(next) {
+		return refresh
+	}
+	return next
+}
+
+// ExpiringSoon reports whether a value fetched at lastUpdate, which expires at expiry, is past its RefreshPoint at now.
+// Such values are refreshed regardless of the window and rate limit.
+func (s *RotationScheduler) ExpiringSoon(lastUpdate, expiry, now time.Time) bool {
+	return !expiry.IsZero() && !now.Before(s.RefreshPoint(lastUpdate, expiry))
+}
+
+// Admit reports whether a due rotation may proceed at now. If it may not, it returns how long to wait before asking
+// again.
+func (s *RotationScheduler) Admit(key types.NamespacedName, now time.Time) (bool, time.Duration) {
+	if s.Window != nil && !s.Window.Contains(now) {
+		return false, s.Window.Next(now, spreadFraction(key)).Sub(now)
+	}
+	if s.Limiter != nil {
+		reservation := s.Limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return false, delay
+		}
+	}
+	return true, 0
+}
+
+// spreadFraction deterministically maps key to a fraction in [0, 1), so an object keeps its place in the schedule
+// across controller restarts.
+func spreadFraction(key types.NamespacedName) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key.String()))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// sinceMidnight returns how long after midnight t is, in t's location.
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestNextRotation(t *testing.T) {
+	lastUpdate := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	ttl := 24 * time.Hour
+
+	var testsTable = []struct {
+		name   string
+		jitter float64
+		window string
+		key    types.NamespacedName
+		min    time.Time
+		max    time.Time
+	}{
+		{name: "no jitter rotates on ttl", jitter: 0, key: types.NamespacedName{Namespace: "a", Name: "b"},
+			min: lastUpdate.Add(ttl), max: lastUpdate.Add(ttl)},
+		{name: "jitter stays within bounds", jitter: 0.5, key: types.NamespacedName{Namespace: "a", Name: "b"},
+			min: lastUpdate.Add(ttl / 2), max: lastUpdate.Add(ttl + ttl/2)},
+		{name: "window defers to opening", jitter: 0, window: "14:00-15:00", key: types.NamespacedName{Namespace: "a", Name: "b"},
+			min: lastUpdate.Add(ttl + 2*time.Hour), max: lastUpdate.Add(ttl + 3*time.Hour)},
+		{name: "window wraps midnight", jitter: 0, window: "22:00-02:00", key: types.NamespacedName{Namespace: "a", Name: "b"},
+			min: lastUpdate.Add(ttl + 10*time.Hour), max: lastUpdate.Add(ttl + 14*time.Hour)},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var window *RotationWindow
+			if tt.window != "" {
+				var err error
+				window, err = ParseRotationWindow(tt.window, time.UTC)
+				if err != nil {
+					t.Fatalf("ParseRotationWindow(%q) failed: %v", tt.window, err)
+				}
+			}
+			scheduler, err := NewRotationScheduler(tt.jitter, window, 0)
+			if err != nil {
+				t.Fatalf("NewRotationScheduler failed: %v", err)
+			}
+
+			next := scheduler.NextRotation(tt.key, lastUpdate, ttl)
+			if next.Before(tt.min) || next.After(tt.max) {
+				t.Errorf("Next rotation %v, expected between %v and %v", next, tt.min, tt.max)
+			}
+			if again := scheduler.NextRotation(tt.key, lastUpdate, ttl); !again.Equal(next) {
+				t.Errorf("Next rotation %v, expected deterministic %v", again, next)
+			}
+		})
+	}
+}
+
+func TestAdmitRateLimit(t *testing.T) {
+	scheduler, err := NewRotationScheduler(0, nil, 2)
+	if err != nil {
+		t.Fatalf("NewRotationScheduler failed: %v", err)
+	}
+
+	now := time.Now()
+	key := types.NamespacedName{Namespace: "a", Name: "b"}
+	for i := 0; i < 2; i++ {
+		if ok, _ := scheduler.Admit(key, now); !ok {
+			t.Fatalf("Rotation %d was not admitted", i)
+		}
+	}
+	if ok, wait := scheduler.Admit(key, now); ok || wait <= 0 {
+		t.Errorf("Rotation admitted %v with wait %v, expected to be deferred", ok, wait)
+	}
+}
+
+func TestParseRotationWindow(t *testing.T) {
+	var testsTable = []struct {
+		window string
+		valid  bool
+	}{
+		{window: "09:00-17:00", valid: true},
+		{window: "22:00-02:00", valid: true},
+		{window: "09:00", valid: false},
+		{window: "09:00-09:00", valid: false},
+		{window: "9am-5pm", valid: false},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.window, func(t *testing.T) {
+			_, err := ParseRotationWindow(tt.window, nil)
+			if (err == nil) != tt.valid {
+				t.Errorf("Error observed %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	lastUpdate := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	key := types.NamespacedName{Namespace: "a", Name: "b"}
+	scheduler := &RotationScheduler{RefreshBeforeExpiry: 0.25}
+
+	var testsTable = []struct {
+		name     string
+		ttl      time.Duration
+		expiry   time.Time
+		expected time.Time
+	}{
+		{name: "no expiry rotates on ttl", ttl: time.Hour, expected: lastUpdate.Add(time.Hour)},
+		{name: "expiry before ttl", ttl: 24 * time.Hour, expiry: lastUpdate.Add(4 * time.Hour),
+			expected: lastUpdate.Add(3 * time.Hour)},
+		{name: "ttl before expiry", ttl: time.Hour, expiry: lastUpdate.Add(4 * time.Hour),
+			expected: lastUpdate.Add(time.Hour)},
+		{name: "already expired waits a minute", ttl: time.Hour, expiry: lastUpdate.Add(-time.Hour),
+			expected: lastUpdate.Add(time.Minute)},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if next := scheduler.NextRefresh(key, lastUpdate, tt.ttl, tt.expiry); !next.Equal(tt.expected) {
+				t.Errorf("Next refresh %v, expected %v", next, tt.expected)
+			}
+		})
+	}
+
+	expiry := lastUpdate.Add(4 * time.Hour)
+	if scheduler.ExpiringSoon(lastUpdate, expiry, lastUpdate.Add(2*time.Hour)) {
+		t.Errorf("Values expiring soon halfway through their lifetime")
+	}
+	if !scheduler.ExpiringSoon(lastUpdate, expiry, lastUpdate.Add(3*time.Hour)) {
+		t.Errorf("Values not expiring soon at their refresh point")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+const (
+	// maxStaleRetryDuration bounds the backoff of retries while stale values are served.
+	maxStaleRetryDuration = 15 * time.Minute
+	// staleReason is the reason of the Ready condition once stale values are escalated.
+	staleReason = "Stale"
+)
+
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// serveStale records that the backend failed to refresh the KeychainSecret's values, which its target keeps serving,
+// and schedules a retry with backoff. The failure only escalates, to a False Ready condition, a warning event and the
+// stale metric, once the values have been stale for longer than the grace period. KeychainSecrets without values to
+// serve fail as usual.
+func (r *KeychainSecretReconciler) serveStale(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, reason string, err error, now time.Time) (ctrl.Result, error) {
+	status := &keychainSecret.Status
+	if keychainSecret.Spec.DryRun || status.LastUpdate.IsZero() {
+		return r.fail(ctx, keychainSecret, reason, err)
+	}
+	key := types.NamespacedName{Namespace: keychainSecret.Namespace, Name: keychainSecret.Name}
+	log := r.Log.WithValues("keychainsecret", key)
+
+	if status.StaleSince == nil {
+		status.StaleSince = &metav1.Time{Time: now}
+	}
+	staleFor := now.Sub(status.StaleSince.Time)
+	message := fmt.Sprintf("Serving values synced %s ago: %v", now.Sub(status.LastUpdate.Time).Round(time.Second), err)
+	status.Reason = reason
+	status.Message = err.Error()
+	status.SetCondition(aqueductv1.ConditionStale, corev1.ConditionTrue, reason, message)
+	if staleFor >= r.StaleGracePeriod {
+		if ready := status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != staleReason {
+			log.Error(err, "serving stale values past the grace period", "staleSince", status.StaleSince.Time)
+			r.event(keychainSecret, corev1.EventTypeWarning, "StaleValues", message)
+		}
+		status.SetCondition(aqueductv1.ConditionReady, corev1.ConditionFalse, staleReason, message)
+		stales.set(key, status.LastUpdate.Time)
+	} else {
+		log.Info("serving stale values", "reason", reason, "error", err.Error(), "staleSince", status.StaleSince.Time)
+	}
+	r.setExpiringSoon(keychainSecret, now)
+	if updateErr := r.Status().Update(ctx, keychainSecret); updateErr != nil {
+		log.Error(updateErr, "unable to update KeychainSecret status")
+		return ctrl.Result{RequeueAfter: retryAfterErrorDuration}, updateErr
+	}
+	return ctrl.Result{RequeueAfter: StaleRetryAfter(staleFor)}, nil
+}
+
+// freshen records that the KeychainSecret's values were refreshed, ending any stale period.
+func (r *KeychainSecretReconciler) freshen(keychainSecret *aqueductv1.KeychainSecret) {
+	if keychainSecret.Status.StaleSince != nil {
+		r.Log.Info("values refreshed", "keychainsecret", keychainSecret.Namespace+"/"+keychainSecret.Name,
+			"staleSince", keychainSecret.Status.StaleSince.Time)
+	}
+	keychainSecret.Status.StaleSince = nil
+	if keychainSecret.Status.GetCondition(aqueductv1.ConditionStale) != nil {
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionStale, corev1.ConditionFalse, "Fresh", "Values synced from Keychain")
+	}
+	stales.set(types.NamespacedName{Namespace: keychainSecret.Namespace, Name: keychainSecret.Name}, time.Time{})
+}
+
+// event records an event for the KeychainSecret, if the reconciler has a Recorder.
+func (r *KeychainSecretReconciler) event(keychainSecret *aqueductv1.KeychainSecret, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(keychainSecret, eventType, reason, message)
+	}
+}
+
+// StaleRetryAfter returns how long to wait before retrying to refresh values which have been stale for staleFor. Waits
+// grow with the outage, doubling the time since it began with every retry, from retryAfterErrorDuration up to
+// maxStaleRetryDuration.
+func StaleRetryAfter(staleFor time.Duration) time.Duration {
+	switch {
+	case staleFor < retryAfterErrorDuration:
+		return retryAfterErrorDuration
+	case staleFor > maxStaleRetryDuration:
+		return maxStaleRetryDuration
+	default:
+		return staleFor
+	}
+}
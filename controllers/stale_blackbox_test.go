@@ -0,0 +1,44 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestStaleRetryAfter(t *testing.T) {
+	var testsTable = []struct {
+		staleFor time.Duration
+		expected time.Duration
+	}{
+		{staleFor: 0, expected: time.Minute},
+		{staleFor: 30 * time.Second, expected: time.Minute},
+		{staleFor: 4 * time.Minute, expected: 4 * time.Minute},
+		{staleFor: 2 * time.Hour, expected: 15 * time.Minute},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.staleFor.String(), func(t *testing.T) {
+			if retryAfter := StaleRetryAfter(tt.staleFor); retryAfter != tt.expected {
+				t.Errorf("Retry after %v, expected %v", retryAfter, tt.expected)
+			}
+		})
+	}
+}
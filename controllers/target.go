@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// writeTarget writes data to the KeychainSecret's target, a Secret or ConfigMap, and removes any object of the other
+// kind it previously wrote. The Keychain version of data, if known, names its versioned Secret. It returns the hash of
+// the data written.
+func (r *KeychainSecretReconciler) writeTarget(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, data map[string][]byte, version string) (string, error) {
+	key := client.ObjectKey{Namespace: keychainSecret.ObjectMeta.Namespace, Name: keychainSecret.Spec.Name}
+
+	if keychainSecret.Spec.Versioning != nil {
+		return r.writeVersioned(ctx, keychainSecret, data, version)
+	}
+	if err := r.deleteVersions(ctx, keychainSecret); err != nil {
+		return "", err
+	}
+
+	switch keychainSecret.Spec.TargetKind() {
+	case aqueductv1.TargetConfigMap:
+		configMap, err := r.CreateConfigMapFromKeychain(ctx, *keychainSecret, data)
+		if err != nil {
+			return "", err
+		}
+		if err := r.deleteOwned(ctx, keychainSecret, key, &corev1.Secret{}); err != nil {
+			return "", err
+		}
+		keychainSecret.Status.SecretRef = corev1.SecretReference{}
+		keychainSecret.Status.ConfigMapRef = &corev1.ObjectReference{Kind: "ConfigMap", Namespace: configMap.Namespace, Name: configMap.Name}
+		return HashSecretData(ConfigMapData(configMap)), nil
+
+	default:
+		secret, err := r.CreateSecretFromKeychain(ctx, *keychainSecret, data)
+		if err != nil {
+			return "", err
+		}
+		if err := r.deleteOwned(ctx, keychainSecret, key, &corev1.ConfigMap{}); err != nil {
+			return "", err
+		}
+		keychainSecret.Status.SecretRef = corev1.SecretReference{Namespace: secret.Namespace, Name: secret.Name}
+		keychainSecret.Status.ConfigMapRef = nil
+		return HashSecretData(secret.Data), nil
+	}
+}
+
+// targetData returns the current data of the KeychainSecret's target, and whether it exists.
+func (r *KeychainSecretReconciler) targetData(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret) (map[string][]byte, bool, error) {
+	key := client.ObjectKey{Namespace: keychainSecret.ObjectMeta.Namespace, Name: keychainSecret.Spec.Name}
+	if keychainSecret.Spec.Versioning != nil {
+		// The target is the active version.
+		if keychainSecret.Status.SecretRef.Name == "" {
+			return nil, false, nil
+		}
+		key.Name = keychainSecret.Status.SecretRef.Name
+	}
+
+	switch keychainSecret.Spec.TargetKind() {
+	case aqueductv1.TargetConfigMap:
+		var configMap corev1.ConfigMap
+		if err := r.Get(ctx, key, &configMap); err != nil {
+			return nil, false, client.IgnoreNotFound(err)
+		}
+		return ConfigMapData(&configMap), true, nil
+	default:
+		var secret corev1.Secret
+		if err := r.Get(ctx, key, &secret); err != nil {
+			return nil, false, client.IgnoreNotFound(err)
+		}
+		return secret.Data, true, nil
+	}
+}
+
+// deleteTarget deletes the KeychainSecret's target, and every version of it, as far as they are controlled by the
+// KeychainSecret. Its status no longer refers to them, so they are written again on the next sync.
+func (r *KeychainSecretReconciler) deleteTarget(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret) error {
+	key := client.ObjectKey{Namespace: keychainSecret.ObjectMeta.Namespace, Name: keychainSecret.Spec.Name}
+	if err := r.deleteVersions(ctx, keychainSecret); err != nil {
+		return err
+	}
+	if err := r.deleteOwned(ctx, keychainSecret, key, &corev1.Secret{}); err != nil {
+		return err
+	}
+	if err := r.deleteOwned(ctx, keychainSecret, key, &corev1.ConfigMap{}); err != nil {
+		return err
+	}
+	keychainSecret.Status.SecretRef = corev1.SecretReference{}
+	keychainSecret.Status.ConfigMapRef = nil
+	keychainSecret.Status.ContentHash = ""
+	return nil
+}
+
+// deleteOwned deletes the object with the given key if, and only if, it is controlled by the KeychainSecret.
+func (r *KeychainSecretReconciler) deleteOwned(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, key client.ObjectKey, obj runtime.Object) error {
+	if err := r.Get(ctx, key, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(accessor, keychainSecret) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+// CreateConfigMapFromKeychain creates a ConfigMap corresponding to the KeychainSecret, or updates the existing one, with
+// data. Values which are valid UTF-8 are stored in Data, others in BinaryData. An existing ConfigMap which is not
+// controlled by the KeychainSecret is left alone, and an error wrapping errNotControlled returned.
+func (r *KeychainSecretReconciler) CreateConfigMapFromKeychain(ctx context.Context, keychainSecret aqueductv1.KeychainSecret, data map[string][]byte) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: keychainSecret.ObjectMeta.Namespace,
+			Name:      keychainSecret.Spec.Name,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.ResourceVersion != "" && !metav1.IsControlledBy(configMap, &keychainSecret) {
+			return fmt.Errorf("ConfigMap %s: %w", configMap.Name, errNotControlled)
+		}
+		configMap.Data = map[string]string{}
+		configMap.BinaryData = map[string][]byte{}
+		for key, value := range data {
+			if utf8.Valid(value) {
+				configMap.Data[key] = string(value)
+			} else {
+				configMap.BinaryData[key] = value
+			}
+		}
+		// The ConfigMap is garbage collected along with the KeychainSecret.
+		return controllerutil.SetControllerReference(&keychainSecret, configMap, r.Scheme)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+// ConfigMapData returns the Data and BinaryData of a ConfigMap in one map, as in a Secret.
+func ConfigMapData(configMap *corev1.ConfigMap) map[string][]byte {
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.Data {
+		data[key] = []byte(value)
+	}
+	for key, value := range configMap.BinaryData {
+		data[key] = value
+	}
+	return data
+}
@@ -0,0 +1,49 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/davidewatson/keychain/controllers"
+)
+
+func TestConfigMapData(t *testing.T) {
+	var testsTable = []struct {
+		name      string
+		configMap corev1.ConfigMap
+		secret    map[string][]byte
+	}{
+		{name: "empty", configMap: corev1.ConfigMap{}, secret: map[string][]byte{}},
+		{name: "text data", configMap: corev1.ConfigMap{Data: map[string]string{"a": "1"}},
+			secret: map[string][]byte{"a": []byte("1")}},
+		{name: "text and binary data", configMap: corev1.ConfigMap{Data: map[string]string{"a": "1"},
+			BinaryData: map[string][]byte{"b": {0xff, 0xfe}}},
+			secret: map[string][]byte{"a": []byte("1"), "b": {0xff, 0xfe}}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			// Equal hashes mean a ConfigMap target is in sync with the data it was written from.
+			if got, expected := HashSecretData(ConfigMapData(&tt.configMap)), HashSecretData(tt.secret); got != expected {
+				t.Errorf("ConfigMap data hash %s, expected %s", got, expected)
+			}
+		})
+	}
+}
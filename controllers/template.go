@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"text/template"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+// RequestedSecret is a Keychain secret to fetch, along with the key its value is known by in templates.
+type RequestedSecret struct {
+	Key    string
+	Params GetKeychainSecretParams
+}
+
+// RequestedSecrets returns every Keychain secret the KeychainSecret needs fetched: the one it names, followed by the
+// sources of its template, if any.
+func RequestedSecrets(keychainSecret aqueductv1.KeychainSecret) []RequestedSecret {
+	primary := GetKeychainSecretParams{
+		Group:   keychainSecret.Spec.Group,
+		Name:    keychainSecret.Spec.Name,
+		Version: keychainSecret.Spec.PinnedVersion(),
+	}
+	requested := []RequestedSecret{{Key: keychainSecret.Spec.Name, Params: primary}}
+	if keychainSecret.Spec.Template == nil {
+		return requested
+	}
+	for _, source := range keychainSecret.Spec.Template.Sources {
+		key := source.Key
+		if key == "" {
+			key = source.Name
+		}
+		requested = append(requested, RequestedSecret{
+			Key:    key,
+			Params: GetKeychainSecretParams{Group: source.Group, Name: source.Name},
+		})
+	}
+	return requested
+}
+
+// ValidateRequestedSecrets returns an error unless every requested secret has a valid name, and a valid group or none,
+// see ValidateKeychainName.
+func ValidateRequestedSecrets(requested []RequestedSecret) error {
+	for _, r := range requested {
+		if err := ValidateKeychainName(r.Params.Name); err != nil {
+			return err
+		}
+		if r.Params.Group == "" {
+			continue
+		}
+		if err := ValidateKeychainName(r.Params.Group); err != nil {
+			return fmt.Errorf("group of %s: %v", r.Params.Name, err)
+		}
+	}
+	return nil
+}
+
+// templateFuncs are the functions available to SecretTemplates. None of them have side effects.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"sha256sum": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// pemBlock returns the first PEM block of the given type, e.g. "PRIVATE KEY".
+	"pemBlock": func(blockType, s string) (string, error) {
+		blocks := pemBlocks(s, blockType)
+		if len(blocks) == 0 {
+			return "", fmt.Errorf("no %s PEM block found", blockType)
+		}
+		return blocks[0], nil
+	},
+	// pemCerts returns every certificate, e.g. a chain, concatenated.
+	"pemCerts": func(s string) (string, error) {
+		blocks := pemBlocks(s, "CERTIFICATE")
+		if len(blocks) == 0 {
+			return "", fmt.Errorf("no CERTIFICATE PEM block found")
+		}
+		return strings.Join(blocks, ""), nil
+	},
+}
+
+// pemBlocks returns the PEM encoded blocks of the given type in s.
+func pemBlocks(s, blockType string) []string {
+	var blocks []string
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return blocks
+		}
+		if block.Type == blockType {
+			blocks = append(blocks, string(pem.EncodeToMemory(block)))
+		}
+	}
+}
+
+// RenderSecretTemplate renders each key of a SecretTemplate with values, a map from source key to value.
+func RenderSecretTemplate(secretTemplate *aqueductv1.SecretTemplate, values map[string][]byte) (map[string][]byte, error) {
+	strs := make(map[string]string, len(values))
+	for key, value := range values {
+		strs[key] = string(value)
+	}
+
+	data := make(map[string][]byte, len(secretTemplate.Data))
+	for key, text := range secretTemplate.Data {
+		t, err := template.New(key).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template for %s: %v", key, err)
+		}
+		var buf strings.Builder
+		if err := t.Execute(&buf, strs); err != nil {
+			return nil, fmt.Errorf("template for %s: %v", key, err)
+		}
+		data[key] = []byte(buf.String())
+	}
+	return data, nil
+}
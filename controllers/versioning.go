@@ -0,0 +1,250 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+)
+
+const (
+	// versionLength is how many characters of the content hash make up a version.
+	versionLength = 10
+)
+
+// ContentVersion returns the version of data, a prefix of its content hash.
+func ContentVersion(data map[string][]byte) string {
+	return HashSecretData(data)[:versionLength]
+}
+
+// SecretVersion returns the version of a versioned Secret: the Keychain version if it is known and usable in names and
+// labels, otherwise the ContentVersion.
+func SecretVersion(keychainVersion string, data map[string][]byte) string {
+	if keychainVersion != "" && len(validation.IsDNS1123Label(keychainVersion)) == 0 {
+		return keychainVersion
+	}
+	return ContentVersion(data)
+}
+
+// VersionedSecretName returns the name of the Secret holding the given version.
+func VersionedSecretName(name, version string) string {
+	return name + "-" + version
+}
+
+// writeVersioned writes data, the given Keychain version if known, to a new versioned Secret, unless that version
+// already exists, activates the latest version, or the one rolled back to, and prunes versions beyond the retention
+// limit. The controller only updates the labels of existing versioned Secrets, so their data is not restored if it is
+// edited by others. It returns the hash of the active version's data.
+func (r *KeychainSecretReconciler) writeVersioned(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret, data map[string][]byte, keychainVersion string) (string, error) {
+	if keychainSecret.Spec.TargetKind() != aqueductv1.TargetSecret {
+		return "", fmt.Errorf("versioning is only supported for Secret targets")
+	}
+
+	latest := SecretVersion(keychainVersion, data)
+	secret := NewSecret(*keychainSecret, data)
+	secret.Name = VersionedSecretName(keychainSecret.Spec.Name, latest)
+	secret.Labels = map[string]string{
+		aqueductv1.KeychainSecretLabel: keychainSecret.Name,
+		aqueductv1.VersionLabel:        latest,
+	}
+	if err := controllerutil.SetControllerReference(keychainSecret, secret, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	versions, err := r.listVersions(ctx, keychainSecret)
+	if err != nil {
+		return "", err
+	}
+
+	active := latest
+	if rollback := keychainSecret.Spec.Versioning.ActiveVersion; rollback != "" {
+		active = rollback
+	}
+	var activeSecret *corev1.Secret
+	for i := range versions {
+		if versions[i].Labels[aqueductv1.VersionLabel] == active {
+			activeSecret = &versions[i]
+		}
+	}
+	if activeSecret == nil {
+		return "", fmt.Errorf("version %s of secret %s is not retained", active, keychainSecret.Spec.Name)
+	}
+
+	// Move the alias before pruning, so consumers selecting it never find nothing.
+	for i := range versions {
+		if err := r.setActive(ctx, &versions[i], &versions[i] == activeSecret); err != nil {
+			return "", err
+		}
+	}
+
+	// The latest version comes first even if an older Secret already held the same data.
+	prune := VersionsToPrune(versions, int(keychainSecret.Spec.Versioning.Retain), active, latest)
+	retained := []string{latest}
+	for i := range versions {
+		version := versions[i].Labels[aqueductv1.VersionLabel]
+		if _, ok := prune[version]; !ok {
+			if version != latest {
+				retained = append(retained, version)
+			}
+			continue
+		}
+		if err := r.Delete(ctx, &versions[i]); client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+	}
+
+	// The unversioned Secret, if versioning was just enabled, is no longer needed.
+	key := client.ObjectKey{Namespace: keychainSecret.Namespace, Name: keychainSecret.Spec.Name}
+	if err := r.deleteOwned(ctx, keychainSecret, key, &corev1.Secret{}); err != nil {
+		return "", err
+	}
+
+	keychainSecret.Status.SecretRef = corev1.SecretReference{Namespace: activeSecret.Namespace, Name: activeSecret.Name}
+	keychainSecret.Status.ConfigMapRef = nil
+	keychainSecret.Status.ActiveVersion = active
+	keychainSecret.Status.Versions = retained
+	return HashSecretData(activeSecret.Data), nil
+}
+
+// deleteVersions deletes all versioned Secrets, e.g. once versioning has been disabled.
+func (r *KeychainSecretReconciler) deleteVersions(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret) error {
+	if keychainSecret.Status.ActiveVersion == "" {
+		return nil
+	}
+	versions, err := r.listVersions(ctx, keychainSecret)
+	if err != nil {
+		return err
+	}
+	for i := range versions {
+		if err := r.Delete(ctx, &versions[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	keychainSecret.Status.ActiveVersion = ""
+	keychainSecret.Status.Versions = nil
+	return nil
+}
+
+// versionChangeRequested reports whether the pinned Keychain version is not the one synced, or the version rolled back
+// to, or the latest one if none is, is not the active one. While the version synced is unknown, the pinned one is
+// only synced on schedule, rather than on every reconcile.
+func versionChangeRequested(keychainSecret *aqueductv1.KeychainSecret) bool {
+	pinned := keychainSecret.Spec.PinnedVersion()
+	versionUnknown := keychainSecret.Status.IsConditionTrue(aqueductv1.ConditionVersionUnknown)
+	if pinned != "" && pinned != keychainSecret.Status.CurrentVersion && !versionUnknown {
+		return true
+	}
+	if keychainSecret.Spec.Versioning == nil {
+		return false
+	}
+	active := keychainSecret.Spec.Versioning.ActiveVersion
+	if active == "" && len(keychainSecret.Status.Versions) > 0 {
+		active = keychainSecret.Status.Versions[0]
+	}
+	return active != keychainSecret.Status.ActiveVersion
+}
+
+// setVersionUnknown sets the VersionUnknown condition if looking up versions failed with err, and clears it otherwise.
+func setVersionUnknown(keychainSecret *aqueductv1.KeychainSecret, err error) {
+	if err != nil {
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionVersionUnknown, corev1.ConditionTrue, "VersionFailed", err.Error())
+	} else if keychainSecret.Status.GetCondition(aqueductv1.ConditionVersionUnknown) != nil {
+		keychainSecret.Status.SetCondition(aqueductv1.ConditionVersionUnknown, corev1.ConditionFalse, "VersionKnown", "Version looked up")
+	}
+}
+
+// listVersions returns the versioned Secrets owned by the KeychainSecret, newest first.
+func (r *KeychainSecretReconciler) listVersions(ctx context.Context, keychainSecret *aqueductv1.KeychainSecret) ([]corev1.Secret, error) {
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(keychainSecret.Namespace),
+		client.MatchingLabels{aqueductv1.KeychainSecretLabel: keychainSecret.Name}); err != nil {
+		return nil, err
+	}
+
+	versions := make([]corev1.Secret, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if metav1.IsControlledBy(&secret, keychainSecret) {
+			versions = append(versions, secret)
+		}
+	}
+	sortNewestFirst(versions)
+	return versions, nil
+}
+
+// setActive adds or removes the ActiveLabel on a versioned Secret.
+func (r *KeychainSecretReconciler) setActive(ctx context.Context, secret *corev1.Secret, active bool) error {
+	if _, ok := secret.Labels[aqueductv1.ActiveLabel]; ok == active {
+		return nil
+	}
+	original := secret.DeepCopy()
+	if active {
+		secret.Labels[aqueductv1.ActiveLabel] = "true"
+	} else {
+		delete(secret.Labels, aqueductv1.ActiveLabel)
+	}
+	return r.Patch(ctx, secret, client.MergeFrom(original))
+}
+
+// VersionsToPrune returns the versions of the versioned Secrets which exceed the retention limit. The versions in keep,
+// i.e. the active and latest ones, are never pruned, nor counted against the limit.
+func VersionsToPrune(secrets []corev1.Secret, retain int, keep ...string) map[string]struct{} {
+	kept := map[string]struct{}{}
+	for _, version := range keep {
+		kept[version] = struct{}{}
+	}
+
+	sorted := append([]corev1.Secret(nil), secrets...)
+	sortNewestFirst(sorted)
+
+	prune := map[string]struct{}{}
+	for _, secret := range sorted {
+		version := secret.Labels[aqueductv1.VersionLabel]
+		if _, ok := kept[version]; ok {
+			continue
+		}
+		if retain > 0 {
+			retain--
+			continue
+		}
+		prune[version] = struct{}{}
+	}
+	return prune
+}
+
+// sortNewestFirst sorts Secrets by descending creation time, and name for equal times.
+func sortNewestFirst(secrets []corev1.Secret) {
+	sort.Slice(secrets, func(i, j int) bool {
+		ti, tj := secrets[i].CreationTimestamp, secrets[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return secrets[i].Name < secrets[j].Name
+	})
+}
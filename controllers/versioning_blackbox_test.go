@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2020 Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	aqueductv1 "github.com/davidewatson/keychain/api/v1"
+	. "github.com/davidewatson/keychain/controllers"
+	"github.com/davidewatson/keychain/controllers/keychaintest"
+)
+
+func TestVersionsToPrune(t *testing.T) {
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	version := func(name string, age time.Duration) corev1.Secret {
+		return corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(base.Add(-age)),
+			Labels:            map[string]string{aqueductv1.VersionLabel: name},
+		}}
+	}
+	secrets := []corev1.Secret{version("v1", 4*time.Hour), version("v4", time.Hour), version("v2", 3*time.Hour), version("v3", 2*time.Hour)}
+
+	var testsTable = []struct {
+		name   string
+		retain int
+		keep   []string
+		pruned map[string]struct{}
+	}{
+		{name: "retain all", retain: 3, keep: []string{"v4"}, pruned: map[string]struct{}{}},
+		{name: "prune oldest", retain: 1, keep: []string{"v4"}, pruned: map[string]struct{}{"v1": {}, "v2": {}}},
+		{name: "pinned version is kept", retain: 1, keep: []string{"v1", "v4"}, pruned: map[string]struct{}{"v2": {}}},
+		{name: "retain none", retain: 0, keep: []string{"v4"}, pruned: map[string]struct{}{"v1": {}, "v2": {}, "v3": {}}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if pruned := VersionsToPrune(secrets, tt.retain, tt.keep...); !reflect.DeepEqual(pruned, tt.pruned) {
+				t.Errorf("Pruned %v, expected %v", pruned, tt.pruned)
+			}
+		})
+	}
+}
+
+func TestContentVersion(t *testing.T) {
+	a := ContentVersion(map[string][]byte{"a": []byte("1")})
+	b := ContentVersion(map[string][]byte{"a": []byte("2")})
+	if a == b {
+		t.Errorf("Versions of different data are both %s", a)
+	}
+	if again := ContentVersion(map[string][]byte{"a": []byte("1")}); again != a {
+		t.Errorf("Version %s, expected deterministic %s", again, a)
+	}
+}
+
+func TestSecretVersion(t *testing.T) {
+	data := map[string][]byte{"a": []byte("1")}
+
+	var testsTable = []struct {
+		name     string
+		version  string
+		expected string
+	}{
+		{name: "keychain version is used", version: "42", expected: "42"},
+		{name: "unknown version falls back to content", version: "", expected: ContentVersion(data)},
+		{name: "invalid label falls back to content", version: "V_42", expected: ContentVersion(data)},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if version := SecretVersion(tt.version, data); version != tt.expected {
+				t.Errorf("Version %s, expected %s", version, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReconcileVersioning(t *testing.T) {
+	server := keychaintest.NewServer()
+	defer server.Close()
+	backend, err := NewHTTPBackend(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPBackend failed: %v", err)
+	}
+	r, c := newReconciler(t, backend, &aqueductv1.KeychainSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec: aqueductv1.KeychainSecretSpec{Name: "DB_PASSWORD", TTL: "24h", Version: aqueductv1.LatestVersion,
+			Versioning: &aqueductv1.VersioningSpec{Retain: 3}},
+	})
+	// Values without a Keychain version are named by their content.
+	unversioned := ContentVersion(map[string][]byte{"DB_PASSWORD": []byte("unversioned")})
+
+	// Each step stores a new version, if any, and requests a refresh before reconciling.
+	var testsTable = []struct {
+		name      string
+		put       *HTTPSecret
+		version   string
+		rollback  string
+		active    string
+		current   string
+		available string
+		synced    string
+	}{
+		{name: "unversioned value", put: &HTTPSecret{Value: []byte("unversioned")}, version: aqueductv1.LatestVersion,
+			active: unversioned, synced: "unversioned"},
+		{name: "versioned value", put: &HTTPSecret{Value: []byte("one"), Version: "1"}, version: aqueductv1.LatestVersion,
+			active: "1", current: "1", available: "1", synced: "one"},
+		{name: "rollback while pinned", put: &HTTPSecret{Value: []byte("two"), Version: "2"}, version: "1",
+			rollback: unversioned, active: unversioned, current: "1", available: "2", synced: "unversioned"},
+		{name: "pinned", version: "1", active: "1", current: "1", available: "2", synced: "one"},
+		{name: "latest", version: aqueductv1.LatestVersion, active: "2", current: "2", available: "2", synced: "two"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.put != nil {
+				server.Put("", "DB_PASSWORD", *tt.put)
+			}
+			var keychainSecret aqueductv1.KeychainSecret
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, &keychainSecret); err != nil {
+				t.Fatalf("Get KeychainSecret failed: %v", err)
+			}
+			keychainSecret.Annotations = map[string]string{aqueductv1.RefreshRequestedAnnotation: tt.name}
+			keychainSecret.Spec.Version = tt.version
+			keychainSecret.Spec.Versioning.ActiveVersion = tt.rollback
+			if err := c.Update(context.Background(), &keychainSecret); err != nil {
+				t.Fatalf("Update KeychainSecret failed: %v", err)
+			}
+
+			keychainSecret, _ = reconcile(t, r, c, "db")
+			if ready := keychainSecret.Status.GetCondition(aqueductv1.ConditionReady); ready == nil || ready.Reason != "Synced" {
+				t.Fatalf("Ready %v, expected Synced", ready)
+			}
+			status := keychainSecret.Status
+			if status.ActiveVersion != tt.active || status.CurrentVersion != tt.current || status.AvailableVersion != tt.available {
+				t.Errorf("Active, current and available versions %q, %q and %q, expected %q, %q and %q",
+					status.ActiveVersion, status.CurrentVersion, status.AvailableVersion, tt.active, tt.current, tt.available)
+			}
+			var secret corev1.Secret
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: status.SecretRef.Name}, &secret); err != nil {
+				t.Fatalf("Get active Secret failed: %v", err)
+			}
+			if synced := string(secret.Data["DB_PASSWORD"]); synced != tt.synced {
+				t.Errorf("Synced %q, expected %q", synced, tt.synced)
+			}
+		})
+	}
+}
@@ -17,14 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	aqueductv1 "github.com/davidewatson/keychain/api/v1"
 	"github.com/davidewatson/keychain/controllers"
@@ -45,35 +52,207 @@ func init() {
 
 func main() {
 	var metricsAddr string
+	var healthProbeAddr string
 	var enableLeaderElection bool
+	var rotationJitter float64
+	var rotationWindow string
+	var rotationWindowTimezone string
+	var rotationsPerMinute int
+	var refreshBeforeExpiry float64
+	var backendNames string
+	var backendTimeout time.Duration
+	var staleGracePeriod time.Duration
+	var backendFailureThreshold int
+	var backendOpenDuration time.Duration
+	var httpBackendURL string
+	var httpBackendCAFile string
+	var grpcBackendSocket string
+	var fileBackendRoot string
+	var enableWebhooks bool
+	var defaultDenyAccess bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8081", "The address the /healthz and /readyz endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.Float64Var(&rotationJitter, "rotation-jitter", 0.1,
+		"Fraction of a KeychainSecret's TTL by which its rotations are deterministically spread, in [0, 1).")
+	flag.StringVar(&rotationWindow, "rotation-window", "",
+		"Daily window rotations are restricted to, e.g. 09:00-17:00. Rotations are allowed at any time if empty.")
+	flag.StringVar(&rotationWindowTimezone, "rotation-window-timezone", "UTC",
+		"IANA time zone the rotation window is expressed in.")
+	flag.IntVar(&rotationsPerMinute, "max-rotations-per-minute", 0,
+		"Maximum number of KeychainSecrets rotated per minute across the cluster. Zero means unlimited.")
+	flag.Float64Var(&refreshBeforeExpiry, "refresh-before-expiry", 0.2,
+		"Fraction of an expiring Keychain value's lifetime before its expiry at which it is refreshed, in [0, 1).")
+	flag.StringVar(&backendNames, "backend", "command",
+		"Comma separated backends Keychain secrets are fetched from, in order of preference: command, which runs the "+
+			"*_COMMAND templates, http, grpc or file. Unavailable backends fall back to the next one. KeychainSecrets "+
+			"may select among them in spec.backends.")
+	flag.DurationVar(&backendTimeout, "backend-timeout", 30*time.Second,
+		"Time after which a request to a backend, but the last one, falls back to the next backend. Zero means "+
+			"requests wait for the backends' own timeouts.")
+	flag.IntVar(&backendFailureThreshold, "backend-failure-threshold", 5,
+		"Consecutive failures of a backend after which requests to it are rejected, until a probe succeeds. Zero "+
+			"disables the circuit breakers.")
+	flag.DurationVar(&backendOpenDuration, "backend-open-duration", 30*time.Second,
+		"Time after which a backend whose requests are rejected is probed again.")
+	flag.DurationVar(&staleGracePeriod, "stale-grace-period", time.Hour,
+		"How long targets keep serving values the backend fails to refresh before KeychainSecrets become not Ready, "+
+			"with a warning event and the keychain_secret_stale_age_seconds metric.")
+	flag.StringVar(&httpBackendURL, "http-backend-url", "",
+		"Base URL of the http backend. Requests authenticate with the namespace's identity when it is https.")
+	flag.StringVar(&httpBackendCAFile, "http-backend-ca-file", "",
+		"PEM file of CA certificates the http backend's server certificate is verified with. Defaults to the system roots.")
+	flag.StringVar(&grpcBackendSocket, "grpc-backend-socket", "/var/run/keychain/backend.sock",
+		"Unix socket the grpc backend plugin, e.g. a sidecar, listens on.")
+	flag.StringVar(&fileBackendRoot, "file-backend-root", "/var/run/keychain/secrets",
+		"Directory the file backend reads secrets from, as GROUP/NAME, or NAME for secrets outside of any group.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve admission webhooks. Requires a serving certificate, e.g. from cert-manager, see config/default.")
+	flag.BoolVar(&defaultDenyAccess, "default-deny-access", false,
+		"Deny namespaces no KeychainAccessPolicy applies to. Otherwise they may request any Keychain secret.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "7f063a80.k8s.facebook.com",
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: healthProbeAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "7f063a80.k8s.facebook.com",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var window *controllers.RotationWindow
+	if rotationWindow != "" {
+		location, err := time.LoadLocation(rotationWindowTimezone)
+		if err != nil {
+			setupLog.Error(err, "invalid rotation window timezone")
+			os.Exit(1)
+		}
+		window, err = controllers.ParseRotationWindow(rotationWindow, location)
+		if err != nil {
+			setupLog.Error(err, "invalid rotation window")
+			os.Exit(1)
+		}
+	}
+	scheduler, err := controllers.NewRotationScheduler(rotationJitter, window, rotationsPerMinute)
+	if err != nil {
+		setupLog.Error(err, "invalid rotation schedule")
+		os.Exit(1)
+	}
+	if refreshBeforeExpiry < 0 || refreshBeforeExpiry >= 1 {
+		setupLog.Error(fmt.Errorf("%v is not in [0, 1)", refreshBeforeExpiry), "invalid refresh before expiry")
+		os.Exit(1)
+	}
+	scheduler.RefreshBeforeExpiry = refreshBeforeExpiry
+
+	backend := &controllers.FallbackBackend{Timeout: backendTimeout}
+	configured := map[string]bool{}
+	for _, name := range strings.Split(backendNames, ",") {
+		name = strings.TrimSpace(name)
+		if configured[name] {
+			setupLog.Error(fmt.Errorf("backend %q is listed twice", name), "invalid backend")
+			os.Exit(1)
+		}
+		configured[name] = true
+		named, err := newBackend(name, httpBackendURL, httpBackendCAFile, grpcBackendSocket, fileBackendRoot)
+		if err != nil {
+			setupLog.Error(err, "invalid backend", "backend", name)
+			os.Exit(1)
+		}
+		if backendFailureThreshold > 0 {
+			named = controllers.NewCircuitBreaker(name, named, backendFailureThreshold, backendOpenDuration)
+		}
+		backend.Backends = append(backend.Backends, controllers.NamedBackend{Name: name, Backend: named})
+	}
+
+	// Missing binaries break the pod, while unavailable backends and API servers may recover.
+	healthChecks := map[string]healthz.Checker{
+		"ping":     healthz.Ping,
+		"commands": controllers.CommandsChecker(controllers.GenerateCertCommandEnv),
+	}
+	for name, check := range healthChecks {
+		if err := mgr.AddHealthzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to add health check", "check", name)
+			os.Exit(1)
+		}
+	}
+	readyChecks := map[string]healthz.Checker{
+		"backend":   controllers.BackendChecker(backend),
+		"namespace": controllers.NamespaceChecker(mgr.GetAPIReader(), os.Getenv("CONTROLLER_NAMESPACE")),
+	}
+	for name, check := range readyChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to add ready check", "check", name)
+			os.Exit(1)
+		}
+	}
+
+	accessPolicies := &controllers.AccessPolicyChecker{Reader: mgr.GetClient(), DefaultDeny: defaultDenyAccess}
+
 	if err = (&controllers.KeychainSecretReconciler{
+		Client:           mgr.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("KeychainSecret"),
+		Scheme:           mgr.GetScheme(),
+		Scheduler:        scheduler,
+		Backend:          backend,
+		AccessPolicies:   accessPolicies,
+		Recorder:         mgr.GetEventRecorderFor("keychainsecret-controller"),
+		StaleGracePeriod: staleGracePeriod,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeychainSecret")
+		os.Exit(1)
+	}
+	if err = (&controllers.ClusterKeychainSecretReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("KeychainSecret"),
+		Log:    ctrl.Log.WithName("controllers").WithName("ClusterKeychainSecret"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "KeychainSecret")
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterKeychainSecret")
+		os.Exit(1)
+	}
+	if err = (&controllers.KeychainGroupSecretReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("KeychainGroupSecret"),
+		Scheme:         mgr.GetScheme(),
+		Scheduler:      scheduler,
+		Backend:        backend,
+		AccessPolicies: accessPolicies,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeychainGroupSecret")
 		os.Exit(1)
 	}
+	if err = (&controllers.KeychainPublicationReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("KeychainPublication"),
+		Scheme:         mgr.GetScheme(),
+		Backend:        backend,
+		AccessPolicies: accessPolicies,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeychainPublication")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		mgr.GetWebhookServer().Register(controllers.KeychainSecretValidatorPath, &webhook.Admission{
+			Handler: &controllers.KeychainSecretValidator{AccessPolicies: accessPolicies},
+		})
+		mgr.GetWebhookServer().Register(controllers.ClusterKeychainSecretValidatorPath, &webhook.Admission{
+			Handler: &controllers.ClusterKeychainSecretValidator{AccessPolicies: accessPolicies},
+		})
+		mgr.GetWebhookServer().Register(controllers.KeychainGroupSecretValidatorPath, &webhook.Admission{
+			Handler: &controllers.KeychainGroupSecretValidator{AccessPolicies: accessPolicies},
+		})
+		mgr.GetWebhookServer().Register(controllers.KeychainPublicationValidatorPath, &webhook.Admission{
+			Handler: &controllers.KeychainPublicationValidator{AccessPolicies: accessPolicies},
+		})
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -82,3 +261,30 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// newBackend returns the Backend named in the --backend flag.
+func newBackend(name, httpURL, httpCAFile, grpcSocket, fileRoot string) (controllers.Backend, error) {
+	switch name {
+	case "command":
+		return controllers.CommandBackend{}, nil
+	case "http":
+		var rootCAs *x509.CertPool
+		if httpCAFile != "" {
+			pemData, err := ioutil.ReadFile(httpCAFile)
+			if err != nil {
+				return nil, err
+			}
+			rootCAs = x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(pemData) {
+				return nil, fmt.Errorf("no certificates found in %s", httpCAFile)
+			}
+		}
+		return controllers.NewHTTPBackend(httpURL, rootCAs)
+	case "grpc":
+		return controllers.NewGRPCBackend(grpcSocket)
+	case "file":
+		return controllers.NewFileBackend(fileRoot)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+}